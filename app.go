@@ -3,35 +3,200 @@ package main
 import (
     "github.com/ownode/controllers"
     "github.com/ownode/services"
+    "github.com/ownode/models"
     "github.com/ownode/config"
     "github.com/ownode/policies"
     "github.com/ownode/middlewares"
+    "github.com/ownode/workers"
+    "github.com/ownode/projections"
+    "github.com/ownode/metrics"
+    "github.com/ownode/tracing"
     "github.com/go-martini/martini"
+    "context"
     "net/http"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
 )
 
 
 func main() {
 
+    // load settings from the config file, environment and flags
+    settings, args, err := config.LoadSettings(os.Args[1:], os.Getenv)
+    if err != nil {
+        config.Log().Error(err)
+        os.Exit(2)
+    }
+    if err := settings.Validate(); err != nil {
+        config.Log().Error(err)
+        os.Exit(2)
+    }
+    controllers.Configure(&settings)
+
+    // export trace spans
+    shutdownTracing, err := tracing.Setup(settings.Tracing.Exporter, settings.Tracing.Endpoint, settings.Tracing.File)
+    if err != nil {
+        config.Log().Error(err)
+        os.Exit(2)
+    }
+
     db := &services.DB{}
+    conninfo := settings.Database.DSN
+
+    // connect to the database
+    if _, err := db.Connect(settings.Database.Driver, conninfo, settings.Database.MaxOpenConns, settings.Database.MaxIdleConns); err != nil {
+        config.Log().Error(err)
+        os.Exit(1)
+    }
 
-    // connect to postgres
-    if _, err := db.ConnectToPostgres("user=ned dbname=localdb sslmode=disable"); err != nil {
+    // export the connection pool stats
+    if err := metrics.RegisterDatabase(db.GetPostgresHandle().DB()); err != nil {
         config.Log().Error(err)
+    }
+
+    // `ownode migrate up|down|status` manages the database schema
+    if len(args) > 0 && args[0] == "migrate" {
+        if err := config.RunMigrateCommand(db, args[1:]); err != nil {
+            config.Log().Error(err)
+            os.Exit(1)
+        }
         return
     }
-    config.PostgresAutoMigration(db)
 
-    // create martini object
-    m := martini.Classic()
+    // an in-memory database starts empty every time
+    if settings.Database.Driver == "sqlite3" && conninfo == ":memory:" {
+        if err := config.MigrateUp(db); err != nil {
+            config.Log().Error(err)
+            os.Exit(1)
+        }
+    }
+
+    // the schema must be up to date before serving
+    if err := config.CheckMigrations(db); err != nil {
+        config.Log().Error(err)
+        os.Exit(1)
+    }
+
+    // `ownode rebuild-objects` rebuilds the objects table from the events table
+    if len(args) > 0 && args[0] == "rebuild-objects" {
+        replayed, err := projections.RebuildObjects(db.GetPostgresHandle())
+        if err != nil {
+            config.Log().Error(err)
+            os.Exit(1)
+        }
+        services.Println("Rebuilt objects from", replayed, "events")
+        return
+    }
+
+    // load exchange rates. conversions are unavailable without rates
+    if rates, err := services.LoadRateFile(settings.RatesFile); err != nil {
+        config.Log().Error(err)
+    } else {
+        services.Rates = rates
+    }
+
+    // continue processing object batches interrupted by a restart
+    controllers.ObjectBatch.Resume(db)
+
+    // deliver queued webhook events in the background
+    webhookWorker := workers.NewWebhookWorker(db, config.Log().WithField("worker", "webhook_worker"))
+    webhookWorker.Start()
+
+    // relay wallet events from all instances to wallet event streams
+    walletEventListener := workers.NewWalletEventListener(db, conninfo, services.Events, config.Log().WithField("worker", "wallet_event_listener"))
+    if err := walletEventListener.Start(); err != nil {
+        config.Log().Error(err)
+        controllers.ObjectBatch.Stop()
+        webhookWorker.Stop()
+        db.ClosePostgresHandle()
+        os.Exit(1)
+    }
+
+    server := &http.Server{ Addr: settings.Listen, Handler: newApp(db, controllers.Workers{
+        "webhook_worker": webhookWorker,
+        "wallet_event_listener": walletEventListener,
+    }) }
+    server.RegisterOnShutdown(controllers.Wallet.CloseStreams)
+    err = serve(server, time.Duration(settings.ShutdownTimeout) * time.Second)
+
+    // stop background work once requests are drained, then close the database
+    controllers.ObjectBatch.Stop()
+    walletEventListener.Stop()
+    webhookWorker.Stop()
+    db.ClosePostgresHandle()
+
+    // export the spans of the last requests
+    ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+    defer cancel()
+    if err := shutdownTracing(ctx); err != nil {
+        config.Log().Error(err)
+    }
+
+    if err != nil {
+        config.Log().Error(err)
+        os.Exit(1)
+    }
+}
+
+// serve requests until the process is interrupted or terminated. The server then
+// stops accepting connections and waits up to timeout for requests in flight,
+// and their transactions, to complete
+func serve(server *http.Server, timeout time.Duration) error {
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+    defer signal.Stop(stop)
+
+    failed := make(chan error, 1)
+    go func() {
+        services.Println("Listening on", server.Addr)
+        failed <- server.ListenAndServe()
+    }()
+
+    select {
+    case err := <-failed:
+        return err
+    case sig := <-stop:
+        services.Println("Received", sig, "shutting down")
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    return server.Shutdown(ctx)
+}
+
+// create the http handler of the api. Controllers must be configured first.
+// workers are the background workers checked by the readiness probe
+func newApp(db *services.DB, workers controllers.Workers) http.Handler {
+
+    // create martini object. martini's logger is left out as it logs
+    // unredacted paths, requests are logged by the request context instead
+    r := martini.NewRouter()
+    base := martini.New()
+    base.Use(martini.Recovery())
+    base.Action(r.Handle)
+    m := &martini.ClassicMartini{ Martini: base, Router: r }
     m.Map(db)
-    m.Map(config.Log())
+    m.Map(workers)
+    m.MapTo(models.NewSQLRepos(db.GetPostgresHandle()), (*models.Repos)(nil))
+
+    // trace requests. The request's span is added to its context
+    m.Use(middlewares.Tracing())
 
     // add auxilliary request context as a service.
-    // service is created and added in every new request.
-    // auxilliary request wraps the original request 
-    m.Use(func(c martini.Context, req *http.Request) {
-        c.Map(services.NewAuxRequestContext(c, req))
+    // service is created and added in every new request with
+    // the request's id and log. auxilliary request wraps the original request 
+    m.Use(middlewares.RequestContext())
+
+    // count and time requests by route
+    m.Use(middlewares.Metrics())
+
+    // trace the queries of handlers in the request's trace
+    m.Use(func(c martini.Context, req *http.Request, db *services.DB) {
+        reqDB := db.WithContext(req.Context())
+        c.Map(reqDB)
+        c.MapTo(models.NewSQLRepos(reqDB.GetPostgresHandle()), (*models.Repos)(nil))
     })
 
     // define policies for specific routes
@@ -41,6 +206,9 @@ func main() {
 
     // define routes
     m.Get("/", controllers.APP.Index)
+    m.Get("/healthz", controllers.Health.Healthz)
+    m.Get("/readyz", controllers.Health.Readyz)
+    m.Get("/metrics", metrics.Handler().ServeHTTP)
 
     m.Group("/api", func(r martini.Router) {
         r.Post("/token", controllers.Auth.GetToken)
@@ -48,23 +216,36 @@ func main() {
 
     m.Group("/v1", func(r martini.Router) {
         r.Post("/services", controllers.Service.Create)
+        r.Get("/services", controllers.Service.List)
         r.Get("/services/:id", controllers.Service.Get)
         r.Put("/services/enable_issuer", controllers.Service.EnableIssuer)
         
         r.Post("/identities", controllers.Identity.Create)
+        r.Get("/identities", controllers.Identity.List)
         r.Post("/identities/renew_soul", controllers.Identity.RenewSoul)
         r.Get("/identities/:id", controllers.Identity.Get)
         
         r.Post("/wallets", controllers.Wallet.Create)
+        r.Get("/wallets", controllers.Wallet.ListWallets)
         r.Get("/wallets/:id", controllers.Wallet.Get)
         r.Get("/wallets/:id/objects", controllers.Wallet.List)
         r.Get("/wallets/:id/numbers", controllers.Wallet.Numbers)
+        r.Get("/wallets/:id/numbers/series", controllers.Wallet.NumbersSeries)
+        r.Get("/wallets/:id/statement", controllers.Wallet.Statement)
         r.Put("/wallets/:id/lock", controllers.Wallet.Lock)
         r.Put("/wallets/:id/open", controllers.Wallet.Open)
+        r.Get("/wallets/:id/events", controllers.Wallet.Events)
 
         r.Post("/issuers", controllers.Issuer.Create)
+        r.Get("/issuers", controllers.Issuer.List)
+        r.Get("/issuers/:id/meta_schema", controllers.Issuer.GetMetaSchema)
+        r.Put("/issuers/:id/meta_schema", controllers.Issuer.SetMetaSchema)
+        r.Delete("/issuers/:id/meta_schema", controllers.Issuer.DeleteMetaSchema)
+        r.Get("/issuers/:id/metrics", controllers.Issuer.Metrics)
 
         r.Post("/objects", controllers.Object.Create)
+        r.Post("/objects/batches", controllers.ObjectBatch.Create)
+        r.Get("/objects/batches/:id", controllers.ObjectBatch.Get)
         r.Get("/objects/:id", controllers.Object.Get)
         r.Post("/objects/merge", controllers.Object.Merge)
         r.Post("/objects/divide", controllers.Object.Divide)
@@ -72,7 +253,19 @@ func main() {
         r.Put("/objects/:id/open", controllers.Object.Open)
         r.Put("/objects/:id/lock", controllers.Object.Lock)
         r.Post("/objects/charge", controllers.Object.Charge)
+        r.Post("/objects/:id/redeem_use", controllers.Object.RedeemUse)
+
+        r.Get("/pins/:pin/validate", controllers.Pin.Validate)
+
+        r.Get("/exchange/convert", controllers.Exchange.Convert)
+        r.Post("/exchange/quotes", controllers.Exchange.CreateQuote)
+
+        r.Post("/webhooks", controllers.Webhook.Create)
+        r.Get("/webhooks", controllers.Webhook.List)
+        r.Delete("/webhooks/:id", controllers.Webhook.Delete)
+        r.Get("/webhooks/:id/deliveries", controllers.Webhook.Deliveries)
+        r.Post("/webhooks/deliveries/:id/redeliver", controllers.Webhook.Redeliver)
     })
 
-    m.Run()
-}
\ No newline at end of file
+    return m
+}
@@ -0,0 +1,810 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+    "github.com/ownode/config"
+    "github.com/ownode/controllers"
+    "github.com/ownode/metrics"
+    "github.com/ownode/services"
+    "github.com/ownode/tracing"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "github.com/stretchr/testify/assert"
+    "go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// credentials the controllers currently authorize with
+// TODO: remove once they are read from the access token
+const (
+    testClientID = "kl14zFDq4SHlmmmVNHgLtE0LqCo8BTjyShOH"
+    testWalletID = "55c679145fe09c74ed000001"
+)
+
+type testApp struct {
+    t *testing.T
+    handler http.Handler
+    db *services.DB
+}
+
+// boot the api against a migrated in-memory sqlite database
+func newTestApp(t *testing.T) *testApp {
+    return newTestAppWithDSN(t, ":memory:")
+}
+
+// boot the api against a migrated sqlite database. An in-memory database
+// has a single connection, a database file allows concurrent connections
+func newTestAppWithDSN(t *testing.T, dsn string) *testApp {
+    return newTestAppWithDriver(t, "sqlite3", dsn)
+}
+
+// boot the api against a new schema of the postgres database at TEST_DATABASE_URL.
+// The test is skipped if it is not set. The schema is dropped when the test ends
+func newPostgresTestApp(t *testing.T) *testApp {
+    dsn := os.Getenv("TEST_DATABASE_URL")
+    if dsn == "" {
+        t.Skip("TEST_DATABASE_URL is not set")
+    }
+
+    schema := "ownode_test_" + services.NewObjectID()
+    admin, err := sql.Open("postgres", dsn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+        admin.Close()
+        t.Fatal(err)
+    }
+    t.Cleanup(func() {
+        admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+        admin.Close()
+    })
+
+    // connections use the schema
+    if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+        query := u.Query()
+        query.Set("search_path", schema)
+        u.RawQuery = query.Encode()
+        dsn = u.String()
+    } else {
+        dsn += " search_path=" + schema
+    }
+    return newTestAppWithDriver(t, "postgres", dsn)
+}
+
+// boot the api against a migrated database
+func newTestAppWithDriver(t *testing.T, driver, dsn string) *testApp {
+    settings := config.DefaultSettings()
+    settings.Database.Driver = driver
+    settings.Database.DSN = dsn
+    settings.Auth.SigningKey = "0123456789abcdef"
+    settings.Auth.BackOfficeSecret = "secret"
+    controllers.Configure(&settings)
+
+    db := &services.DB{}
+    if _, err := db.Connect(settings.Database.Driver, settings.Database.DSN, 0, 0); err != nil {
+        t.Fatal(err)
+    }
+    if err := config.MigrateUp(db); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(db.ClosePostgresHandle)
+    return &testApp{ t: t, handler: newApp(db, controllers.Workers{}), db: db }
+}
+
+// send a request with a json body and decode the json response into out.
+// Returns the status of the response
+func (a *testApp) do(method, path string, body interface{}, out interface{}) int {
+    data := ""
+    if body != nil {
+        encoded, _ := json.Marshal(body)
+        data = string(encoded)
+    }
+    req, _ := http.NewRequest(method, path, strings.NewReader(data))
+    req.Header.Set("Content-Type", "application/json")
+    res := httptest.NewRecorder()
+    a.handler.ServeHTTP(res, req)
+    if out != nil {
+        if err := json.Unmarshal(res.Body.Bytes(), out); err != nil {
+            a.t.Fatalf("%s %s: invalid response %q", method, path, res.Body.String())
+        }
+    }
+    return responseStatus(res)
+}
+
+// status of a response. api errors are sent with status 200 and carry their status in the body
+func responseStatus(res *httptest.ResponseRecorder) int {
+    var apiError services.APIError
+    if json.Unmarshal(res.Body.Bytes(), &apiError) == nil && apiError.Error.Status != 0 {
+        return apiError.Error.Status
+    }
+    return res.Code
+}
+
+// send a request that is expected to fail and return the status and error type
+func (a *testApp) fail(method, path string, body interface{}) (int, string) {
+    var resp struct {
+        Error services.ErrorContent `json:"error"`
+    }
+    status := a.do(method, path, body, &resp)
+    return status, resp.Error.Type
+}
+
+// get a back office access token
+func (a *testApp) backOfficeToken() string {
+    req, _ := http.NewRequest("POST", "/api/token", strings.NewReader("grant_type=client_credentials"))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.SetBasicAuth("backoffice", "secret")
+    res := httptest.NewRecorder()
+    a.handler.ServeHTTP(res, req)
+    var token struct {
+        Token string `json:"token"`
+    }
+    if err := json.Unmarshal(res.Body.Bytes(), &token); err != nil || token.Token == "" {
+        a.t.Fatalf("no back office token: %q", res.Body.String())
+    }
+    return token.Token
+}
+
+// list a collection with an access token and return the ids of the results
+func (a *testApp) list(path, token string) []string {
+    req, _ := http.NewRequest("GET", path, nil)
+    req.Header.Set("Authorization", "Bearer " + token)
+    res := httptest.NewRecorder()
+    a.handler.ServeHTTP(res, req)
+    var page struct {
+        Results []map[string]interface{} `json:"results"`
+    }
+    if err := json.Unmarshal(res.Body.Bytes(), &page); err != nil {
+        a.t.Fatalf("GET %s: invalid response %q", path, res.Body.String())
+    }
+    return objectIDs(page.Results)
+}
+
+func (a *testApp) exec(sql string, values ...interface{}) {
+    if err := a.db.GetPostgresHandle().Exec(sql, values...).Error; err != nil {
+        a.t.Fatal(err)
+    }
+}
+
+// soul balance of an issuer plus the balance of every object. Constant as objects change
+func (a *testApp) totalValue(identityID string) float64 {
+    var identity map[string]interface{}
+    a.do("GET", "/v1/identities/" + identityID, nil, &identity)
+    var balance float64
+    if err := a.db.GetPostgresHandle().Raw("SELECT COALESCE(SUM(balance), 0) FROM objects").Row().Scan(&balance); err != nil {
+        a.t.Fatal(err)
+    }
+    return identity["soul_balance"].(float64) + balance
+}
+
+func objectIDs(objects []map[string]interface{}) []string {
+    ids := []string{}
+    for _, o := range objects {
+        ids = append(ids, o["id"].(string))
+    }
+    return ids
+}
+
+func TestObjectLifecycle(t *testing.T) {
+    assert := assert.New(t)
+    app := newTestApp(t)
+
+    // create a service and authorize with it
+    var service map[string]interface{}
+    status := app.do("POST", "/v1/services", map[string]string{ "full_name": "Gold Shop", "service_name": "gold", "description": "sells gold", "email": "gold@shop.com" }, &service)
+    assert.Equal(200, status)
+    serviceID := service["id"].(string)
+    identityID := service["identity"].(map[string]interface{})["id"].(string)
+    app.exec("UPDATE services SET client_id = ? WHERE object_id = ?", testClientID, serviceID)
+
+    // enable issuer
+    status, errType := app.fail("PUT", "/v1/services/enable_issuer", map[string]string{ "service_id": serviceID, "object_name": "gold" })
+    assert.Equal(400, status)
+    assert.Equal("missing_parameter", errType)
+    status = app.do("PUT", "/v1/services/enable_issuer", map[string]string{ "service_id": serviceID, "object_name": "gold", "base_currency": "USD" }, &service)
+    assert.Equal(200, status)
+    assert.Equal(true, service["identity"].(map[string]interface{})["issuer"])
+
+    // renew soul
+    var identity map[string]interface{}
+    status = app.do("POST", "/v1/identities/renew_soul", map[string]interface{}{ "identity_id": identityID, "soul_balance": 1000 }, &identity)
+    assert.Equal(200, status)
+    assert.Equal(1000.0, identity["soul_balance"])
+    status, errType = app.fail("POST", "/v1/identities/renew_soul", map[string]interface{}{ "identity_id": "unknown", "soul_balance": 10 })
+    assert.Equal(404, status)
+    assert.Equal("invalid_identity", errType)
+
+    // create the authorizing wallet and a shop wallet to charge to
+    var wallet, shop map[string]interface{}
+    assert.Equal(200, app.do("POST", "/v1/wallets", map[string]string{ "identity_id": identityID, "handle": "john", "password": "secret1" }, &wallet))
+    app.exec("UPDATE wallets SET object_id = ? WHERE object_id = ?", testWalletID, wallet["id"])
+    assert.Equal(200, app.do("POST", "/v1/wallets", map[string]string{ "identity_id": identityID, "handle": "shop", "password": "secret1" }, &shop))
+    status, errType = app.fail("POST", "/v1/wallets", map[string]string{ "identity_id": identityID, "handle": "john", "password": "secret1" })
+    assert.Equal(400, status)
+    assert.Equal("handle_registered", errType)
+
+    // create objects. soul balance pays for their value
+    var created []map[string]interface{}
+    status = app.do("POST", "/v1/objects", map[string]interface{}{ "type": "obj_value", "wallet_id": testWalletID, "number_objects": 2, "unit_per_object": 100 }, &created)
+    assert.Equal(200, status)
+    assert.Equal(2, len(created))
+    assert.Equal(100.0, created[0]["balance"])
+    status, errType = app.fail("POST", "/v1/objects", map[string]interface{}{ "type": "obj_value", "wallet_id": testWalletID, "number_objects": 100, "unit_per_object": 100 })
+    assert.Equal(400, status)
+    assert.Equal("insufficient_soul_balance", errType)
+    assert.Equal(1000.0, app.totalValue(identityID))
+
+    // merge
+    var merged map[string]interface{}
+    assert.Equal(200, app.do("POST", "/v1/objects/merge", map[string]interface{}{ "objects": objectIDs(created) }, &merged))
+    assert.Equal(200.0, merged["balance"])
+    status, _ = app.fail("GET", "/v1/objects/" + created[0]["id"].(string), nil)
+    assert.Equal(404, status)
+    assert.Equal(1000.0, app.totalValue(identityID))
+
+    // divide
+    status, errType = app.fail("POST", "/v1/objects/divide", map[string]interface{}{ "object": merged["id"], "amounts": []float64{ 100, 50 } })
+    assert.Equal(400, status)
+    assert.Equal("invalid_parameter", errType)
+    var parts []map[string]interface{}
+    assert.Equal(200, app.do("POST", "/v1/objects/divide", map[string]interface{}{ "object": merged["id"], "num_objects": 4 }, &parts))
+    assert.Equal(4, len(parts))
+    for _, part := range parts {
+        assert.Equal(50.0, part["balance"])
+    }
+    assert.Equal(1000.0, app.totalValue(identityID))
+
+    // subtract
+    var subtracted, source map[string]interface{}
+    status, errType = app.fail("POST", "/v1/objects/subtract", map[string]interface{}{ "object": parts[3]["id"], "amount": 60 })
+    assert.Equal(400, status)
+    assert.Equal("invalid_parameter", errType)
+    assert.Equal(200, app.do("POST", "/v1/objects/subtract", map[string]interface{}{ "object": parts[3]["id"], "amount": 20 }, &subtracted))
+    assert.Equal(20.0, subtracted["balance"])
+    app.do("GET", "/v1/objects/" + parts[3]["id"].(string), nil, &source)
+    assert.Equal(30.0, source["balance"])
+    assert.Equal(1000.0, app.totalValue(identityID))
+
+    // open with every method
+    path := func(object map[string]interface{}, action string) string {
+        return fmt.Sprintf("/v1/objects/%s/%s", object["id"], action)
+    }
+    var opened map[string]interface{}
+    status, _ = app.fail("PUT", path(parts[0], "open"), map[string]interface{}{ "open_method": "open_forever" })
+    assert.Equal(400, status)
+    assert.Equal(200, app.do("PUT", path(parts[0], "open"), map[string]interface{}{ "open_method": "open" }, &opened))
+    assert.Equal(true, opened["open"])
+    status, _ = app.fail("PUT", path(parts[1], "open"), map[string]interface{}{ "open_method": "open_timed", "time": time.Now().Add(-time.Hour).Unix() })
+    assert.Equal(400, status)
+    assert.Equal(200, app.do("PUT", path(parts[1], "open"), map[string]interface{}{ "open_method": "open_timed", "time": time.Now().Add(time.Hour).Unix() }, &opened))
+    assert.Equal("open_timed", opened["open_method"])
+    status, _ = app.fail("PUT", path(parts[3], "open"), map[string]interface{}{ "open_method": "open_pin", "pin": "12a4" })
+    assert.Equal(400, status)
+    assert.Equal(200, app.do("PUT", path(parts[3], "open"), map[string]interface{}{ "open_method": "open_pin", "pin": "1234" }, &opened))
+    assert.Equal("open_pin", opened["open_method"])
+
+    // lock
+    var locked map[string]interface{}
+    assert.Equal(200, app.do("PUT", path(parts[2], "open"), map[string]interface{}{ "open_method": "open" }, &opened))
+    assert.Equal(200, app.do("PUT", path(parts[2], "lock"), nil, &locked))
+    assert.Equal(false, locked["open"])
+
+    // charge. locked objects, a wrong pin or too little balance fail
+    charge := func(ids []string, amount float64, pins map[string]int) map[string]interface{} {
+        return map[string]interface{}{ "ids": ids, "wallet_id": shop["id"], "amount": amount, "pins": pins }
+    }
+    ids := []string{ parts[0]["id"].(string), parts[1]["id"].(string), parts[3]["id"].(string) }
+    status, errType = app.fail("POST", "/v1/objects/charge", charge([]string{ parts[2]["id"].(string) }, 10, nil))
+    assert.Equal(402, status)
+    assert.Equal("object_error", errType)
+    status, _ = app.fail("POST", "/v1/objects/charge", charge(ids, 120, map[string]int{ ids[2]: 4321 }))
+    assert.Equal(402, status)
+    status, _ = app.fail("POST", "/v1/objects/charge", charge(ids, 200, map[string]int{ ids[2]: 1234 }))
+    assert.Equal(402, status)
+
+    var charged map[string]interface{}
+    assert.Equal(200, app.do("POST", "/v1/objects/charge", charge(ids, 120, map[string]int{ ids[2]: 1234 }), &charged))
+    assert.Equal(120.0, charged["balance"])
+    assert.Equal(shop["id"], charged["wallet"].(map[string]interface{})["id"])
+    assert.Equal(1000.0, app.totalValue(identityID))
+
+    // the charged objects hold what was not charged
+    remaining := 0.0
+    for _, id := range ids {
+        var object map[string]interface{}
+        if app.do("GET", "/v1/objects/" + id, nil, &object) == 200 {
+            remaining += object["balance"].(float64)
+        }
+    }
+    assert.Equal(10.0, remaining)
+}
+
+// filter a list by creation date. Dates are compared as times, so the filter
+// matches the stored dates on sqlite too
+func TestListDateFilters(t *testing.T) {
+    assert := assert.New(t)
+    app := newTestApp(t)
+
+    var old, recent map[string]interface{}
+    app.do("POST", "/v1/services", map[string]string{ "full_name": "Old Shop", "service_name": "old", "description": "sells old things", "email": "old@shop.com" }, &old)
+    app.do("POST", "/v1/services", map[string]string{ "full_name": "New Shop", "service_name": "new", "description": "sells new things", "email": "new@shop.com" }, &recent)
+    app.exec("UPDATE services SET created_at = ? WHERE object_id = ?", time.Now().UTC().AddDate(0, 0, -2), old["id"])
+
+    token := app.backOfficeToken()
+    yesterday := time.Now().UTC().AddDate(0, 0, -1).Unix()
+    assert.Equal([]string{ recent["id"].(string) }, app.list(fmt.Sprintf("/v1/services?filter_gte_date_created=%d", yesterday), token))
+    assert.Equal([]string{ old["id"].(string) }, app.list(fmt.Sprintf("/v1/services?filter_lte_date_created=%d", yesterday), token))
+}
+
+// filter the objects of a wallet by meta fields. Runs on sqlite, where
+// meta values are read with json_extract
+func TestWalletObjectsMetaFilter(t *testing.T) {
+    assert := assert.New(t)
+    app := newTestApp(t)
+    identityID, _, _ := app.seedOpenObjects()
+    app.exec("UPDATE identities SET soul_balance = 100 WHERE object_id = ?", identityID)
+
+    var objects []map[string]interface{}
+    metas := []string{ `{"order_id":123,"event":{"seat":"A1"},"paid":true}`, `{"order_id":124,"event":{"seat":"B2"},"paid":false}` }
+    for _, meta := range metas {
+        var created []map[string]interface{}
+        assert.Equal(200, app.do("POST", "/v1/objects", map[string]interface{}{ "type": "obj_value", "wallet_id": testWalletID, "number_objects": 1, "unit_per_object": 10, "meta": json.RawMessage(meta) }, &created))
+        objects = append(objects, created...)
+    }
+
+    filter := func(query string) []string {
+        var page struct {
+            Results []map[string]interface{} `json:"results"`
+        }
+        assert.Equal(200, app.do("GET", "/v1/wallets/" + testWalletID + "/objects?" + query, nil, &page))
+        return objectIDs(page.Results)
+    }
+    assert.Equal([]string{ objects[0]["id"].(string) }, filter("meta.order_id=123"))
+    assert.Equal([]string{ objects[1]["id"].(string) }, filter("meta.event.seat=B2"))
+    assert.Equal([]string{ objects[0]["id"].(string) }, filter("meta.paid=true"))
+    assert.Equal([]string{}, filter("meta.order_id=999"))
+
+    status, _ := app.fail("GET", "/v1/wallets/" + testWalletID + "/objects?meta.order-id=1", nil)
+    assert.Equal(400, status)
+}
+
+// divide objects into equal parts at the precision of their currency and
+// into the wallets of their issuer
+func TestDivide(t *testing.T) {
+    assert := assert.New(t)
+    app := newTestApp(t)
+    identityID, shopID, ids := app.seedOpenObjects()
+
+    // 100 USD in 3 parts
+    var parts []map[string]interface{}
+    assert.Equal(200, app.do("POST", "/v1/objects/divide", map[string]interface{}{ "object": ids[0], "num_objects": 3 }, &parts))
+    assert.Equal(3, len(parts))
+    assert.Equal([]interface{}{ 33.33, 33.33, 33.34 }, []interface{}{ parts[0]["balance"], parts[1]["balance"], parts[2]["balance"] })
+    assert.Equal(1000.0, app.totalValue(identityID))
+
+    // amounts must sum to the balance exactly at the currency's precision
+    status, _ := app.fail("POST", "/v1/objects/divide", map[string]interface{}{ "object": ids[1], "amounts": []float64{ 50, 49.999999 } })
+    assert.Equal(400, status)
+
+    // a wallet of another issuer cannot receive a part
+    var other map[string]interface{}
+    app.do("POST", "/v1/services", map[string]string{ "full_name": "Silver Shop", "service_name": "silver", "description": "sells silver", "email": "silver@shop.com" }, &other)
+    var otherWallet map[string]interface{}
+    assert.Equal(200, app.do("POST", "/v1/wallets", map[string]string{ "identity_id": other["identity"].(map[string]interface{})["id"].(string), "handle": "jane", "password": "secret1" }, &otherWallet))
+    status, _ = app.fail("POST", "/v1/objects/divide", map[string]interface{}{ "object": ids[1], "amounts": []float64{ 60, 40 }, "wallets": []string{ "", otherWallet["id"].(string) } })
+    assert.Equal(400, status)
+    status, _ = app.fail("POST", "/v1/objects/divide", map[string]interface{}{ "object": ids[1], "amounts": []float64{ 60, 40 }, "wallets": []string{ "", "unknown" } })
+    assert.Equal(404, status)
+
+    // a part moves to the shop wallet
+    assert.Equal(200, app.do("POST", "/v1/objects/divide", map[string]interface{}{ "object": ids[1], "amounts": []float64{ 60, 40 }, "wallets": []string{ "", shopID } }, &parts))
+    assert.Equal(testWalletID, parts[0]["wallet"].(map[string]interface{})["id"])
+    assert.Equal(shopID, parts[1]["wallet"].(map[string]interface{})["id"])
+    assert.Equal(40.0, parts[1]["balance"])
+    assert.Equal(1000.0, app.totalValue(identityID))
+
+    // locked wallets cannot receive parts
+    app.exec("UPDATE wallets SET lock = ? WHERE object_id = ?", true, shopID)
+    status, _ = app.fail("POST", "/v1/objects/divide", map[string]interface{}{ "object": ids[2], "amounts": []float64{ 60, 40 }, "wallets": []string{ "", shopID } })
+    assert.Equal(400, status)
+}
+
+// charge objects of another currency with quotes. Expired and used quotes and
+// objects not of the quoted currency are refused and nothing is charged
+func TestChargeWithQuote(t *testing.T) {
+    assert := assert.New(t)
+    app := newTestApp(t)
+
+    rates, err := services.ParseRates([]byte(`{"base":"USD","time":1439424000,"rates":{"EUR":0.5}}`))
+    assert.Nil(err)
+    defer func(rates services.RateProvider) { services.Rates = rates }(services.Rates)
+    services.Rates = rates
+
+    // an issuer of each currency with a soul balance of 1000
+    issuer := func(name, currency string) (map[string]interface{}, string) {
+        var service map[string]interface{}
+        app.do("POST", "/v1/services", map[string]string{ "full_name": name + " Shop", "service_name": name, "description": "sells " + name, "email": name + "@shop.com" }, &service)
+        identityID := service["identity"].(map[string]interface{})["id"].(string)
+        app.exec("UPDATE services SET client_id = ? WHERE object_id = ?", testClientID, service["id"])
+        assert.Equal(200, app.do("PUT", "/v1/services/enable_issuer", map[string]string{ "service_id": service["id"].(string), "object_name": name, "base_currency": currency }, nil))
+        assert.Equal(200, app.do("POST", "/v1/identities/renew_soul", map[string]interface{}{ "identity_id": identityID, "soul_balance": 1000 }, nil))
+        return service, identityID
+    }
+    euro, euroIdentityID := issuer("euro", "EUR")
+
+    // the authorizing wallet and a shop wallet
+    var wallet, shop map[string]interface{}
+    app.do("POST", "/v1/wallets", map[string]string{ "identity_id": euroIdentityID, "handle": "john", "password": "secret1" }, &wallet)
+    app.exec("UPDATE wallets SET object_id = ? WHERE object_id = ?", testWalletID, wallet["id"])
+    app.do("POST", "/v1/wallets", map[string]string{ "identity_id": euroIdentityID, "handle": "shop", "password": "secret1" }, &shop)
+
+    // open objects of 100 issued by the authorizing service
+    createObjects := func(n int) []string {
+        var objects []map[string]interface{}
+        assert.Equal(200, app.do("POST", "/v1/objects", map[string]interface{}{ "type": "obj_value", "wallet_id": testWalletID, "number_objects": n, "unit_per_object": 100 }, &objects))
+        for _, object := range objects {
+            assert.Equal(200, app.do("PUT", fmt.Sprintf("/v1/objects/%s/open", object["id"]), map[string]interface{}{ "open_method": "open" }, nil))
+        }
+        return objectIDs(objects)
+    }
+    euroIDs := createObjects(3)
+
+    // the dollar issuer charges from here on
+    app.exec("UPDATE services SET client_id = ? WHERE object_id = ?", "euro-client", euro["id"])
+    dollar, dollarIdentityID := issuer("dollar", "USD")
+    dollarIDs := createObjects(1)
+
+    quote := func() map[string]interface{} {
+        var quote map[string]interface{}
+        assert.Equal(200, app.do("POST", "/v1/exchange/quotes", map[string]interface{}{ "from": "EUR", "amount": 10 }, &quote))
+        return quote
+    }
+    charge := func(ids []string, quote map[string]interface{}) map[string]interface{} {
+        return map[string]interface{}{ "ids": ids, "wallet_id": shop["id"], "amount": 10, "quote_id": quote["id"] }
+    }
+
+    // an expired quote
+    expired := quote()
+    app.exec("UPDATE quotes SET expires_at = ? WHERE object_id = ?", time.Now().UTC().Add(-time.Minute), expired["id"])
+    status, errType := app.fail("POST", "/v1/objects/charge", charge(euroIDs[:1], expired))
+    assert.Equal(402, status)
+    assert.Equal("quote_error", errType)
+
+    // objects not of the quoted currency
+    status, errType = app.fail("POST", "/v1/objects/charge", charge(dollarIDs, quote()))
+    assert.Equal(402, status)
+    assert.Equal("object_error", errType)
+
+    // a quote is used once
+    used := quote()
+    var charged map[string]interface{}
+    assert.Equal(200, app.do("POST", "/v1/objects/charge", charge(euroIDs[:1], used), &charged))
+
+    // the shop receives an object of the charging issuer for the quoted amount
+    var destination map[string]interface{}
+    assert.Equal(200, app.do("GET", "/v1/objects/" + charged["id"].(string), nil, &destination))
+    assert.Equal(dollar["id"], destination["service"].(map[string]interface{})["id"])
+    assert.Equal(shop["id"], destination["wallet"].(map[string]interface{})["id"])
+    assert.Equal(10.0, destination["balance"])
+
+    // the charging issuer backs the new object and the source issuer gets back the source amount
+    var identity map[string]interface{}
+    assert.Equal(200, app.do("GET", "/v1/identities/" + dollarIdentityID, nil, &identity))
+    assert.InDelta(1000 - 100 - 10, identity["soul_balance"], 0.000001)
+    assert.Equal(200, app.do("GET", "/v1/identities/" + euroIdentityID, nil, &identity))
+    assert.InDelta(1000 - 300 + used["source_amount"].(float64), identity["soul_balance"], 0.000001)
+
+    status, errType = app.fail("POST", "/v1/objects/charge", charge(euroIDs[1:2], used))
+    assert.Equal(402, status)
+    assert.Equal("quote_error", errType)
+
+    // the charging issuer must have the soul balance to issue the quoted amount
+    app.exec("UPDATE identities SET soul_balance = 5 WHERE object_id = ?", dollarIdentityID)
+    status, errType = app.fail("POST", "/v1/objects/charge", charge(euroIDs[1:2], quote()))
+    assert.Equal(402, status)
+    assert.Equal("insufficient_soul_balance", errType)
+
+    // only the charge with a valid quote moved value
+    var source map[string]interface{}
+    assert.Equal(200, app.do("GET", "/v1/objects/" + euroIDs[0], nil, &source))
+    assert.InDelta(100 - used["source_amount"].(float64), source["balance"], 0.000001)
+    for _, id := range append([]string{ euroIDs[1], euroIDs[2] }, dollarIDs...) {
+        assert.Equal(200, app.do("GET", "/v1/objects/" + id, nil, &source))
+        assert.Equal(100.0, source["balance"])
+    }
+}
+
+// charge the same objects from many requests at once. Every charge either
+// succeeds or is refused and no value is created or lost
+func TestParallelCharges(t *testing.T) {
+    assert := assert.New(t)
+    app := newTestAppWithDSN(t, filepath.Join(t.TempDir(), "ownode.db"))
+
+    identityID, shopID, ids := app.seedOpenObjects()
+
+    // each request charges 15 from a pair of objects. Pairs overlap, so
+    // every object is charged by several requests at once
+    var mu sync.Mutex
+    statuses := map[int]int{}
+    var wg sync.WaitGroup
+    for i := 0; i < 60; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            pair := []string{ ids[i % len(ids)], ids[(i + 1) % len(ids)] }
+            status := app.do("POST", "/v1/objects/charge", map[string]interface{}{ "ids": pair, "wallet_id": shopID, "amount": 15 }, nil)
+            mu.Lock()
+            statuses[status]++
+            mu.Unlock()
+        }(i)
+    }
+    wg.Wait()
+
+    // charges fail only because objects were used up
+    for status := range statuses {
+        assert.Contains([]int{ 200, 402, 404 }, status)
+    }
+    assert.True(statuses[200] > 0)
+
+    // the shop holds exactly what was charged and the total value is unchanged
+    app.assertConserved(identityID, shopID, float64(statuses[200]) * 15)
+}
+
+// redeem the uses of a ticket from concurrent requests. Every use is redeemed
+// once and the requests left without a use are refused
+func TestParallelRedeems(t *testing.T) {
+    assert := assert.New(t)
+    app := newTestAppWithDSN(t, filepath.Join(t.TempDir(), "ownode.db"))
+    app.seedOpenObjects()
+
+    var tickets []map[string]interface{}
+    assert.Equal(200, app.do("POST", "/v1/objects", map[string]interface{}{ "type": "obj_valueless", "wallet_id": testWalletID, "number_objects": 1, "max_uses": 3 }, &tickets))
+    ticketID := tickets[0]["id"].(string)
+
+    var mu sync.Mutex
+    statuses := map[int]int{}
+    var wg sync.WaitGroup
+    for i := 0; i < 12; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            status := app.do("POST", "/v1/objects/" + ticketID + "/redeem_use", nil, nil)
+            mu.Lock()
+            statuses[status]++
+            mu.Unlock()
+        }()
+    }
+    wg.Wait()
+
+    assert.Equal(map[int]int{ 200: 3, 402: 9 }, statuses)
+    var ticket map[string]interface{}
+    assert.Equal(200, app.do("GET", "/v1/objects/" + ticketID, nil, &ticket))
+    assert.Equal(3.0, ticket["uses"])
+    var uses int64
+    assert.Nil(app.db.GetPostgresHandle().Raw("SELECT COUNT(*) FROM object_uses").Row().Scan(&uses))
+    assert.Equal(int64(3), uses)
+}
+
+// merge and charge the same objects from many requests at once on postgres,
+// where transactions run concurrently. Requests that conflict are retried and
+// no value is created or lost. Requires TEST_DATABASE_URL
+func TestParallelMergesAndCharges(t *testing.T) {
+    assert := assert.New(t)
+    app := newPostgresTestApp(t)
+    identityID, shopID, ids := app.seedOpenObjects()
+    retriesBefore := testutil.ToFloat64(metrics.TransactionRetries)
+
+    // requests alternate between charging 15 from a pair of objects and merging a pair
+    var mu sync.Mutex
+    statuses := map[int]int{}
+    charged := 0
+    var wg sync.WaitGroup
+    for i := 0; i < 60; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            pair := []string{ ids[i % len(ids)], ids[(i + 1) % len(ids)] }
+            var status int
+            if i % 2 == 0 {
+                status = app.do("POST", "/v1/objects/charge", map[string]interface{}{ "ids": pair, "wallet_id": shopID, "amount": 15 }, nil)
+            } else {
+                status = app.do("POST", "/v1/objects/merge", map[string]interface{}{ "objects": pair }, nil)
+            }
+            mu.Lock()
+            statuses[status]++
+            if i % 2 == 0 && status == 200 {
+                charged++
+            }
+            mu.Unlock()
+        }(i)
+    }
+    wg.Wait()
+
+    // requests fail only because objects were used up or merged away
+    for status := range statuses {
+        assert.Contains([]int{ 200, 400, 402, 404 }, status)
+    }
+    assert.True(statuses[200] > 0)
+    assert.True(testutil.ToFloat64(metrics.TransactionRetries) > retriesBefore, "conflicting transactions should be retried")
+
+    app.assertConserved(identityID, shopID, float64(charged) * 15)
+}
+
+// create an issuer with a soul balance of 1000, the authorizing wallet holding
+// ten open objects of 100 and a shop wallet. Returns the issuer's identity id,
+// the shop's id and the ids of the objects
+func (a *testApp) seedOpenObjects() (string, string, []string) {
+    var service, wallet, shop map[string]interface{}
+    a.do("POST", "/v1/services", map[string]string{ "full_name": "Gold Shop", "service_name": "gold", "description": "sells gold", "email": "gold@shop.com" }, &service)
+    identityID := service["identity"].(map[string]interface{})["id"].(string)
+    a.exec("UPDATE services SET client_id = ? WHERE object_id = ?", testClientID, service["id"])
+    if a.do("PUT", "/v1/services/enable_issuer", map[string]string{ "service_id": service["id"].(string), "object_name": "gold", "base_currency": "USD" }, nil) != 200 {
+        a.t.Fatal("could not enable issuer")
+    }
+    if a.do("POST", "/v1/identities/renew_soul", map[string]interface{}{ "identity_id": identityID, "soul_balance": 1000 }, nil) != 200 {
+        a.t.Fatal("could not renew soul")
+    }
+    a.do("POST", "/v1/wallets", map[string]string{ "identity_id": identityID, "handle": "john", "password": "secret1" }, &wallet)
+    a.exec("UPDATE wallets SET object_id = ? WHERE object_id = ?", testWalletID, wallet["id"])
+    a.do("POST", "/v1/wallets", map[string]string{ "identity_id": identityID, "handle": "shop", "password": "secret1" }, &shop)
+
+    var objects []map[string]interface{}
+    if a.do("POST", "/v1/objects", map[string]interface{}{ "type": "obj_value", "wallet_id": testWalletID, "number_objects": 10, "unit_per_object": 100 }, &objects) != 200 {
+        a.t.Fatal("could not create objects")
+    }
+    for _, object := range objects {
+        if a.do("PUT", fmt.Sprintf("/v1/objects/%s/open", object["id"]), map[string]interface{}{ "open_method": "open" }, nil) != 200 {
+            a.t.Fatal("could not open object")
+        }
+    }
+    return identityID, shop["id"].(string), objectIDs(objects)
+}
+
+// check that the shop holds exactly what was charged, that no object has
+// a negative balance and that the issuer's total value is unchanged
+func (a *testApp) assertConserved(identityID, shopID string, charged float64) {
+    assert := assert.New(a.t)
+    var shopBalance, negative float64
+    row := a.db.GetPostgresHandle().Raw("SELECT COALESCE(SUM(objects.balance), 0) FROM objects JOIN wallets ON wallets.id = objects.wallet_id WHERE wallets.object_id = ?", shopID).Row()
+    assert.Nil(row.Scan(&shopBalance))
+    assert.InDelta(charged, shopBalance, 0.000001)
+    assert.Nil(a.db.GetPostgresHandle().Raw("SELECT COUNT(*) FROM objects WHERE balance < 0").Row().Scan(&negative))
+    assert.Equal(0.0, negative)
+    assert.InDelta(1000.0, a.totalValue(identityID), 0.000001)
+}
+
+// a worker reporting a fixed state
+type testWorker bool
+
+func (w testWorker) Running() bool {
+    return bool(w)
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+    assert := assert.New(t)
+    app := newTestApp(t)
+
+    var health map[string]string
+    assert.Equal(200, app.do("GET", "/healthz", nil, &health))
+    assert.Equal("ok", health["status"])
+
+    var ready struct {
+        Status string `json:"status"`
+        Checks map[string]string `json:"checks"`
+    }
+    app.handler = newApp(app.db, controllers.Workers{ "webhook_worker": testWorker(true) })
+    assert.Equal(200, app.do("GET", "/readyz", nil, &ready))
+    assert.Equal("ready", ready.Status)
+    assert.Equal(map[string]string{ "database": "ok", "migrations": "ok", "webhook_worker": "ok" }, ready.Checks)
+
+    // a stopped worker makes the server unavailable
+    app.handler = newApp(app.db, controllers.Workers{ "webhook_worker": testWorker(false) })
+    assert.Equal(503, app.do("GET", "/readyz", nil, &ready))
+    assert.Equal("unavailable", ready.Status)
+    assert.Equal("not running", ready.Checks["webhook_worker"])
+
+    // pending migrations make the server unavailable
+    app.exec("DELETE FROM schema_migrations WHERE version = 3")
+    assert.Equal(503, app.do("GET", "/readyz", nil, &ready))
+    assert.Contains(ready.Checks["migrations"], "pending migration")
+
+    // the check only reads the database, it does not create the migrations table
+    app.exec("DROP TABLE schema_migrations")
+    assert.Equal(503, app.do("GET", "/readyz", nil, &ready))
+    assert.Contains(ready.Checks["migrations"], "pending migration")
+    var tables int
+    assert.Nil(app.db.GetPostgresHandle().Raw("SELECT COUNT(*) FROM sqlite_master WHERE name = 'schema_migrations'").Row().Scan(&tables))
+    assert.Equal(0, tables)
+}
+
+func TestMetrics(t *testing.T) {
+    assert := assert.New(t)
+    app := newTestApp(t)
+    soulRenewed := metrics.SoulRenewed.WithLabelValues("silver")
+    malformedPins := metrics.PinFailures.WithLabelValues(metrics.PinMalformed)
+    rejections := metrics.PolicyRejections.WithLabelValues("POST /api/token")
+    renewedBefore, malformedBefore, rejectionsBefore := testutil.ToFloat64(soulRenewed), testutil.ToFloat64(malformedPins), testutil.ToFloat64(rejections)
+
+    // renew the soul of an issuer
+    var service map[string]interface{}
+    app.do("POST", "/v1/services", map[string]string{ "full_name": "Silver Shop", "service_name": "silver", "description": "sells silver", "email": "silver@shop.com" }, &service)
+    app.exec("UPDATE services SET client_id = ? WHERE object_id = ?", testClientID, service["id"])
+    assert.Equal(200, app.do("PUT", "/v1/services/enable_issuer", map[string]string{ "service_id": service["id"].(string), "object_name": "silver", "base_currency": "USD" }, nil))
+    identityID := service["identity"].(map[string]interface{})["id"].(string)
+    assert.Equal(200, app.do("POST", "/v1/identities/renew_soul", map[string]interface{}{ "identity_id": identityID, "soul_balance": 500 }, nil))
+    assert.Equal(500.0, testutil.ToFloat64(soulRenewed) - renewedBefore)
+
+    // a malformed pin and a request rejected by a policy
+    app.do("GET", "/v1/pins/1234/validate", nil, nil)
+    assert.Equal(1.0, testutil.ToFloat64(malformedPins) - malformedBefore)
+    assert.Equal(401, app.do("POST", "/api/token", nil, nil))
+    assert.Equal(1.0, testutil.ToFloat64(rejections) - rejectionsBefore)
+
+    // requests are counted by route pattern
+    app.do("GET", "/healthz", nil, nil)
+    req, _ := http.NewRequest("GET", "/metrics", nil)
+    res := httptest.NewRecorder()
+    app.handler.ServeHTTP(res, req)
+    assert.Equal(200, res.Code)
+    assert.Contains(res.Body.String(), `ownode_http_requests_total{method="GET",route="/healthz",status="200"}`)
+    assert.Contains(res.Body.String(), `ownode_http_requests_total{method="PUT",route="/v1/services/enable_issuer",status="200"}`)
+    assert.Contains(res.Body.String(), `ownode_soul_renewed_total{issuer="silver"}`)
+}
+
+func TestRequestIDs(t *testing.T) {
+    assert := assert.New(t)
+    app := newTestApp(t)
+
+    // a client's request id is echoed in the response and its error body
+    req, _ := http.NewRequest("GET", "/v1/objects/55c679145fe09c74ed00ffff", nil)
+    req.Header.Set("X-Request-ID", "checkout-42")
+    res := httptest.NewRecorder()
+    app.handler.ServeHTTP(res, req)
+    var body services.APIError
+    assert.Nil(json.Unmarshal(res.Body.Bytes(), &body))
+    assert.Equal(404, responseStatus(res))
+    assert.Equal(res.Header().Get("X-Request-ID"), "checkout-42")
+    assert.Equal(body.Error.RequestID, "checkout-42")
+
+    // requests without a valid id are given one
+    req, _ = http.NewRequest("GET", "/healthz", nil)
+    req.Header.Set("X-Request-ID", "not valid\n")
+    res = httptest.NewRecorder()
+    app.handler.ServeHTTP(res, req)
+    assert.Len(res.Header().Get("X-Request-ID"), 24)
+}
+
+func TestTracing(t *testing.T) {
+    assert := assert.New(t)
+    app := newTestApp(t)
+    _, err := tracing.Setup(tracing.ExporterNone, "", "")
+    assert.Nil(err)
+    exporter := tracetest.NewInMemoryExporter()
+    shutdown := tracing.Register(exporter)
+
+    // a request continues the trace of its traceparent header
+    traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+    req, _ := http.NewRequest("GET", "/v1/objects/55c679145fe09c74ed00ffff", nil)
+    req.Header.Set("traceparent", "00-" + traceID + "-00f067aa0ba902b7-01")
+    app.handler.ServeHTTP(httptest.NewRecorder(), req)
+    assert.Nil(shutdown(context.Background()))
+
+    names := map[string]bool{}
+    for _, span := range exporter.GetSpans() {
+        assert.Equal(traceID, span.SpanContext.TraceID().String())
+        names[span.Name] = true
+    }
+    assert.True(names["GET /v1/objects/:id"], "should trace the request")
+    assert.True(names["SELECT objects"], "should trace its queries")
+}
@@ -1,9 +1,258 @@
 package config
 
-var SupportedBaseCurrencies []string
+import (
+	"math"
+	"sort"
+)
 
-func init () {
-	SupportedBaseCurrencies = []string{
-		"AED","AFN","ALL","AMD","ANG","AOA","ARS","AUD","AWG","AZN","BAM","BBD","BDT","BGN","BHD","BIF","BMD","BND","BOB","BRL","BSD","BTC","BTN","BWP","BYR","BZD","CAD","CDF","CHF","CLF","CLP","CNY","COP","CRC","CUC","CUP","CVE","CZK","DJF","DKK","DOP","DZD","EEK","EGP","ERN","ETB","EUR","FJD","FKP","GBP","GEL","GGP","GHS","GIP","GMD","GNF","GTQ","GYD","HKD","HNL","HRK","HTG","HUF","IDR","ILS","IMP","INR","IQD","IRR","ISK","JEP","JMD","JOD","JPY","KES","KGS","KHR","KMF","KPW","KRW","KWD","KYD","KZT","LAK","LBP","LKR","LRD","LSL","LTL","LVL","LYD","MAD","MDL","MGA","MKD","MMK","MNT","MOP","MRO","MTL","MUR","MVR","MWK","MXN","MYR","MZN","NAD","NGN","NIO","NOK","NPR","NZD","OMR","PAB","PEN","PGK","PHP","PKR","PLN","PYG","QAR","RON","RSD","RUB","RWF","SAR","SBD","SCR","SDG","SEK","SGD","SHP","SLL","SOS","SRD","STD","SVC","SYP","SZL","THB","TJS","TMT","TND","TOP","TRY","TTD","TWD","TZS","UAH","UGX","USD","UYU","UZS","VEF","VND","VUV","WST","XAF","XAG","XAU","XCD","XDR","XOF","XPD","XPF","XPT","YER","ZAR","ZMK","ZMW","ZWL",
+// an ISO 4217 currency. MinorUnits is the number of decimal places of an amount,
+// -1 where ISO 4217 defines none (e.g precious metals). Obsolete currencies are
+// not active but are kept for issuers created before they were replaced
+type Currency struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	MinorUnits int `json:"minor_units"`
+	Symbol string `json:"symbol"`
+	Active bool `json:"active"`
+	PinPrefix string `json:"-"`
+}
+
+// the precision used for currencies without minor units
+const maxMinorUnits = 8
+
+// decimal places allowed in an amount of the currency
+func (c Currency) Places() int {
+	if c.MinorUnits < 0 {
+		return maxMinorUnits
+	}
+	return c.MinorUnits
+}
+
+// smallest amount of the currency
+func (c Currency) Unit() float64 {
+	return math.Pow(10, -float64(c.Places()))
+}
+
+// check that an amount has no more decimal places than the currency allows
+func (c Currency) ValidAmount(amount float64) bool {
+	units := amount * math.Pow(10, float64(c.Places()))
+	return math.Abs(units - math.Floor(units + .5)) < 1e-6
+}
+
+// registry of currencies by code
+var Currencies map[string]Currency
+
+// PIN prefixes are the ISO 4217 numeric codes. Currencies without a numeric code
+// use prefixes from 1000 which no numeric code can take
+var currencyTable = []Currency{
+	{ Code: "AED", Name: "UAE Dirham", MinorUnits: 2, Symbol: "د.إ", Active: true, PinPrefix: "784" },
+	{ Code: "AFN", Name: "Afghani", MinorUnits: 2, Symbol: "؋", Active: true, PinPrefix: "971" },
+	{ Code: "ALL", Name: "Lek", MinorUnits: 2, Symbol: "L", Active: true, PinPrefix: "008" },
+	{ Code: "AMD", Name: "Armenian Dram", MinorUnits: 2, Symbol: "֏", Active: true, PinPrefix: "051" },
+	{ Code: "ANG", Name: "Netherlands Antillean Guilder", MinorUnits: 2, Symbol: "ƒ", Active: true, PinPrefix: "532" },
+	{ Code: "AOA", Name: "Kwanza", MinorUnits: 2, Symbol: "Kz", Active: true, PinPrefix: "973" },
+	{ Code: "ARS", Name: "Argentine Peso", MinorUnits: 2, Symbol: "$", Active: true, PinPrefix: "032" },
+	{ Code: "AUD", Name: "Australian Dollar", MinorUnits: 2, Symbol: "A$", Active: true, PinPrefix: "036" },
+	{ Code: "AWG", Name: "Aruban Florin", MinorUnits: 2, Symbol: "ƒ", Active: true, PinPrefix: "533" },
+	{ Code: "AZN", Name: "Azerbaijan Manat", MinorUnits: 2, Symbol: "₼", Active: true, PinPrefix: "944" },
+	{ Code: "BAM", Name: "Convertible Mark", MinorUnits: 2, Symbol: "KM", Active: true, PinPrefix: "977" },
+	{ Code: "BBD", Name: "Barbados Dollar", MinorUnits: 2, Symbol: "Bds$", Active: true, PinPrefix: "052" },
+	{ Code: "BDT", Name: "Taka", MinorUnits: 2, Symbol: "৳", Active: true, PinPrefix: "050" },
+	{ Code: "BGN", Name: "Bulgarian Lev", MinorUnits: 2, Symbol: "лв", Active: true, PinPrefix: "975" },
+	{ Code: "BHD", Name: "Bahraini Dinar", MinorUnits: 3, Symbol: "BD", Active: true, PinPrefix: "048" },
+	{ Code: "BIF", Name: "Burundi Franc", MinorUnits: 0, Symbol: "FBu", Active: true, PinPrefix: "108" },
+	{ Code: "BMD", Name: "Bermudian Dollar", MinorUnits: 2, Symbol: "$", Active: true, PinPrefix: "060" },
+	{ Code: "BND", Name: "Brunei Dollar", MinorUnits: 2, Symbol: "B$", Active: true, PinPrefix: "096" },
+	{ Code: "BOB", Name: "Boliviano", MinorUnits: 2, Symbol: "Bs.", Active: true, PinPrefix: "068" },
+	{ Code: "BRL", Name: "Brazilian Real", MinorUnits: 2, Symbol: "R$", Active: true, PinPrefix: "986" },
+	{ Code: "BSD", Name: "Bahamian Dollar", MinorUnits: 2, Symbol: "B$", Active: true, PinPrefix: "044" },
+	{ Code: "BTN", Name: "Ngultrum", MinorUnits: 2, Symbol: "Nu.", Active: true, PinPrefix: "064" },
+	{ Code: "BWP", Name: "Pula", MinorUnits: 2, Symbol: "P", Active: true, PinPrefix: "072" },
+	{ Code: "BYN", Name: "Belarusian Ruble", MinorUnits: 2, Symbol: "Br", Active: true, PinPrefix: "933" },
+	{ Code: "BYR", Name: "Belarusian Ruble (2000-2016)", MinorUnits: 0, Symbol: "Br", Active: false, PinPrefix: "974" },
+	{ Code: "BZD", Name: "Belize Dollar", MinorUnits: 2, Symbol: "BZ$", Active: true, PinPrefix: "084" },
+	{ Code: "CAD", Name: "Canadian Dollar", MinorUnits: 2, Symbol: "C$", Active: true, PinPrefix: "124" },
+	{ Code: "CDF", Name: "Congolese Franc", MinorUnits: 2, Symbol: "FC", Active: true, PinPrefix: "976" },
+	{ Code: "CHF", Name: "Swiss Franc", MinorUnits: 2, Symbol: "CHF", Active: true, PinPrefix: "756" },
+	{ Code: "CLF", Name: "Unidad de Fomento", MinorUnits: 4, Symbol: "UF", Active: true, PinPrefix: "990" },
+	{ Code: "CLP", Name: "Chilean Peso", MinorUnits: 0, Symbol: "$", Active: true, PinPrefix: "152" },
+	{ Code: "CNY", Name: "Yuan Renminbi", MinorUnits: 2, Symbol: "¥", Active: true, PinPrefix: "156" },
+	{ Code: "COP", Name: "Colombian Peso", MinorUnits: 2, Symbol: "$", Active: true, PinPrefix: "170" },
+	{ Code: "CRC", Name: "Costa Rican Colon", MinorUnits: 2, Symbol: "₡", Active: true, PinPrefix: "188" },
+	{ Code: "CUC", Name: "Peso Convertible", MinorUnits: 2, Symbol: "CUC$", Active: true, PinPrefix: "931" },
+	{ Code: "CUP", Name: "Cuban Peso", MinorUnits: 2, Symbol: "$", Active: true, PinPrefix: "192" },
+	{ Code: "CVE", Name: "Cabo Verde Escudo", MinorUnits: 2, Symbol: "Esc", Active: true, PinPrefix: "132" },
+	{ Code: "CZK", Name: "Czech Koruna", MinorUnits: 2, Symbol: "Kč", Active: true, PinPrefix: "203" },
+	{ Code: "DJF", Name: "Djibouti Franc", MinorUnits: 0, Symbol: "Fdj", Active: true, PinPrefix: "262" },
+	{ Code: "DKK", Name: "Danish Krone", MinorUnits: 2, Symbol: "kr", Active: true, PinPrefix: "208" },
+	{ Code: "DOP", Name: "Dominican Peso", MinorUnits: 2, Symbol: "RD$", Active: true, PinPrefix: "214" },
+	{ Code: "DZD", Name: "Algerian Dinar", MinorUnits: 2, Symbol: "DA", Active: true, PinPrefix: "012" },
+	{ Code: "EEK", Name: "Kroon", MinorUnits: 2, Symbol: "kr", Active: false, PinPrefix: "233" },
+	{ Code: "EGP", Name: "Egyptian Pound", MinorUnits: 2, Symbol: "E£", Active: true, PinPrefix: "818" },
+	{ Code: "ERN", Name: "Nakfa", MinorUnits: 2, Symbol: "Nfk", Active: true, PinPrefix: "232" },
+	{ Code: "ETB", Name: "Ethiopian Birr", MinorUnits: 2, Symbol: "Br", Active: true, PinPrefix: "230" },
+	{ Code: "EUR", Name: "Euro", MinorUnits: 2, Symbol: "€", Active: true, PinPrefix: "978" },
+	{ Code: "FJD", Name: "Fiji Dollar", MinorUnits: 2, Symbol: "FJ$", Active: true, PinPrefix: "242" },
+	{ Code: "FKP", Name: "Falkland Islands Pound", MinorUnits: 2, Symbol: "£", Active: true, PinPrefix: "238" },
+	{ Code: "GBP", Name: "Pound Sterling", MinorUnits: 2, Symbol: "£", Active: true, PinPrefix: "826" },
+	{ Code: "GEL", Name: "Lari", MinorUnits: 2, Symbol: "₾", Active: true, PinPrefix: "981" },
+	{ Code: "GHS", Name: "Ghana Cedi", MinorUnits: 2, Symbol: "GH₵", Active: true, PinPrefix: "936" },
+	{ Code: "GIP", Name: "Gibraltar Pound", MinorUnits: 2, Symbol: "£", Active: true, PinPrefix: "292" },
+	{ Code: "GMD", Name: "Dalasi", MinorUnits: 2, Symbol: "D", Active: true, PinPrefix: "270" },
+	{ Code: "GNF", Name: "Guinean Franc", MinorUnits: 0, Symbol: "FG", Active: true, PinPrefix: "324" },
+	{ Code: "GTQ", Name: "Quetzal", MinorUnits: 2, Symbol: "Q", Active: true, PinPrefix: "320" },
+	{ Code: "GYD", Name: "Guyana Dollar", MinorUnits: 2, Symbol: "G$", Active: true, PinPrefix: "328" },
+	{ Code: "HKD", Name: "Hong Kong Dollar", MinorUnits: 2, Symbol: "HK$", Active: true, PinPrefix: "344" },
+	{ Code: "HNL", Name: "Lempira", MinorUnits: 2, Symbol: "L", Active: true, PinPrefix: "340" },
+	{ Code: "HRK", Name: "Kuna", MinorUnits: 2, Symbol: "kn", Active: false, PinPrefix: "191" },
+	{ Code: "HTG", Name: "Gourde", MinorUnits: 2, Symbol: "G", Active: true, PinPrefix: "332" },
+	{ Code: "HUF", Name: "Forint", MinorUnits: 2, Symbol: "Ft", Active: true, PinPrefix: "348" },
+	{ Code: "IDR", Name: "Rupiah", MinorUnits: 2, Symbol: "Rp", Active: true, PinPrefix: "360" },
+	{ Code: "ILS", Name: "New Israeli Sheqel", MinorUnits: 2, Symbol: "₪", Active: true, PinPrefix: "376" },
+	{ Code: "INR", Name: "Indian Rupee", MinorUnits: 2, Symbol: "₹", Active: true, PinPrefix: "356" },
+	{ Code: "IQD", Name: "Iraqi Dinar", MinorUnits: 3, Symbol: "ID", Active: true, PinPrefix: "368" },
+	{ Code: "IRR", Name: "Iranian Rial", MinorUnits: 2, Symbol: "﷼", Active: true, PinPrefix: "364" },
+	{ Code: "ISK", Name: "Iceland Krona", MinorUnits: 0, Symbol: "kr", Active: true, PinPrefix: "352" },
+	{ Code: "JMD", Name: "Jamaican Dollar", MinorUnits: 2, Symbol: "J$", Active: true, PinPrefix: "388" },
+	{ Code: "JOD", Name: "Jordanian Dinar", MinorUnits: 3, Symbol: "JD", Active: true, PinPrefix: "400" },
+	{ Code: "JPY", Name: "Yen", MinorUnits: 0, Symbol: "¥", Active: true, PinPrefix: "392" },
+	{ Code: "KES", Name: "Kenyan Shilling", MinorUnits: 2, Symbol: "KSh", Active: true, PinPrefix: "404" },
+	{ Code: "KGS", Name: "Som", MinorUnits: 2, Symbol: "с", Active: true, PinPrefix: "417" },
+	{ Code: "KHR", Name: "Riel", MinorUnits: 2, Symbol: "៛", Active: true, PinPrefix: "116" },
+	{ Code: "KMF", Name: "Comorian Franc", MinorUnits: 0, Symbol: "CF", Active: true, PinPrefix: "174" },
+	{ Code: "KPW", Name: "North Korean Won", MinorUnits: 2, Symbol: "₩", Active: true, PinPrefix: "408" },
+	{ Code: "KRW", Name: "Won", MinorUnits: 0, Symbol: "₩", Active: true, PinPrefix: "410" },
+	{ Code: "KWD", Name: "Kuwaiti Dinar", MinorUnits: 3, Symbol: "KD", Active: true, PinPrefix: "414" },
+	{ Code: "KYD", Name: "Cayman Islands Dollar", MinorUnits: 2, Symbol: "CI$", Active: true, PinPrefix: "136" },
+	{ Code: "KZT", Name: "Tenge", MinorUnits: 2, Symbol: "₸", Active: true, PinPrefix: "398" },
+	{ Code: "LAK", Name: "Lao Kip", MinorUnits: 2, Symbol: "₭", Active: true, PinPrefix: "418" },
+	{ Code: "LBP", Name: "Lebanese Pound", MinorUnits: 2, Symbol: "L£", Active: true, PinPrefix: "422" },
+	{ Code: "LKR", Name: "Sri Lanka Rupee", MinorUnits: 2, Symbol: "Rs", Active: true, PinPrefix: "144" },
+	{ Code: "LRD", Name: "Liberian Dollar", MinorUnits: 2, Symbol: "L$", Active: true, PinPrefix: "430" },
+	{ Code: "LSL", Name: "Loti", MinorUnits: 2, Symbol: "L", Active: true, PinPrefix: "426" },
+	{ Code: "LTL", Name: "Lithuanian Litas", MinorUnits: 2, Symbol: "Lt", Active: false, PinPrefix: "440" },
+	{ Code: "LVL", Name: "Latvian Lats", MinorUnits: 2, Symbol: "Ls", Active: false, PinPrefix: "428" },
+	{ Code: "LYD", Name: "Libyan Dinar", MinorUnits: 3, Symbol: "LD", Active: true, PinPrefix: "434" },
+	{ Code: "MAD", Name: "Moroccan Dirham", MinorUnits: 2, Symbol: "DH", Active: true, PinPrefix: "504" },
+	{ Code: "MDL", Name: "Moldovan Leu", MinorUnits: 2, Symbol: "L", Active: true, PinPrefix: "498" },
+	{ Code: "MGA", Name: "Malagasy Ariary", MinorUnits: 2, Symbol: "Ar", Active: true, PinPrefix: "969" },
+	{ Code: "MKD", Name: "Denar", MinorUnits: 2, Symbol: "ден", Active: true, PinPrefix: "807" },
+	{ Code: "MMK", Name: "Kyat", MinorUnits: 2, Symbol: "K", Active: true, PinPrefix: "104" },
+	{ Code: "MNT", Name: "Tugrik", MinorUnits: 2, Symbol: "₮", Active: true, PinPrefix: "496" },
+	{ Code: "MOP", Name: "Pataca", MinorUnits: 2, Symbol: "MOP$", Active: true, PinPrefix: "446" },
+	{ Code: "MRO", Name: "Ouguiya (1973-2017)", MinorUnits: 2, Symbol: "UM", Active: false, PinPrefix: "478" },
+	{ Code: "MRU", Name: "Ouguiya", MinorUnits: 2, Symbol: "UM", Active: true, PinPrefix: "929" },
+	{ Code: "MTL", Name: "Maltese Lira", MinorUnits: 2, Symbol: "Lm", Active: false, PinPrefix: "470" },
+	{ Code: "MUR", Name: "Mauritius Rupee", MinorUnits: 2, Symbol: "₨", Active: true, PinPrefix: "480" },
+	{ Code: "MVR", Name: "Rufiyaa", MinorUnits: 2, Symbol: "Rf", Active: true, PinPrefix: "462" },
+	{ Code: "MWK", Name: "Malawi Kwacha", MinorUnits: 2, Symbol: "MK", Active: true, PinPrefix: "454" },
+	{ Code: "MXN", Name: "Mexican Peso", MinorUnits: 2, Symbol: "$", Active: true, PinPrefix: "484" },
+	{ Code: "MYR", Name: "Malaysian Ringgit", MinorUnits: 2, Symbol: "RM", Active: true, PinPrefix: "458" },
+	{ Code: "MZN", Name: "Mozambique Metical", MinorUnits: 2, Symbol: "MT", Active: true, PinPrefix: "943" },
+	{ Code: "NAD", Name: "Namibia Dollar", MinorUnits: 2, Symbol: "N$", Active: true, PinPrefix: "516" },
+	{ Code: "NGN", Name: "Naira", MinorUnits: 2, Symbol: "₦", Active: true, PinPrefix: "566" },
+	{ Code: "NIO", Name: "Cordoba Oro", MinorUnits: 2, Symbol: "C$", Active: true, PinPrefix: "558" },
+	{ Code: "NOK", Name: "Norwegian Krone", MinorUnits: 2, Symbol: "kr", Active: true, PinPrefix: "578" },
+	{ Code: "NPR", Name: "Nepalese Rupee", MinorUnits: 2, Symbol: "Rs", Active: true, PinPrefix: "524" },
+	{ Code: "NZD", Name: "New Zealand Dollar", MinorUnits: 2, Symbol: "NZ$", Active: true, PinPrefix: "554" },
+	{ Code: "OMR", Name: "Rial Omani", MinorUnits: 3, Symbol: "RO", Active: true, PinPrefix: "512" },
+	{ Code: "PAB", Name: "Balboa", MinorUnits: 2, Symbol: "B/.", Active: true, PinPrefix: "590" },
+	{ Code: "PEN", Name: "Sol", MinorUnits: 2, Symbol: "S/", Active: true, PinPrefix: "604" },
+	{ Code: "PGK", Name: "Kina", MinorUnits: 2, Symbol: "K", Active: true, PinPrefix: "598" },
+	{ Code: "PHP", Name: "Philippine Peso", MinorUnits: 2, Symbol: "₱", Active: true, PinPrefix: "608" },
+	{ Code: "PKR", Name: "Pakistan Rupee", MinorUnits: 2, Symbol: "Rs", Active: true, PinPrefix: "586" },
+	{ Code: "PLN", Name: "Zloty", MinorUnits: 2, Symbol: "zł", Active: true, PinPrefix: "985" },
+	{ Code: "PYG", Name: "Guarani", MinorUnits: 0, Symbol: "₲", Active: true, PinPrefix: "600" },
+	{ Code: "QAR", Name: "Qatari Rial", MinorUnits: 2, Symbol: "QR", Active: true, PinPrefix: "634" },
+	{ Code: "RON", Name: "Romanian Leu", MinorUnits: 2, Symbol: "lei", Active: true, PinPrefix: "946" },
+	{ Code: "RSD", Name: "Serbian Dinar", MinorUnits: 2, Symbol: "din", Active: true, PinPrefix: "941" },
+	{ Code: "RUB", Name: "Russian Ruble", MinorUnits: 2, Symbol: "₽", Active: true, PinPrefix: "643" },
+	{ Code: "RWF", Name: "Rwanda Franc", MinorUnits: 0, Symbol: "FRw", Active: true, PinPrefix: "646" },
+	{ Code: "SAR", Name: "Saudi Riyal", MinorUnits: 2, Symbol: "SR", Active: true, PinPrefix: "682" },
+	{ Code: "SBD", Name: "Solomon Islands Dollar", MinorUnits: 2, Symbol: "SI$", Active: true, PinPrefix: "090" },
+	{ Code: "SCR", Name: "Seychelles Rupee", MinorUnits: 2, Symbol: "SR", Active: true, PinPrefix: "690" },
+	{ Code: "SDG", Name: "Sudanese Pound", MinorUnits: 2, Symbol: "£SD", Active: true, PinPrefix: "938" },
+	{ Code: "SEK", Name: "Swedish Krona", MinorUnits: 2, Symbol: "kr", Active: true, PinPrefix: "752" },
+	{ Code: "SGD", Name: "Singapore Dollar", MinorUnits: 2, Symbol: "S$", Active: true, PinPrefix: "702" },
+	{ Code: "SHP", Name: "Saint Helena Pound", MinorUnits: 2, Symbol: "£", Active: true, PinPrefix: "654" },
+	{ Code: "SLE", Name: "Leone", MinorUnits: 2, Symbol: "Le", Active: true, PinPrefix: "925" },
+	{ Code: "SLL", Name: "Leone (1964-2022)", MinorUnits: 2, Symbol: "Le", Active: false, PinPrefix: "694" },
+	{ Code: "SOS", Name: "Somali Shilling", MinorUnits: 2, Symbol: "Sh", Active: true, PinPrefix: "706" },
+	{ Code: "SRD", Name: "Surinam Dollar", MinorUnits: 2, Symbol: "$", Active: true, PinPrefix: "968" },
+	{ Code: "STD", Name: "Dobra (1977-2017)", MinorUnits: 2, Symbol: "Db", Active: false, PinPrefix: "678" },
+	{ Code: "STN", Name: "Dobra", MinorUnits: 2, Symbol: "Db", Active: true, PinPrefix: "930" },
+	{ Code: "SVC", Name: "El Salvador Colon", MinorUnits: 2, Symbol: "₡", Active: true, PinPrefix: "222" },
+	{ Code: "SYP", Name: "Syrian Pound", MinorUnits: 2, Symbol: "£S", Active: true, PinPrefix: "760" },
+	{ Code: "SZL", Name: "Lilangeni", MinorUnits: 2, Symbol: "E", Active: true, PinPrefix: "748" },
+	{ Code: "THB", Name: "Baht", MinorUnits: 2, Symbol: "฿", Active: true, PinPrefix: "764" },
+	{ Code: "TJS", Name: "Somoni", MinorUnits: 2, Symbol: "SM", Active: true, PinPrefix: "972" },
+	{ Code: "TMT", Name: "Turkmenistan New Manat", MinorUnits: 2, Symbol: "m", Active: true, PinPrefix: "934" },
+	{ Code: "TND", Name: "Tunisian Dinar", MinorUnits: 3, Symbol: "DT", Active: true, PinPrefix: "788" },
+	{ Code: "TOP", Name: "Pa'anga", MinorUnits: 2, Symbol: "T$", Active: true, PinPrefix: "776" },
+	{ Code: "TRY", Name: "Turkish Lira", MinorUnits: 2, Symbol: "₺", Active: true, PinPrefix: "949" },
+	{ Code: "TTD", Name: "Trinidad and Tobago Dollar", MinorUnits: 2, Symbol: "TT$", Active: true, PinPrefix: "780" },
+	{ Code: "TWD", Name: "New Taiwan Dollar", MinorUnits: 2, Symbol: "NT$", Active: true, PinPrefix: "901" },
+	{ Code: "TZS", Name: "Tanzanian Shilling", MinorUnits: 2, Symbol: "TSh", Active: true, PinPrefix: "834" },
+	{ Code: "UAH", Name: "Hryvnia", MinorUnits: 2, Symbol: "₴", Active: true, PinPrefix: "980" },
+	{ Code: "UGX", Name: "Uganda Shilling", MinorUnits: 0, Symbol: "USh", Active: true, PinPrefix: "800" },
+	{ Code: "USD", Name: "US Dollar", MinorUnits: 2, Symbol: "$", Active: true, PinPrefix: "840" },
+	{ Code: "UYU", Name: "Peso Uruguayo", MinorUnits: 2, Symbol: "$U", Active: true, PinPrefix: "858" },
+	{ Code: "UZS", Name: "Uzbekistan Sum", MinorUnits: 2, Symbol: "soʻm", Active: true, PinPrefix: "860" },
+	{ Code: "VEF", Name: "Bolivar (2008-2018)", MinorUnits: 2, Symbol: "Bs", Active: false, PinPrefix: "937" },
+	{ Code: "VES", Name: "Bolivar Soberano", MinorUnits: 2, Symbol: "Bs.S", Active: true, PinPrefix: "928" },
+	{ Code: "VND", Name: "Dong", MinorUnits: 0, Symbol: "₫", Active: true, PinPrefix: "704" },
+	{ Code: "VUV", Name: "Vatu", MinorUnits: 0, Symbol: "VT", Active: true, PinPrefix: "548" },
+	{ Code: "WST", Name: "Tala", MinorUnits: 2, Symbol: "WS$", Active: true, PinPrefix: "882" },
+	{ Code: "XAF", Name: "CFA Franc BEAC", MinorUnits: 0, Symbol: "FCFA", Active: true, PinPrefix: "950" },
+	{ Code: "XAG", Name: "Silver", MinorUnits: -1, Symbol: "XAG", Active: true, PinPrefix: "961" },
+	{ Code: "XAU", Name: "Gold", MinorUnits: -1, Symbol: "XAU", Active: true, PinPrefix: "959" },
+	{ Code: "XCD", Name: "East Caribbean Dollar", MinorUnits: 2, Symbol: "EC$", Active: true, PinPrefix: "951" },
+	{ Code: "XDR", Name: "SDR (Special Drawing Right)", MinorUnits: -1, Symbol: "SDR", Active: true, PinPrefix: "960" },
+	{ Code: "XOF", Name: "CFA Franc BCEAO", MinorUnits: 0, Symbol: "CFA", Active: true, PinPrefix: "952" },
+	{ Code: "XPD", Name: "Palladium", MinorUnits: -1, Symbol: "XPD", Active: true, PinPrefix: "964" },
+	{ Code: "XPF", Name: "CFP Franc", MinorUnits: 0, Symbol: "₣", Active: true, PinPrefix: "953" },
+	{ Code: "XPT", Name: "Platinum", MinorUnits: -1, Symbol: "XPT", Active: true, PinPrefix: "962" },
+	{ Code: "YER", Name: "Yemeni Rial", MinorUnits: 2, Symbol: "﷼", Active: true, PinPrefix: "886" },
+	{ Code: "ZAR", Name: "Rand", MinorUnits: 2, Symbol: "R", Active: true, PinPrefix: "710" },
+	{ Code: "ZMK", Name: "Zambian Kwacha (1968-2012)", MinorUnits: 2, Symbol: "ZK", Active: false, PinPrefix: "894" },
+	{ Code: "ZMW", Name: "Zambian Kwacha", MinorUnits: 2, Symbol: "ZK", Active: true, PinPrefix: "967" },
+	{ Code: "ZWG", Name: "Zimbabwe Gold", MinorUnits: 2, Symbol: "ZiG", Active: true, PinPrefix: "924" },
+	{ Code: "ZWL", Name: "Zimbabwe Dollar (2009-2024)", MinorUnits: 2, Symbol: "Z$", Active: false, PinPrefix: "932" },
+
+	// not ISO 4217. kept for existing issuers
+	{ Code: "BTC", Name: "Bitcoin", MinorUnits: 8, Symbol: "₿", Active: false, PinPrefix: "1000" },
+	{ Code: "GGP", Name: "Guernsey Pound", MinorUnits: 2, Symbol: "£", Active: false, PinPrefix: "1001" },
+	{ Code: "IMP", Name: "Isle of Man Pound", MinorUnits: 2, Symbol: "£", Active: false, PinPrefix: "1002" },
+	{ Code: "JEP", Name: "Jersey Pound", MinorUnits: 2, Symbol: "£", Active: false, PinPrefix: "1003" },
+}
+
+func init() {
+	Currencies = map[string]Currency{}
+	for _, currency := range currencyTable {
+		Currencies[currency.Code] = currency
+	}
+}
+
+// find a currency by code
+func FindCurrency(code string) (Currency, bool) {
+	currency, found := Currencies[code]
+	return currency, found
+}
+
+// check if a currency can be used as the base currency of a new issuer
+func IsActiveCurrency(code string) bool {
+	currency, found := Currencies[code]
+	return found && currency.Active
+}
+
+// codes of the active currencies in alphabetical order
+func ActiveCurrencyCodes() []string {
+	codes := []string{}
+	for code, currency := range Currencies {
+		if currency.Active {
+			codes = append(codes, code)
+		}
 	}
+	sort.Strings(codes)
+	return codes
 }
@@ -1,164 +0,0 @@
-package config
-
-var CurrencyCallCodes map[string]int
-
-func init() {
-	CurrencyCallCodes = map[string]int{
-		"AFN":93,
-		"EUR":34,
-		"ALL":355,
-		"DZD":213,
-		"USD":263,
-		"AOA":244,
-		"XCD":1784,
-		"ARS":54,
-		"AMD":374,
-		"AWG":297,
-		"AUD":688,
-		"AZN":994,
-		"BSD":1242,
-		"BHD":973,
-		"BDT":880,
-		"BBD":1246,
-		"BYR":375,
-		"BZD":501,
-		"XOF":228,
-		"BMD":1441,
-		"BTN":975,
-		"BOB":591,
-		"BAM":387,
-		"BWP":267,
-		"NOK":4779,
-		"BRL":55,
-		"BND":673,
-		"BGN":359,
-		"BIF":257,
-		"KHR":855,
-		"XAF":241,
-		"CAD":1,
-		"CVE":238,
-		"KYD":1345,
-		"CLF":56,
-		"CNY":86,
-		"COP":57,
-		"KMF":269,
-		"CDF":243,
-		"NZD":690,
-		"CRC":506,
-		"HRK":385,
-		"CUC":53,
-		"ANG":1721,
-		"CZK":420,
-		"DKK":299,
-		"DJF":253,
-		"DOP":1809,
-		"EGP":20,
-		"SVC":503,
-		"ERN":291,
-		"ETB":251,
-		"FKP":500,
-		"FJD":679,
-		"XPF":681,
-		"GMD":220,
-		"GEL":995,
-		"GHS":233,
-		"GIP":350,
-		"GTQ":502,
-		"GBP":44,
-		"GNF":224,
-		"GYD":592,
-		"HTG":509,
-		"HNL":504,
-		"HKD":852,
-		"HUF":36,
-		"ISK":354,
-		"INR":91,
-		"IDR":62,
-		"IRR":98,
-		"IQD":964,
-		"ILS":970,
-		"JMD":1876,
-		"JPY":81,
-		"JOD":962,
-		"KZT":76,
-		"KES":254,
-		"KWD":965,
-		"KGS":996,
-		"LAK":856,
-		"LBP":961,
-		"LSL":266,
-		"LRD":231,
-		"LYD":218,
-		"CHF":423,
-		"MOP":853,
-		"MKD":389,
-		"MGA":261,
-		"MWK":265,
-		"MYR":60,
-		"MVR":960,
-		"MRO":222,
-		"MUR":230,
-		"MXN":52,
-		"MDL":373,
-		"MNT":976,
-		"MAD":212,
-		"MZN":258,
-		"MMK":95,
-		"NAD":264,
-		"NPR":977,
-		"NIO":505,
-		"NGN":234,
-		"KPW":850,
-		"OMR":968,
-		"PKR":92,
-		"PAB":507,
-		"PGK":675,
-		"PYG":595,
-		"PEN":51,
-		"PHP":63,
-		"PLN":48,
-		"QAR":974,
-		"RON":40,
-		"RUB":7,
-		"RWF":250,
-		"SHP":290,
-		"WST":685,
-		"STD":239,
-		"SAR":966,
-		"RSD":381,
-		"SCR":248,
-		"SLL":232,
-		"SGD":65,
-		"SDB":677,
-		"SOS":252,
-		"ZAR":27,
-		"KRW":82,
-		"SSP":211,
-		"LKR":94,
-		"SDG":249,
-		"SRD":597,
-		"SZL":268,
-		"SEK":46,
-		"CHE":41,
-		"SYP":963,
-		"TWD":886,
-		"TJS":992,
-		"TZS":255,
-		"THB":66,
-		"TOP":676,
-		"TTD":1868,
-		"TND":216,
-		"TRY":90,
-		"TMT":993,
-		"UGX":256,
-		"UAH":380,
-		"AED":971,
-		"UYI":598,
-		"UZS":998,
-		"VUV":678,
-		"VEF":58,
-		"VND":84,
-		"YER":967,
-		"ZMK":260,
-	}
-}
\ No newline at end of file
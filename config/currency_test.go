@@ -0,0 +1,36 @@
+package config
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurrencyValidAmount(t *testing.T) {
+	assert := assert.New(t)
+	usd, _ := FindCurrency("USD")
+	assert.True(usd.ValidAmount(10.25))
+	assert.False(usd.ValidAmount(10.255))
+
+	jpy, _ := FindCurrency("JPY")
+	assert.True(jpy.ValidAmount(100))
+	assert.False(jpy.ValidAmount(100.5))
+
+	// currencies without minor units allow the object precision
+	xau, _ := FindCurrency("XAU")
+	assert.True(xau.ValidAmount(0.00000001))
+}
+
+func TestCurrencyRegistry(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(IsActiveCurrency("EUR"))
+	assert.False(IsActiveCurrency("EEK"))
+	assert.False(IsActiveCurrency("XYZ"))
+
+	// pin prefixes must be unique
+	prefixes := map[string]string{}
+	for code, currency := range Currencies {
+		_, dup := prefixes[currency.PinPrefix]
+		assert.False(dup, code)
+		prefixes[currency.PinPrefix] = code
+	}
+}
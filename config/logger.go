@@ -2,78 +2,64 @@ package config
 
 import (
     "github.com/Sirupsen/logrus"
+    "io"
     "os"
-    "fmt"
 )
 
 var log *CustomLog
 
-// custom log supports prepending logs with a name or identifier
+// custom log writes json lines. Fields such as a name or a request id are
+// added with WithField, which returns a new log and leaves the original unchanged,
+// so a log can be shared between goroutines
 type CustomLog struct {
-	BaseLogger *logrus.Logger
-	Name string
-	Id string
-	EnableNaming bool
-}
-
-func (cl *CustomLog) makeName() string {
-	return fmt.Sprintf("%s-%s: ", cl.Name, cl.Id)
+	entry *logrus.Entry
 }
 
 func (cl *CustomLog) Info(args... interface{}) {
-	if cl.EnableNaming {
-		args = append([]interface{}{ cl.makeName() }, args...)
-	}
-	cl.BaseLogger.Info(args...)
+	cl.entry.Info(args...)
 }
 
 func (cl *CustomLog) Debug(args... interface{}) {
-	if cl.EnableNaming {
-		args = append([]interface{}{ cl.makeName() }, args...)
-	}
-	cl.BaseLogger.Debug(args...)
+	cl.entry.Debug(args...)
 }
 
 func (cl *CustomLog) Error(args... interface{}) {
-	if cl.EnableNaming {
-		args = append([]interface{}{ cl.makeName() }, args...)
-	}
-	cl.BaseLogger.Error(args...)
+	cl.entry.Error(args...)
 }
 
 func (cl *CustomLog) Warn(args... interface{}) {
-	if cl.EnableNaming {
-		args = append([]interface{}{ cl.makeName() }, args...)
-	}
-	cl.BaseLogger.Warn(args...)
+	cl.entry.Warn(args...)
 }
 
-// Set log name
-func (cl *CustomLog) SetName(name, id string) {
-	cl.Name = name
-	cl.Id = id
+// return a log that adds a field to every line
+func (cl *CustomLog) WithField(key string, value interface{}) *CustomLog {
+	return &CustomLog{ entry: cl.entry.WithField(key, value) }
+}
+
+// return a log that adds fields to every line
+func (cl *CustomLog) WithFields(fields map[string]interface{}) *CustomLog {
+	return &CustomLog{ entry: cl.entry.WithFields(logrus.Fields(fields)) }
+}
+
+// create a log writing json lines to w
+func NewLog(w io.Writer) *CustomLog {
+	base := logrus.New()
+	base.Out = w
+	base.Level = logrus.DebugLevel
+	base.Formatter = &logrus.JSONFormatter{}
+	return &CustomLog{ entry: logrus.NewEntry(base) }
 }
 
 func init(){
-	log = &CustomLog{ BaseLogger: logrus.New() }
+	log = NewLog(os.Stderr)
 }
 
 // return a nameless log
 func Log() *CustomLog {
-    log.EnableNaming = false
-    // logrus.SetFormatter(&logrus.JSONFormatter{})
-	log.BaseLogger.Out = os.Stderr
-  	log.BaseLogger.Level = logrus.DebugLevel
 	return log
 }
 
+// return a log that adds a name and an id to every line
 func LogWithName(name string, id string) *CustomLog {
-	log.EnableNaming = true
-	log.SetName(name, id)
-	// logrus.SetFormatter(&logrus.JSONFormatter{})
-	log.BaseLogger.Out = os.Stderr
-  	log.BaseLogger.Level = logrus.DebugLevel
-	return log
+	return log.WithFields(map[string]interface{}{ "name": name, "id": id })
 }
-
-
@@ -1,11 +1,86 @@
 package config
 
 import (
+	"fmt"
+	"strconv"
+	"github.com/ownode/migrations"
 	"github.com/ownode/models"
 	"github.com/ownode/services"
 )
 
-func PostgresAutoMigration(db *services.DB) {
-	db.GetPostgresHandle().AutoMigrate(&models.Token{}, &models.Service{}, &models.Identity{}, &models.Wallet{}, &models.Object{})
-	services.Println("Migration complete!")
-}
\ No newline at end of file
+// apply the pending migrations. Objects created before events
+// were recorded are then imported so replays include them
+func MigrateUp(db *services.DB) error {
+	all, err := migrations.All(db.Dialect())
+	if err != nil {
+		return err
+	}
+	applied, err := migrations.Up(db.GetPostgresHandle().DB(), db.Dialect(), all)
+	for _, m := range applied {
+		services.Println(fmt.Sprintf("Applied %04d_%s", m.Version, m.Name))
+	}
+	if err != nil {
+		return err
+	}
+	return models.ImportObjectsAsEvents(db.GetPostgresHandle(), services.NewObjectID, 1000)
+}
+
+// ensure the database has no pending migrations. Only reads the database,
+// so it can be called by the readiness probe
+func CheckMigrations(db *services.DB) error {
+	all, err := migrations.All(db.Dialect())
+	if err != nil {
+		return err
+	}
+	pending, err := migrations.Pending(db.GetPostgresHandle().DB(), db.Dialect(), all)
+	if err != nil {
+		return err
+	} else if len(pending) > 0 {
+		return fmt.Errorf("database has %d pending migration(s). run `ownode migrate up`", len(pending))
+	}
+	return nil
+}
+
+// run `ownode migrate up|down [steps]|status`. down reverts one migration unless steps is given
+func RunMigrateCommand(db *services.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: ownode migrate up|down [steps]|status")
+	}
+
+	all, err := migrations.All(db.Dialect())
+	if err != nil {
+		return err
+	}
+	sqlDB := db.GetPostgresHandle().DB()
+
+	switch args[0] {
+	case "up":
+		return MigrateUp(db)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("steps must be a number greater than zero")
+			}
+		}
+		reverted, err := migrations.Down(sqlDB, db.Dialect(), all, steps)
+		for _, m := range reverted {
+			services.Println(fmt.Sprintf("Reverted %04d_%s", m.Version, m.Name))
+		}
+		return err
+	case "status":
+		statuses, err := migrations.GetStatus(sqlDB, db.Dialect(), all)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+			}
+			services.Println(fmt.Sprintf("%04d_%s\t%s", s.Version, s.Name, state))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %s. use up, down or status", args[0])
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"gopkg.in/yaml.v2"
+)
+
+type DatabaseSettings struct {
+	Driver string `yaml:"driver"`
+	DSN string `yaml:"dsn"`
+	MaxOpenConns int `yaml:"max_open_conns"`
+	MaxIdleConns int `yaml:"max_idle_conns"`
+}
+
+type AuthSettings struct {
+	SigningKey string `yaml:"signing_key"`
+	BackOfficeID string `yaml:"backoffice_id"`
+	BackOfficeSecret string `yaml:"backoffice_secret"`
+}
+
+type LimitSettings struct {
+	MaxMetaSize int `yaml:"max_meta_size"`
+	MaxObjectsPerRequest int `yaml:"max_objects_per_request"`
+}
+
+// how long computed results are reused, in seconds. 0 disables caching
+type CacheSettings struct {
+	IssuerMetricsTTL int `yaml:"issuer_metrics_ttl"`
+}
+
+// where trace spans are exported. exporter is none, otlp, stdout or file.
+// endpoint is the otlp http endpoint, file the file spans are appended to
+type TracingSettings struct {
+	Exporter string `yaml:"exporter"`
+	Endpoint string `yaml:"endpoint"`
+	File string `yaml:"file"`
+}
+
+// settings of the server. Loaded from a yaml file, then environment variables,
+// then command line flags. Later sources override earlier ones
+type Settings struct {
+	Listen string `yaml:"listen"`
+	ShutdownTimeout int `yaml:"shutdown_timeout"`
+	RatesFile string `yaml:"rates_file"`
+	Database DatabaseSettings `yaml:"database"`
+	Auth AuthSettings `yaml:"auth"`
+	Limits LimitSettings `yaml:"limits"`
+	Cache CacheSettings `yaml:"cache"`
+	Tracing TracingSettings `yaml:"tracing"`
+}
+
+// minimum length of the token signing key
+const MinSigningKeyLength = 16
+
+// settings used when not set by any source
+func DefaultSettings() Settings {
+	return Settings{
+		Listen: ":3000",
+		ShutdownTimeout: 30,
+		RatesFile: "rates.json",
+		Database: DatabaseSettings{ Driver: "postgres", MaxOpenConns: 20, MaxIdleConns: 5 },
+		Auth: AuthSettings{ BackOfficeID: "backoffice" },
+		Limits: LimitSettings{ MaxMetaSize: 51200, MaxObjectsPerRequest: 100 },
+		Cache: CacheSettings{ IssuerMetricsTTL: 60 },
+		Tracing: TracingSettings{ Exporter: "none", Endpoint: "http://localhost:4318" },
+	}
+}
+
+// a setting that can be set by an environment variable and a flag
+type setting struct {
+	env string
+	flag string
+	usage string
+	str *string
+	num *int
+}
+
+func (s *Settings) settings() []setting {
+	return []setting{
+		{ env: "OWNODE_LISTEN", flag: "listen", usage: "address to listen on", str: &s.Listen },
+		{ env: "OWNODE_SHUTDOWN_TIMEOUT", flag: "shutdown-timeout", usage: "seconds to wait for requests in flight when shutting down", num: &s.ShutdownTimeout },
+		{ env: "OWNODE_RATES_FILE", flag: "rates-file", usage: "exchange rates file", str: &s.RatesFile },
+		{ env: "OWNODE_DB_DRIVER", flag: "db-driver", usage: "database driver, postgres or sqlite3", str: &s.Database.Driver },
+		{ env: "OWNODE_DB_DSN", flag: "db-dsn", usage: "postgres connection string or sqlite file (:memory: for an in-memory database)", str: &s.Database.DSN },
+		{ env: "OWNODE_DB_MAX_OPEN_CONNS", flag: "db-max-open-conns", usage: "maximum open database connections", num: &s.Database.MaxOpenConns },
+		{ env: "OWNODE_DB_MAX_IDLE_CONNS", flag: "db-max-idle-conns", usage: "maximum idle database connections", num: &s.Database.MaxIdleConns },
+		{ env: "OWNODE_KEY", flag: "signing-key", usage: "key for signing tokens", str: &s.Auth.SigningKey },
+		{ env: "OWNODE_BACKOFFICE_ID", flag: "backoffice-id", usage: "back office client id", str: &s.Auth.BackOfficeID },
+		{ env: "OWNODE_BACKOFFICE_SECRET", flag: "backoffice-secret", usage: "back office client secret", str: &s.Auth.BackOfficeSecret },
+		{ env: "OWNODE_MAX_META_SIZE", flag: "max-meta-size", usage: "maximum size of meta in bytes", num: &s.Limits.MaxMetaSize },
+		{ env: "OWNODE_MAX_OBJECTS_PER_REQUEST", flag: "max-objects-per-request", usage: "maximum objects affected by a request", num: &s.Limits.MaxObjectsPerRequest },
+		{ env: "OWNODE_ISSUER_METRICS_CACHE_TTL", flag: "issuer-metrics-cache-ttl", usage: "seconds computed issuer metrics are reused, 0 disables caching", num: &s.Cache.IssuerMetricsTTL },
+		{ env: "OWNODE_TRACING_EXPORTER", flag: "tracing-exporter", usage: "trace exporter, none, otlp, stdout or file", str: &s.Tracing.Exporter },
+		{ env: "OWNODE_TRACING_ENDPOINT", flag: "tracing-endpoint", usage: "url of the otlp http endpoint traces are exported to", str: &s.Tracing.Endpoint },
+		{ env: "OWNODE_TRACING_FILE", flag: "tracing-file", usage: "file traces are exported to", str: &s.Tracing.File },
+	}
+}
+
+// load settings from the config file, environment variables and flags.
+// The config file is set by the -config flag or OWNODE_CONFIG. args are the
+// command line arguments without the program name. Returns the arguments left after the flags
+func LoadSettings(args []string, getenv func(string) string) (Settings, []string, error) {
+	s := DefaultSettings()
+
+	fs := flag.NewFlagSet("ownode", flag.ContinueOnError)
+	configFile := fs.String("config", getenv("OWNODE_CONFIG"), "yaml config file")
+	flagValues := map[string]*string{}
+	for _, st := range s.settings() {
+		flagValues[st.flag] = fs.String(st.flag, "", st.usage + " (env " + st.env + ")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return s, nil, err
+	}
+
+	if *configFile != "" {
+		data, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return s, nil, fmt.Errorf("config: %s", err)
+		}
+		if err := yaml.UnmarshalStrict(data, &s); err != nil {
+			return s, nil, fmt.Errorf("config: %s: %s", *configFile, err)
+		}
+	}
+
+	// flags override environment variables
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, st := range s.settings() {
+		value, source := getenv(st.env), st.env
+		if set[st.flag] {
+			value, source = *flagValues[st.flag], "-" + st.flag
+		} else if value == "" {
+			continue
+		}
+		if st.str != nil {
+			*st.str = value
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return s, nil, fmt.Errorf("config: %s must be a number", source)
+		}
+		*st.num = n
+	}
+
+	return s, fs.Args(), nil
+}
+
+// check that the settings are usable. Returns an error describing every problem
+func (s Settings) Validate() error {
+	problems := []string{}
+	if s.Listen == "" {
+		problems = append(problems, "listen address is required")
+	}
+	if s.ShutdownTimeout <= 0 {
+		problems = append(problems, "shutdown timeout must be greater than zero")
+	}
+	if s.Database.Driver != "postgres" && s.Database.Driver != "sqlite3" {
+		problems = append(problems, "database driver must be postgres or sqlite3 (OWNODE_DB_DRIVER)")
+	}
+	if s.Database.DSN == "" {
+		problems = append(problems, "database dsn is required (OWNODE_DB_DSN)")
+	}
+	if s.Database.MaxOpenConns < 0 || s.Database.MaxIdleConns < 0 {
+		problems = append(problems, "database pool sizes must not be negative")
+	}
+	if len(s.Auth.SigningKey) < MinSigningKeyLength {
+		problems = append(problems, fmt.Sprintf("signing key must be at least %d characters (OWNODE_KEY)", MinSigningKeyLength))
+	}
+	if s.Auth.BackOfficeID == "" || s.Auth.BackOfficeSecret == "" {
+		problems = append(problems, "back office id and secret are required (OWNODE_BACKOFFICE_ID, OWNODE_BACKOFFICE_SECRET)")
+	}
+	if s.Limits.MaxMetaSize <= 0 {
+		problems = append(problems, "max meta size must be greater than zero")
+	}
+	if s.Limits.MaxObjectsPerRequest <= 0 {
+		problems = append(problems, "max objects per request must be greater than zero")
+	}
+	if s.Cache.IssuerMetricsTTL < 0 {
+		problems = append(problems, "issuer metrics cache ttl must not be negative")
+	}
+	switch s.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if s.Tracing.Endpoint == "" {
+			problems = append(problems, "tracing endpoint is required by the otlp exporter (OWNODE_TRACING_ENDPOINT)")
+		}
+	case "file":
+		if s.Tracing.File == "" {
+			problems = append(problems, "tracing file is required by the file exporter (OWNODE_TRACING_FILE)")
+		}
+	default:
+		problems = append(problems, "tracing exporter must be none, otlp, stdout or file (OWNODE_TRACING_EXPORTER)")
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
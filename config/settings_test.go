@@ -0,0 +1,62 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSettings(t *testing.T) {
+	assert := assert.New(t)
+
+	file, err := ioutil.TempFile("", "ownode-config")
+	assert.Nil(err)
+	defer os.Remove(file.Name())
+	file.WriteString("listen: \":4000\"\ndatabase:\n  dsn: from-file\n  max_open_conns: 10\n")
+	file.Close()
+
+	env := map[string]string{ "OWNODE_CONFIG": file.Name(), "OWNODE_DB_DSN": "from-env", "OWNODE_DB_MAX_OPEN_CONNS": "30" }
+	getenv := func(key string) string { return env[key] }
+
+	s, args, err := LoadSettings([]string{ "-db-dsn", "from-flag", "rebuild-objects" }, getenv)
+	assert.Nil(err)
+	assert.Equal([]string{ "rebuild-objects" }, args)
+	assert.Equal("from-flag", s.Database.DSN)
+	assert.Equal(30, s.Database.MaxOpenConns)
+	assert.Equal(":4000", s.Listen)
+	assert.Equal(100, s.Limits.MaxObjectsPerRequest)
+	assert.Equal(60, s.Cache.IssuerMetricsTTL)
+
+	env["OWNODE_MAX_META_SIZE"] = "big"
+	_, _, err = LoadSettings([]string{}, getenv)
+	assert.NotNil(err)
+}
+
+func TestValidateSettings(t *testing.T) {
+	assert := assert.New(t)
+	s := DefaultSettings()
+	assert.NotNil(s.Validate())
+
+	s.Database.DSN = "dbname=ownode"
+	s.Auth.SigningKey = "0123456789abcdef"
+	s.Auth.BackOfficeSecret = "secret"
+	assert.Nil(s.Validate())
+
+	s.Database.Driver = "sqlite3"
+	s.Database.DSN = ":memory:"
+	assert.Nil(s.Validate())
+
+	s.Tracing.Exporter = "file"
+	assert.NotNil(s.Validate(), "file exporter requires a file")
+	s.Tracing.File = "traces.json"
+	assert.Nil(s.Validate())
+
+	s.Cache.IssuerMetricsTTL = -1
+	assert.NotNil(s.Validate())
+	s.Cache.IssuerMetricsTTL = 0
+	assert.Nil(s.Validate())
+
+	s.Database.Driver = "mysql"
+	assert.NotNil(s.Validate())
+}
@@ -6,34 +6,92 @@ import (
     "github.com/ownode/config"
     "github.com/ownode/models"
     jwt "github.com/dgrijalva/jwt-go"
+    "errors"
+    "strings"
     "time"
 )
 
-var (   
-    Auth AuthController
-    SigningKey string
-    BackOfficeId string
-    BackOfficeSecret string
-) 
+var Auth AuthController
 
 func init() {
     Auth = AuthController{ &Base }
-    SigningKey = services.GetEnvOrDefault("OWNODE_KEY", "sample_key")   //TODO: remove sample key
-    services.GetEnvOrDefault("OWNODE_KEY", "sample_key")
-    BackOfficeId = services.GetEnvOrDefault("OWNODE_BACKOFFICE_ID", "backoffice")
-    BackOfficeSecret = services.GetEnvOrDefault("OWNODE_BACKOFFICE_SECRET", "backofficesecret")
 }
 
 // create a jwt token
-func createJWTToken(serviceId string, backOffice bool, expires_in int64) (string, error) {
+func createJWTToken(signingKey string, serviceId string, backOffice bool, expires_in int64) (string, error) {
     token := jwt.New(jwt.SigningMethodHS256)
     token.Claims["service_id"] = serviceId
     token.Claims["expires_in"] = expires_in
     token.Claims["back_office"] = backOffice
-    tokenString, err := token.SignedString([]byte(SigningKey))
+    tokenString, err := token.SignedString([]byte(signingKey))
     return tokenString, err
 }
 
+// create a jwt token scoped to a wallet
+func createWalletJWTToken(signingKey string, serviceId, walletId string, expires_in int64) (string, error) {
+    token := jwt.New(jwt.SigningMethodHS256)
+    token.Claims["service_id"] = serviceId
+    token.Claims["wallet_id"] = walletId
+    token.Claims["expires_in"] = expires_in
+    tokenString, err := token.SignedString([]byte(signingKey))
+    return tokenString, err
+}
+
+// claims of an access token
+type authClaims struct {
+    ServiceID string
+    WalletID string
+    BackOffice bool
+}
+
+// parse and verify a jwt token. Back office tokens do not expire
+func parseJWTToken(signingKey string, tokenString string) (authClaims, error) {
+    claims := authClaims{}
+    token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+        if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+            return nil, errors.New("unexpected signing method")
+        }
+        return []byte(signingKey), nil
+    })
+    if err != nil {
+        return claims, err
+    } else if !token.Valid {
+        return claims, errors.New("invalid token")
+    }
+
+    claims.ServiceID, _ = token.Claims["service_id"].(string)
+    claims.WalletID, _ = token.Claims["wallet_id"].(string)
+    claims.BackOffice, _ = token.Claims["back_office"].(bool)
+
+    expiresIn, _ := token.Claims["expires_in"].(float64)
+    if !claims.BackOffice && int64(expiresIn) < time.Now().UTC().Unix() {
+        return claims, errors.New("token has expired")
+    }
+
+    return claims, nil
+}
+
+// parse a wallet scoped jwt token and return the id of the wallet
+// it grants access to. Returns an error if the token is invalid or expired
+func parseWalletJWTToken(signingKey string, tokenString string) (string, error) {
+    claims, err := parseJWTToken(signingKey, tokenString)
+    if err != nil {
+        return "", err
+    } else if claims.WalletID == "" {
+        return "", errors.New("token is not scoped to a wallet")
+    }
+    return claims.WalletID, nil
+}
+
+// get the claims of the bearer token of a request
+func requestClaims(signingKey string, req services.AuxRequestContext) (authClaims, error) {
+    authorization := req.Header.Get("Authorization")
+    if !services.StringStartsWith(strings.ToLower(authorization), "bearer ") {
+        return authClaims{}, errors.New("missing bearer token")
+    }
+    return parseJWTToken(signingKey, strings.TrimSpace(authorization[len("bearer "):]))
+}
+
 type tokenResp struct {
     Token string `json:"access_token"`
     TokenType string `json:"token_type"`
@@ -46,7 +104,7 @@ type AuthController struct {
 }
 
 // create authentication token for client_credentials grant type
-func (c *AuthController) GetToken(res http.ResponseWriter, req services.AuxRequestContext, log *config.CustomLog, db *services.DB) {
+func (c *AuthController) GetToken(res http.ResponseWriter, req services.AuxRequestContext, log *config.CustomLog, repos models.Repos) {
     
     // get grant type
     grantType := req.FormValue("grant_type")
@@ -54,13 +112,16 @@ func (c *AuthController) GetToken(res http.ResponseWriter, req services.AuxReque
     // launch the appropriate function to produce the token
     switch grantType {
     case "client_credentials":
-     c.GetClientCredentialToken(res, req, log, db)
+     c.GetClientCredentialToken(res, req, log, repos)
+     return
+    case "password":
+     c.GetWalletToken(res, req, log, repos)
      return
     }
 }
 
 // generate and return client_credentials token
-func (c *AuthController) GetClientCredentialToken(res http.ResponseWriter, req services.AuxRequestContext, log *config.CustomLog, db *services.DB) {
+func (c *AuthController) GetClientCredentialToken(res http.ResponseWriter, req services.AuxRequestContext, log *config.CustomLog, repos models.Repos) {
     
     // get base64 encoded credentials
     base64Credential := services.StringSplit(req.Header.Get("Authorization"), " ")[1]
@@ -68,12 +129,12 @@ func (c *AuthController) GetClientCredentialToken(res http.ResponseWriter, req s
     credentials := services.StringSplit(base64CredentialDecoded, ":")
 
     // check if requesting client is a back service id
-    if credentials[0] == BackOfficeId && credentials[1] == BackOfficeSecret {
+    if credentials[0] == c.settings.Auth.BackOfficeID && credentials[1] == c.settings.Auth.BackOfficeSecret {
         
         exp := int64(0)
-        token, err := createJWTToken("", true, exp)
+        token, err := createJWTToken(c.settings.Auth.SigningKey, "", true, exp)
         if err != nil {
-            log.Error(err)
+            req.Log.Error(err)
             services.Res(res).Error(500, "", "server error")
             return
         }
@@ -88,9 +149,9 @@ func (c *AuthController) GetClientCredentialToken(res http.ResponseWriter, req s
         }
 
         // persist token
-        err = models.CreateToken(db.GetPostgresHandle(), &newToken)
+        err = repos.Tokens().Create(&newToken)
         if err != nil {
-            c.log.Error(err.Error())
+            req.Log.Error(err.Error())
             services.Res(res).Error(500, "", "server error")
             return
         }
@@ -100,29 +161,28 @@ func (c *AuthController) GetClientCredentialToken(res http.ResponseWriter, req s
     }
 
     // find service by client id
-    service, found, err := models.FindServiceByClientId(db.GetPostgresHandle(), credentials[0]); 
+    service, found, err := repos.Services().FindByClientID(credentials[0]); 
     if !found && err == nil {
-        log.Error(err)
         services.Res(res).Error(404, "", "service not found")
         return
     } else if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
 
     // compare secret
     if credentials[1] != service.ClientSecret {
-        log.Error(err)
+        req.Log.Warn("invalid client secret for service " + service.ObjectID)
         services.Res(res).Error(401, "", "service credentials are invalid. ensure client id and secret are valid")
         return
     } 
     
     // create access token
     exp := time.Now().Add(time.Hour * 1) 
-    token, err := createJWTToken(service.ObjectID, false, exp.UTC().Unix())
+    token, err := createJWTToken(c.settings.Auth.SigningKey, service.ObjectID, false, exp.UTC().Unix())
     if err != nil {
-        log.Error(err)
+        req.Log.Error(err)
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -138,9 +198,9 @@ func (c *AuthController) GetClientCredentialToken(res http.ResponseWriter, req s
     }
     
     // persist token
-    err = models.CreateToken(db.GetPostgresHandle(), &newToken)
+    err = repos.Tokens().Create(&newToken)
     if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -148,4 +208,82 @@ func (c *AuthController) GetClientCredentialToken(res http.ResponseWriter, req s
     respObj, _ := services.StructToJsonToMap(newToken)
     respObj["service"] = services.DeleteKeys(respObj["service"].(map[string]interface{}), "client_id", "client_secret")
     services.Res(res).Json(respObj)
-}
\ No newline at end of file
+}
+
+// generate and return a token scoped to a wallet (password grant type).
+// The service authenticates with its credentials and the wallet with
+// its handle (`username`) and `password`
+func (c *AuthController) GetWalletToken(res http.ResponseWriter, req services.AuxRequestContext, log *config.CustomLog, repos models.Repos) {
+
+    // get base64 encoded credentials
+    base64Credential := services.StringSplit(req.Header.Get("Authorization"), " ")[1]
+    base64CredentialDecoded := services.DecodeB64(base64Credential)
+    credentials := services.StringSplit(base64CredentialDecoded, ":")
+    if len(credentials) != 2 {
+        services.Res(res).Error(401, "", "service credentials are invalid. ensure client id and secret are valid")
+        return
+    }
+
+    // find service by client id
+    service, found, err := repos.Services().FindByClientID(credentials[0])
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    } else if !found || credentials[1] != service.ClientSecret {
+        services.Res(res).Error(401, "", "service credentials are invalid. ensure client id and secret are valid")
+        return
+    }
+
+    handle := req.FormValue("username")
+    password := req.FormValue("password")
+    if c.validate.IsEmpty(handle) {
+        services.Res(res).Error(400, "missing_parameter", "Missing required field: username")
+        return
+    } else if c.validate.IsEmpty(password) {
+        services.Res(res).Error(400, "missing_parameter", "Missing required field: password")
+        return
+    }
+
+    // find wallet and compare password
+    wallet, found, err := repos.Wallets().FindByHandle(handle)
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    } else if !found || !services.BcryptCompare(req.Request.Context(), wallet.Password, password) {
+        services.Res(res).Error(401, "", "wallet credentials are invalid. ensure handle and password are valid")
+        return
+    }
+
+    // create access token
+    exp := time.Now().Add(time.Hour * 1)
+    token, err := createWalletJWTToken(c.settings.Auth.SigningKey, service.ObjectID, wallet.ObjectID, exp.UTC().Unix())
+    if err != nil {
+        req.Log.Error(err)
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    // create and save new token
+    newToken := models.Token {
+        Service: service,
+        Token: token,
+        Type: "bearer",
+        ExpiresIn: exp.UTC(),
+        CreatedAt: time.Now().UTC(),
+        UpdatedAt: time.Now().UTC(),
+    }
+
+    err = repos.Tokens().Create(&newToken)
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    respObj, _ := services.StructToJsonToMap(newToken)
+    respObj["service"] = services.DeleteKeys(respObj["service"].(map[string]interface{}), "client_id", "client_secret")
+    respObj["wallet"] = wallet.ObjectID
+    services.Res(res).Json(respObj)
+}
@@ -3,23 +3,43 @@ package controllers
 import (
 	"github.com/ownode/config"
 	"encoding/json"
+	"errors"
 	"github.com/ownode/services"
+	"github.com/ownode/models"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"github.com/jinzhu/gorm"
 )
 
 var MinimumObjectUnit = 0.00000001
-var MaxMetaSize = 51200
 var Base BaseController
 
+// returned from a transaction function after it wrote an error response.
+// The transaction is rolled back and nothing else is written
+var errResponseWritten = errors.New("error response written")
+
 func init() {
+	defaults := config.DefaultSettings()
 	Base = BaseController{
 		log: config.Log(),
 		validate: &services.CustomValidator{},
+		settings: &defaults,
 	}
+	issuerMetricsCache = newIssuerMetricsCache(&defaults)
 }
 
 type BaseController struct {
 	log *config.CustomLog
 	validate *services.CustomValidator
+	settings *config.Settings
+}
+
+// set the settings used by all controllers. Must be called before serving requests
+func Configure(settings *config.Settings) {
+	Base.settings = settings
+	issuerMetricsCache = newIssuerMetricsCache(settings)
 }
 
 // Parse json request body to struct
@@ -32,3 +52,171 @@ func (base *BaseController) ParseJsonBody(req services.AuxRequestContext, obj in
 	return nil
 }
 
+// check that meta satisfies the meta schema registered by an issuer.
+// returns the reason meta is invalid or an empty string if meta is valid
+// or the issuer has no meta schema
+func (base *BaseController) MetaSchemaError(issuer *models.Identity, meta models.Meta) (string, error) {
+	if issuer == nil || issuer.MetaSchema == "" || meta.IsEmpty() {
+		return "", nil
+	}
+	reasons, err := services.ValidateJSONAgainstSchema(issuer.MetaSchema, string(meta))
+	if err != nil {
+		return "", err
+	}
+	if len(reasons) > 0 {
+		return "meta does not match the issuer's meta schema: " + strings.Join(reasons, "; "), nil
+	}
+	return "", nil
+}
+
+// authorize the caller of a list endpoint using the request's bearer token.
+// Returns the calling service or nil for back office callers. Writes an error
+// response and returns false if the caller is not authorized
+func (base *BaseController) ListCaller(req services.AuxRequestContext, res http.ResponseWriter, db *services.DB) (*models.Service, bool) {
+	claims, err := requestClaims(base.settings.Auth.SigningKey, req)
+	if err != nil {
+		services.Res(res).Error(401, "unauthorized", "access token is missing, invalid or has expired")
+		return nil, false
+	} else if claims.BackOffice {
+		return nil, true
+	} else if claims.WalletID != "" {
+		services.Res(res).Error(403, "forbidden", "wallet tokens cannot list this collection")
+		return nil, false
+	}
+
+	service, found, err := models.FindServiceByObjectID(db.GetPostgresHandle(), claims.ServiceID)
+	if err != nil {
+		req.Log.Error(err.Error())
+		services.Res(res).Error(500, "", "server error")
+		return nil, false
+	} else if !found {
+		services.Res(res).Error(401, "unauthorized", "service not found")
+		return nil, false
+	}
+	return &service, true
+}
+
+// run a list query of the request and write the page of results. dest is a pointer to a slice
+// the results are fetched into. preload lists associations to load
+func (base *BaseController) WriteList(req services.AuxRequestContext, res http.ResponseWriter, listing *services.Listing, db *gorm.DB, dest interface{}, preload ...string) {
+	listQuery, err := listing.Parse(db, req.URL.Query())
+	if listErr, ok := err.(*services.ListError); ok {
+		services.Res(res).ErrParam(listErr.Param).Error(400, "invalid_parameter", listErr.Message)
+		return
+	} else if err != nil {
+		req.Log.Error(err.Error())
+		services.Res(res).Error(500, "", "server error")
+		return
+	}
+
+	var count int64
+	if err := listQuery.DB.Count(&count).Error; err != nil {
+		req.Log.Error(err.Error())
+		services.Res(res).Error(500, "", "server error")
+		return
+	}
+
+	page := listQuery.Page()
+	for _, association := range preload {
+		page = page.Preload(association)
+	}
+	nextCursor, err := listQuery.Find(page, dest)
+	if err != nil {
+		req.Log.Error(err.Error())
+		services.Res(res).Error(500, "", "server error")
+		return
+	}
+
+	services.Res(res).Json(map[string]interface{}{
+		"results": dest,
+		"_metadata": map[string]interface{}{
+			"total_count": count,
+			"limit": listQuery.Limit,
+			"has_more": nextCursor != "",
+			"next_cursor": nextCursor,
+		},
+	})
+}
+
+// filters on the creation date of items of a table
+func createdRangeFilters(table string) []services.ListFilter {
+	return []services.ListFilter{
+		services.TimeFilter("filter_gte_date_created", table + ".created_at", ">="),
+		services.TimeFilter("filter_lte_date_created", table + ".created_at", "<="),
+	}
+}
+
+// listing sorted by id or creation date with creation date filters
+func newListing(table string, filters ...services.ListFilter) *services.Listing {
+	return &services.Listing{
+		Sorts: map[string]string{
+			"id": table + ".id",
+			"created_at": table + ".created_at",
+		},
+		SortFields: map[string]string{
+			"created_at": "CreatedAt",
+		},
+		DefaultSort: "id",
+		IDColumn: table + ".id",
+		IDField: "ID",
+		FilterPrefix: "filter_",
+		DefaultLimit: 20,
+		MaxLimit: 100,
+		Filters: append(createdRangeFilters(table), filters...),
+	}
+}
+
+// filter identities by base currency
+var baseCurrencyFilter = services.ListFilter{ Param: "filter_base_currency", Apply: func(db *gorm.DB, value string) (*gorm.DB, error) {
+	if _, found := config.FindCurrency(value); !found {
+		return db, &services.ListError{ Param: "filter_base_currency", Message: "filter_base_currency: base currency is unknown" }
+	}
+	return db.Where("UPPER(identities.base_currency) = ?", strings.ToUpper(value)), nil
+}}
+
+// check that an amount has no more decimal places than the base currency of
+// an issuer allows. Returns the reason if it has
+func amountPrecisionError(issuer *models.Identity, amount float64) string {
+	if issuer == nil {
+		return ""
+	}
+	currency, found := config.FindCurrency(strings.ToUpper(issuer.BaseCurrency))
+	if !found || currency.ValidAmount(amount) {
+		return ""
+	}
+	return fmt.Sprintf("amount has more decimal places than %s allows (%d)", currency.Code, currency.Places())
+}
+
+// decimal places of amounts of an issuer's base currency. Issuers
+// without a known currency use the precision of the minimum object unit
+func issuerPlaces(issuer *models.Identity) int {
+	if issuer != nil {
+		if currency, found := config.FindCurrency(strings.ToUpper(issuer.BaseCurrency)); found {
+			return currency.Places()
+		}
+	}
+	return config.Currency{ MinorUnits: -1 }.Places()
+}
+
+// an amount in the smallest units of a currency with `places` decimal places
+func amountUnits(amount float64, places int) int64 {
+	return int64(math.Round(amount * math.Pow(10, float64(places))))
+}
+
+// split an amount into n equal parts with `places` decimal places. The remainder
+// of the division is added to the last part so the parts sum to the amount.
+// Returns false if the amount is too small to give every part a unit
+func splitAmount(amount float64, n, places int) ([]float64, bool) {
+	units := amountUnits(amount, places)
+	part := units / int64(n)
+	if part < 1 {
+		return nil, false
+	}
+	scale := math.Pow(10, float64(places))
+	parts := make([]float64, n)
+	for i := range parts {
+		parts[i] = float64(part) / scale
+	}
+	parts[n - 1] = float64(units - part * int64(n - 1)) / scale
+	return parts, true
+}
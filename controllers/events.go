@@ -0,0 +1,178 @@
+package controllers
+
+import (
+    "github.com/ownode/models"
+    "github.com/ownode/services"
+    "github.com/jinzhu/gorm"
+    "encoding/json"
+    "database/sql"
+    "time"
+)
+
+var (
+    EventObjectCreated = "object.created"
+    EventObjectMerged = "object.merged"
+    EventObjectDivided = "object.divided"
+    EventObjectSubtracted = "object.subtracted"
+    EventObjectCharged = "object.charged"
+    EventObjectOpened = "object.opened"
+    EventObjectLocked = "object.locked"
+    EventObjectRedeemed = "object.redeemed"
+    EventWalletLocked = "wallet.locked"
+    EventWalletOpened = "wallet.opened"
+
+    // all events services can subscribe to
+    AllEvents = []string{
+        EventObjectCreated, EventObjectMerged, EventObjectDivided, EventObjectSubtracted, EventObjectCharged,
+        EventObjectOpened, EventObjectLocked, EventObjectRedeemed, EventWalletLocked, EventWalletOpened,
+    }
+)
+
+// representation of an object in event payloads. pins are masked
+func objectEventData(object models.Object) map[string]interface{} {
+    return map[string]interface{}{
+        "id": object.ObjectID,
+        "pin": services.MaskPin(object.Pin),
+        "type": object.Type,
+        "balance": object.Balance,
+        "meta": object.Meta,
+        "open": object.Open,
+        "open_method": object.OpenMethod,
+        "wallet": object.Wallet.ObjectID,
+        "service": object.Service.ObjectID,
+        "created_at": object.CreatedAt,
+    }
+}
+
+// representation of a list of objects in event payloads
+func objectsEventData(objects []models.Object) []map[string]interface{} {
+    data := []map[string]interface{}{}
+    for _, object := range objects {
+        data = append(data, objectEventData(object))
+    }
+    return data
+}
+
+// representation of a wallet in event payloads
+func walletEventData(wallet models.Wallet) map[string]interface{} {
+    return map[string]interface{}{
+        "id": wallet.ObjectID,
+        "handle": wallet.Handle,
+        "lock": wallet.Lock,
+    }
+}
+
+// record a domain event of the objects created or updated and the objects deleted by a mutation.
+// Must be called with the transaction of the mutation
+func appendObjectEvent(dbTx *gorm.DB, eventType string, objects []models.Object, deleted ...models.Object) error {
+    return models.AppendObjectEvent(dbTx, services.NewObjectID(), eventType, objects, deleted)
+}
+
+// queue an event for delivery to the webhook endpoints of the services involved in it
+// and record it in the activity ledger of the given wallets.
+// The services involved are the given services and the services that own the given wallets.
+// Must be called with the transaction of the mutation that caused the event so the
+// event is only delivered if the mutation is committed
+func queueEvent(dbTx *gorm.DB, event string, data map[string]interface{}, serviceIDs []uint, wallets ...models.Wallet) error {
+
+    eventID := services.NewObjectID()
+    if err := recordWalletEvents(dbTx, eventID, event, data, wallets); err != nil {
+        return err
+    }
+
+    recipients := map[uint]struct{}{}
+    for _, id := range serviceIDs {
+        recipients[id] = struct{}{}
+    }
+
+    // add the services that own the wallets
+    seenIdentities := map[uint]struct{}{}
+    for _, wallet := range wallets {
+        identityID := wallet.Identity.ID
+        if wallet.IdentityID.Valid {
+            identityID = uint(wallet.IdentityID.Int64)
+        }
+        if _, seen := seenIdentities[identityID]; seen || identityID == 0 {
+            continue
+        }
+        seenIdentities[identityID] = struct{}{}
+
+        owners, err := models.FindServicesByIdentityID(dbTx, identityID)
+        if err != nil {
+            return err
+        }
+        for _, owner := range owners {
+            recipients[owner.ID] = struct{}{}
+        }
+    }
+
+    ids := []uint{}
+    for id := range recipients {
+        ids = append(ids, id)
+    }
+
+    endpoints, err := models.FindActiveWebhookEndpointsByServiceIDs(dbTx, ids)
+    if err != nil || len(endpoints) == 0 {
+        return err
+    }
+
+    payload, err := json.Marshal(map[string]interface{}{
+        "id": eventID,
+        "event": event,
+        "created_at": time.Now().UTC(),
+        "data": data,
+    })
+    if err != nil {
+        return err
+    }
+
+    for _, endpoint := range endpoints {
+        if !endpoint.Subscribed(event) {
+            continue
+        }
+
+        delivery := models.WebhookDelivery{
+            ObjectID: services.NewObjectID(),
+            EndpointID: sql.NullInt64{ Int64: int64(endpoint.ID), Valid: true },
+            EventID: eventID,
+            Event: event,
+            Payload: string(payload),
+            Status: models.DeliveryPending,
+            NextAttemptAt: time.Now().UTC(),
+        }
+
+        if err := models.CreateWebhookDelivery(dbTx, &delivery); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// add an event to the activity ledger of each wallet once
+func recordWalletEvents(dbTx *gorm.DB, eventID, event string, data map[string]interface{}, wallets []models.Wallet) error {
+
+    ledgerData, err := json.Marshal(map[string]interface{}{ "id": eventID, "data": data })
+    if err != nil {
+        return err
+    }
+
+    seen := map[uint]struct{}{}
+    for _, wallet := range wallets {
+        if _, ok := seen[wallet.ID]; ok || wallet.ID == 0 {
+            continue
+        }
+        seen[wallet.ID] = struct{}{}
+
+        walletEvent := models.WalletEvent{
+            WalletID: sql.NullInt64{ Int64: int64(wallet.ID), Valid: true },
+            Event: event,
+            Data: models.Meta(ledgerData),
+        }
+        if err := models.CreateWalletEvent(dbTx, &walletEvent); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
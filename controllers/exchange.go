@@ -0,0 +1,161 @@
+package controllers
+
+import (
+    "net/http"
+    "github.com/ownode/models"
+    "github.com/ownode/services"
+    "github.com/ownode/config"
+    "fmt"
+    "math"
+    "strconv"
+    "time"
+)
+
+var Exchange ExchangeController
+
+// how long the rate of a quote is locked
+var QuoteTTL = 5 * time.Minute
+
+type quoteCreateBody struct {
+    From string `json:"from"`
+    Amount float64 `json:"amount"`
+}
+
+func init() {
+    Exchange = ExchangeController{ &Base }
+}
+
+type ExchangeController struct {
+    *BaseController
+}
+
+// round an amount up to the smallest unit of a currency
+func roundUpToUnit(amount float64, currency config.Currency) float64 {
+    units := amount / currency.Unit()
+    return services.ToFixed(math.Ceil(units - 1e-6) * currency.Unit(), currency.Places())
+}
+
+// get the rate between two currencies. writes an error response and returns false if not available
+func (c *ExchangeController) rate(from, to string, req services.AuxRequestContext, res http.ResponseWriter) (services.Rate, bool) {
+    if _, found := config.FindCurrency(from); !found {
+        services.Res(res).ErrParam("from").Error(400, "invalid_parameter", "from is not a known currency")
+        return services.Rate{}, false
+    }
+    if _, found := config.FindCurrency(to); !found {
+        services.Res(res).ErrParam("to").Error(400, "invalid_parameter", "to is not a known currency")
+        return services.Rate{}, false
+    }
+    if services.Rates == nil {
+        services.Res(res).Error(503, "rates_unavailable", "exchange rates are not available")
+        return services.Rate{}, false
+    }
+    rate, err := services.Rates.Rate(from, to)
+    if err == services.ErrRateNotFound {
+        services.Res(res).Error(404, "not_found", fmt.Sprintf("no exchange rate from %s to %s", from, to))
+        return rate, false
+    } else if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return rate, false
+    }
+    return rate, true
+}
+
+// convert an amount between currencies at the current rate.
+// requires `from`, `to` and `amount`
+func (c *ExchangeController) Convert(res http.ResponseWriter, req services.AuxRequestContext) {
+
+    query := req.URL.Query()
+    amount, err := strconv.ParseFloat(query.Get("amount"), 64)
+    if err != nil || amount < MinimumObjectUnit {
+        services.Res(res).ErrParam("amount").Error(400, "invalid_parameter", fmt.Sprintf("amount must be a number not less than %.8f", MinimumObjectUnit))
+        return
+    }
+
+    rate, ok := c.rate(query.Get("from"), query.Get("to"), req, res)
+    if !ok {
+        return
+    }
+
+    services.Res(res).Json(map[string]interface{}{
+        "from": rate.From,
+        "to": rate.To,
+        "rate": rate.Value,
+        "rate_time": rate.Time,
+        "amount": amount,
+        "converted_amount": services.ToFixed(rate.Convert(amount), config.Currencies[rate.To].Places()),
+    })
+}
+
+// quote the amount of `from` currency objects needed to pay `amount` in the base currency
+// of the authorizing service's issuer. The rate is locked until the quote expires.
+// A quote is used by passing its id as `quote_id` when charging
+func (c *ExchangeController) CreateQuote(res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    // TODO: get client id from access token
+    clientID := "kl14zFDq4SHlmmmVNHgLtE0LqCo8BTjyShOH"
+
+    var body quoteCreateBody
+    if err := c.ParseJsonBody(req, &body); err != nil {
+        services.Res(res).Error(400, "invalid_body", "request body is invalid or malformed. Expects valid json body")
+        return
+    }
+
+    if body.Amount < MinimumObjectUnit {
+        services.Res(res).ErrParam("amount").Error(400, "invalid_parameter", fmt.Sprintf("amount must not be less than %.8f", MinimumObjectUnit))
+        return
+    }
+
+    dbCon := db.GetPostgresHandle()
+    service, found, err := models.FindServiceByClientId(dbCon, clientID)
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    } else if !found {
+        services.Res(res).Error(401, "unauthorized", "service not found")
+        return
+    }
+
+    // only issuers can charge
+    if service.Identity == nil || !service.Identity.Issuer {
+        services.Res(res).Error(400, "invalid_service", "service is not an issuer")
+        return
+    }
+
+    to := service.Identity.BaseCurrency
+    if body.From == to {
+        services.Res(res).ErrParam("from").Error(400, "invalid_parameter", "from must differ from the issuer's base currency")
+        return
+    }
+
+    if reason := amountPrecisionError(service.Identity, body.Amount); reason != "" {
+        services.Res(res).ErrParam("amount").Error(400, "invalid_parameter", "amount: " + reason)
+        return
+    }
+
+    rate, ok := c.rate(body.From, to, req, res)
+    if !ok {
+        return
+    }
+
+    quote := models.Quote{
+        ObjectID: services.NewObjectID(),
+        ServiceID: service.ID,
+        FromCurrency: rate.From,
+        ToCurrency: rate.To,
+        Rate: rate.Value,
+        Amount: body.Amount,
+        SourceAmount: roundUpToUnit(body.Amount / rate.Value, config.Currencies[rate.From]),
+        RateTime: rate.Time,
+        ExpiresAt: time.Now().UTC().Add(QuoteTTL),
+    }
+
+    if err := models.CreateQuote(dbCon, &quote); err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    services.Res(res).Json(quote)
+}
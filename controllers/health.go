@@ -0,0 +1,76 @@
+package controllers
+
+import (
+    "context"
+    "net/http"
+    "time"
+    "github.com/ownode/config"
+    "github.com/ownode/services"
+)
+
+var Health HealthController
+
+// time allowed for the database to answer the readiness probe
+var ReadinessPingTimeout = 2 * time.Second
+
+func init() {
+    Health = HealthController{ &Base }
+}
+
+type HealthController struct {
+    *BaseController
+}
+
+// a background worker the server needs to serve requests
+type Worker interface {
+    Running() bool
+}
+
+// workers checked by the readiness probe, by name
+type Workers map[string]Worker
+
+// report that the process is up. Does not check dependencies
+func (c *HealthController) Healthz(res http.ResponseWriter) {
+    services.Res(res).Json(map[string]string{ "status": "ok" })
+}
+
+// report whether the server can serve requests. The database must be reachable,
+// its migrations current and the background workers running. Responds with
+// 503 and the reason of every failed check otherwise
+func (c *HealthController) Readyz(res http.ResponseWriter, req services.AuxRequestContext, db *services.DB, workers Workers) {
+    checks := map[string]string{}
+    ready := true
+    fail := func(name, reason string) {
+        checks[name] = reason
+        ready = false
+    }
+
+    ctx, cancel := context.WithTimeout(req.Request.Context(), ReadinessPingTimeout)
+    defer cancel()
+    if err := db.Ping(ctx); err != nil {
+        req.Log.Error("readiness: " + err.Error())
+        fail("database", "database is unreachable")
+        fail("migrations", "database is unreachable")
+    } else {
+        checks["database"] = "ok"
+        if err := config.CheckMigrations(db); err != nil {
+            fail("migrations", err.Error())
+        } else {
+            checks["migrations"] = "ok"
+        }
+    }
+
+    for name, worker := range workers {
+        if worker.Running() {
+            checks[name] = "ok"
+        } else {
+            fail(name, "not running")
+        }
+    }
+
+    if !ready {
+        services.Res(res).StatusCode(503).Json(map[string]interface{}{ "status": "unavailable", "checks": checks })
+        return
+    }
+    services.Res(res).Json(map[string]interface{}{ "status": "ready", "checks": checks })
+}
@@ -3,11 +3,11 @@ package controllers
 import (
     "net/http"
     "github.com/ownode/models"
-	"gopkg.in/mgo.v2/bson"
     "github.com/ownode/services"
     "github.com/ownode/config"
     "github.com/go-martini/martini"
     validator "github.com/asaskevich/govalidator"
+    "github.com/ownode/metrics"
 )
 
 var Identity IdentityController
@@ -33,7 +33,7 @@ type IdentityController struct {
 }
 
 // create an identity
-func (c *IdentityController) Create(res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+func (c *IdentityController) Create(res http.ResponseWriter, req services.AuxRequestContext, repos models.Repos) {
     
     // parse request body
     var body identityCreateBody
@@ -62,7 +62,7 @@ func (c *IdentityController) Create(res http.ResponseWriter, req services.AuxReq
 
     // create identity
     newIdentity := models.Identity {
-        ObjectID: bson.NewObjectId().Hex(),
+        ObjectID: services.NewObjectID(),
         FullName: body.FullName,
         Email: body.Email,
     }
@@ -83,8 +83,8 @@ func (c *IdentityController) Create(res http.ResponseWriter, req services.AuxReq
         }
 
         // base currency must be supported
-        if !services.StringInStringSlice(config.SupportedBaseCurrencies, body.BaseCurrency){
-            services.Res(res).Error(400, "invalid_base_currency", "base currency is unknown")
+        if !config.IsActiveCurrency(body.BaseCurrency) {
+            services.Res(res).Error(400, "invalid_base_currency", "base currency is unknown or no longer in use")
             return
         }
 
@@ -93,9 +93,9 @@ func (c *IdentityController) Create(res http.ResponseWriter, req services.AuxReq
         newIdentity.BaseCurrency = body.BaseCurrency
     }
 
-    err := models.CreateIdentity(db.GetPostgresHandle(), &newIdentity)
+    err := repos.Identities().Create(&newIdentity)
     if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -112,7 +112,7 @@ func (c *IdentityController) Create(res http.ResponseWriter, req services.AuxReq
 }
 
 // renew an issuer identity soul
-func (c *IdentityController) RenewSoul(res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+func (c *IdentityController) RenewSoul(res http.ResponseWriter, req services.AuxRequestContext, repos models.Repos) {
     
     // parse request body
     var body soulRenewBody
@@ -140,12 +140,12 @@ func (c *IdentityController) RenewSoul(res http.ResponseWriter, req services.Aux
     }
 
     // ensure identity exists
-    identity, found, err := models.FindIdentityByObjectID(db.GetPostgresHandle(), body.IdentityId)
+    identity, found, err := repos.Identities().FindByObjectID(body.IdentityId)
     if !found {
         services.Res(res).Error(404, "invalid_identity", "identity_id is unknown")
         return
     } else if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -157,12 +157,13 @@ func (c *IdentityController) RenewSoul(res http.ResponseWriter, req services.Aux
     }
 
     // add to soul balance
-    newIdentity, err := models.AddToSoulByObjectID(db.GetPostgresHandle(), identity.ObjectID, body.SoulBalance)
+    newIdentity, err := repos.Identities().AddToSoul(identity.ObjectID, body.SoulBalance)
     if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
+    metrics.SoulRenewed.WithLabelValues(newIdentity.ObjectName).Add(body.SoulBalance)
 
     // create response, hide some fields
     respObj, _ := services.StructToJsonToMap(newIdentity)
@@ -175,14 +176,14 @@ func (c *IdentityController) RenewSoul(res http.ResponseWriter, req services.Aux
 }
 
 // get an identity
-func(c *IdentityController) Get(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+func(c *IdentityController) Get(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, repos models.Repos) {
     
-    identity, found, err := models.FindIdentityByObjectID(db.GetPostgresHandle(), params["id"])
+    identity, found, err := repos.Identities().FindByObjectID(params["id"])
     if !found {
         services.Res(res).Error(404, "not_found", "identity was not found")
         return
     } else if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -195,4 +196,24 @@ func(c *IdentityController) Get(params martini.Params, res http.ResponseWriter,
     }
 
     services.Res(res).Json(respObj)
-}
\ No newline at end of file
+}
+
+var identitiesListing = newListing("identities", services.BoolFilter("filter_issuer", "identities.issuer"), baseCurrencyFilter)
+
+// list identities. A service sees its own identity, back office sees all.
+// supports cursor, limit, sort (id, created_at) and filter_issuer, filter_base_currency,
+// filter_gte_date_created, filter_lte_date_created
+func (c *IdentityController) List(res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    caller, ok := c.ListCaller(req, res, db)
+    if !ok {
+        return
+    }
+
+    dbCon := db.GetPostgresHandle().Model(&models.Identity{})
+    if caller != nil {
+        dbCon = dbCon.Where("identities.id = ?", caller.IdentityID.Int64)
+    }
+
+    c.WriteList(req, res, identitiesListing, dbCon, &[]models.Identity{})
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+    "encoding/json"
+    "net/http/httptest"
+    "testing"
+    "github.com/go-martini/martini"
+    "github.com/ownode/models"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestIdentityCreateRenewSoulAndGet(t *testing.T) {
+    assert := assert.New(t)
+    repos := models.NewMemoryRepos()
+
+    res := httptest.NewRecorder()
+    Identity.Create(res, newJsonRequest(`{"full_name":"john doe","email":"not an email"}`), repos)
+    assert.Equal(400, errorStatus(res))
+
+    // issuers need an object name and a currency in use
+    req := newJsonRequest(`{"full_name":"gold shop","email":"gold@shop.com","object_name":"gold","base_currency":"XXX"}`)
+    req.SetData("isIssuer", true)
+    res = httptest.NewRecorder()
+    Identity.Create(res, req, repos)
+    assert.Equal(400, errorStatus(res))
+
+    req = newJsonRequest(`{"full_name":"gold shop","email":"gold@shop.com","object_name":"gold","base_currency":"USD"}`)
+    req.SetData("isIssuer", true)
+    res = httptest.NewRecorder()
+    Identity.Create(res, req, repos)
+    assert.Equal(200, res.Code)
+    var created map[string]interface{}
+    assert.Nil(json.Unmarshal(res.Body.Bytes(), &created))
+    assert.Equal(true, created["issuer"])
+
+    // soul can only be renewed for known issuers
+    res = httptest.NewRecorder()
+    Identity.RenewSoul(res, newJsonRequest(`{"identity_id":"unknown","soul_balance":10}`), repos)
+    assert.Equal(404, errorStatus(res))
+
+    res = httptest.NewRecorder()
+    Identity.RenewSoul(res, newJsonRequest(`{"identity_id":"` + created["id"].(string) + `","soul_balance":10}`), repos)
+    assert.Equal(200, res.Code)
+
+    res = httptest.NewRecorder()
+    Identity.Get(martini.Params{ "id": created["id"].(string) }, res, newJsonRequest(""), repos)
+    assert.Equal(200, res.Code)
+    var identity map[string]interface{}
+    assert.Nil(json.Unmarshal(res.Body.Bytes(), &identity))
+    assert.Equal(10.0, identity["soul_balance"])
+
+    res = httptest.NewRecorder()
+    Identity.Get(martini.Params{ "id": "unknown" }, res, newJsonRequest(""), repos)
+    assert.Equal(404, errorStatus(res))
+}
@@ -2,10 +2,16 @@ package controllers
 
 import (
     "net/http"
-    // "github.com/ownode/models"
-	// "gopkg.in/mgo.v2/bson"
+    "github.com/ownode/config"
+    "github.com/ownode/models"
     "github.com/ownode/services"
-    // "time"
+    "github.com/go-martini/martini"
+    "io/ioutil"
+    "encoding/json"
+    "github.com/jinzhu/gorm"
+    "fmt"
+    "strconv"
+    "time"
     // validator "github.com/asaskevich/govalidator"
 )
 
@@ -20,7 +26,208 @@ type IssuerController struct {
 }
 
 // create an issuer enabled identity
-func (c *IssuerController) Create(res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+func (c *IssuerController) Create(res http.ResponseWriter, req services.AuxRequestContext, repos models.Repos) {
     req.SetData("isIssuer", true)
-    Identity.Create(res, req, db)
-}
\ No newline at end of file
+    Identity.Create(res, req, repos)
+}
+
+// find the issuer identity of the request and ensure the authorizing
+// service owns the issuer. writes an error response and returns false if not
+func (c *IssuerController) authorizedIssuer(id string, req services.AuxRequestContext, res http.ResponseWriter, db *services.DB) (models.Identity, bool) {
+
+    // TODO: get client id from access token
+    clientID := "kl14zFDq4SHlmmmVNHgLtE0LqCo8BTjyShOH"
+
+    identity, found, err := models.FindIdentityByObjectID(db.GetPostgresHandle(), id)
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return identity, false
+    } else if !found {
+        services.Res(res).Error(404, "not_found", "issuer was not found")
+        return identity, false
+    }
+
+    // ensure identity is an issuer
+    if !identity.Issuer {
+        services.Res(res).Error(400, "invalid_identity", "identity is not an issuer")
+        return identity, false
+    }
+
+    // ensure the authorizing service owns the issuer
+    service, _, _ := models.FindServiceByClientId(db.GetPostgresHandle(), clientID)
+    if service.Identity == nil || service.Identity.ObjectID != identity.ObjectID {
+        services.Res(res).Error(401, "unauthorized", "service does not own this issuer")
+        return identity, false
+    }
+
+    return identity, true
+}
+
+// get the meta schema of an issuer
+func (c *IssuerController) GetMetaSchema(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    identity, ok := c.authorizedIssuer(params["id"], req, res, db)
+    if !ok {
+        return
+    }
+
+    var schema interface{}
+    if identity.MetaSchema != "" {
+        schema = json.RawMessage(identity.MetaSchema)
+    }
+
+    services.Res(res).Json(map[string]interface{}{
+        "id": identity.ObjectID,
+        "meta_schema": schema,
+    })
+}
+
+// set the json schema that meta of objects issued by the issuer must satisfy.
+// The request body is the json schema
+func (c *IssuerController) SetMetaSchema(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    body, err := ioutil.ReadAll(req.Body)
+    if err != nil || len(body) == 0 {
+        services.Res(res).Error(400, "invalid_body", "request body is invalid or malformed. Expects a json schema")
+        return
+    }
+
+    // ensure schema is valid
+    if err := services.ValidateJSONSchema(string(body)); err != nil {
+        services.Res(res).Error(400, "invalid_meta_schema", err.Error())
+        return
+    }
+
+    identity, ok := c.authorizedIssuer(params["id"], req, res, db)
+    if !ok {
+        return
+    }
+
+    identity.MetaSchema = string(body)
+    if err := db.GetPostgresHandle().Save(&identity).Error; err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    services.Res(res).Json(map[string]interface{}{
+        "id": identity.ObjectID,
+        "meta_schema": json.RawMessage(identity.MetaSchema),
+    })
+}
+
+// remove the meta schema of an issuer
+func (c *IssuerController) DeleteMetaSchema(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    identity, ok := c.authorizedIssuer(params["id"], req, res, db)
+    if !ok {
+        return
+    }
+
+    identity.MetaSchema = ""
+    if err := db.GetPostgresHandle().Save(&identity).Error; err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    services.Res(res).Json(map[string]interface{}{
+        "id": identity.ObjectID,
+        "meta_schema": nil,
+    })
+}
+
+var issuersListing = newListing("identities", baseCurrencyFilter, services.ListFilter{ Param: "filter_object_name", Apply: func(db *gorm.DB, value string) (*gorm.DB, error) {
+    return db.Where("identities.object_name = ?", value), nil
+}})
+
+// list issuers. Issuers are visible to every authorized caller.
+// supports cursor, limit, sort (id, created_at) and filter_base_currency, filter_object_name,
+// filter_gte_date_created, filter_lte_date_created
+func (c *IssuerController) List(res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    if _, ok := c.ListCaller(req, res, db); !ok {
+        return
+    }
+
+    dbCon := db.GetPostgresHandle().Model(&models.Identity{}).Where("identities.issuer = ?", true)
+    c.WriteList(req, res, issuersListing, dbCon, &[]models.Identity{})
+}
+
+// maximum number of top wallets in issuer metrics
+var MaxIssuerTopWallets = 50
+
+// computed issuer metrics. Created from the settings by Configure
+var issuerMetricsCache *services.TTLCache
+
+// create the cache of issuer metrics with the ttl of the settings
+func newIssuerMetricsCache(settings *config.Settings) *services.TTLCache {
+    return services.NewTTLCache(time.Duration(settings.Cache.IssuerMetricsTTL) * time.Second)
+}
+
+// get the metrics of an issuer: soul balance, outstanding balance of value objects,
+// number of holders, objects created, charges and redemptions between `from` and `to`
+// (unix timestamps, defaults to the last 30 days) and the `top` (default 10) wallets by balance
+func (c *IssuerController) Metrics(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    identity, ok := c.authorizedIssuer(params["id"], req, res, db)
+    if !ok {
+        return
+    }
+
+    // the default end is aligned to the cache ttl so that cached metrics can be reused
+    query := req.URL.Query()
+    to := time.Now().UTC()
+    if ttl := issuerMetricsCache.TTL(); ttl > 0 {
+        to = to.Truncate(ttl)
+    }
+    if qTo := query.Get("to"); !c.validate.IsEmpty(qTo) {
+        ts, err := strconv.ParseInt(qTo, 10, 64)
+        if err != nil {
+            services.Res(res).ErrParam("to").Error(400, "invalid_parameter", "to must be a unix timestamp")
+            return
+        }
+        to = services.UnixToTime(ts).UTC()
+    }
+    from := to.AddDate(0, 0, -30)
+    if qFrom := query.Get("from"); !c.validate.IsEmpty(qFrom) {
+        ts, err := strconv.ParseInt(qFrom, 10, 64)
+        if err != nil {
+            services.Res(res).ErrParam("from").Error(400, "invalid_parameter", "from must be a unix timestamp")
+            return
+        }
+        from = services.UnixToTime(ts).UTC()
+    }
+    if to.Before(from) {
+        services.Res(res).ErrParam("to").Error(400, "invalid_parameter", "to must not be before from")
+        return
+    }
+
+    top := 10
+    if qTop := query.Get("top"); !c.validate.IsEmpty(qTop) {
+        n, err := strconv.Atoi(qTop)
+        if err != nil || n < 0 || n > MaxIssuerTopWallets {
+            services.Res(res).ErrParam("top").Error(400, "invalid_parameter", fmt.Sprintf("top must be a number between 0 and %d", MaxIssuerTopWallets))
+            return
+        }
+        top = n
+    }
+
+    // reuse recently computed metrics
+    cacheKey := fmt.Sprintf("%s:%d:%d:%d", identity.ObjectID, from.Unix(), to.Unix(), top)
+    if metrics, found := issuerMetricsCache.Get(cacheKey); found {
+        services.Res(res).Json(metrics)
+        return
+    }
+
+    metrics, err := models.ComputeIssuerMetrics(db.GetPostgresHandle(), identity, from, to, top)
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    issuerMetricsCache.Set(cacheKey, metrics)
+    services.Res(res).Json(metrics)
+}
@@ -6,13 +6,16 @@ import (
     "github.com/ownode/config"
     "strings"
     "strconv"
+    "errors"
     validator "github.com/asaskevich/govalidator"
     "github.com/ownode/models"
     "github.com/go-martini/martini"
-    "gopkg.in/mgo.v2/bson"
+    "github.com/jinzhu/gorm"
     "time"
     "fmt"
     "sort"
+    "database/sql"
+    "github.com/ownode/metrics"
 )
 
 var (   
@@ -32,25 +35,29 @@ type objectCreateBody struct {
     WalletID string         `json:"wallet_id"`
     NumberOfObjects int     `json:"number_objects"`
     BalancePerObject float64   `json:"unit_per_object"` 
-    Meta string             `json:"meta"` 
+    Meta models.Meta        `json:"meta"` 
+    MaxUses int             `json:"max_uses"`
 }
 
 type objectMergeBody struct {
     Objects []string `json:"objects"`
-    Meta string `json:"meta"`
+    Meta models.Meta `json:"meta"`
 }
 
 type objectDivideBody struct {
     Object string `json:"object"`
     NumObjects int `json:"num_objects"`
-    Meta string `json:"meta"`
+    Amounts []float64 `json:"amounts"`
+    Metas []models.Meta `json:"metas"`
+    Wallets []string `json:"wallets"`
+    Meta models.Meta `json:"meta"`
     InheritMeta bool `json:"inherit_meta"`
 }
 
 type objectSubtractBody struct {
     Object string `json:"object"`
     AmountToSubtract float64 `json:"amount"`
-    Meta string `json:"meta"`
+    Meta models.Meta `json:"meta"`
     InheritMeta bool `json:"inherit_meta"`
 }
 
@@ -59,7 +66,12 @@ type objectChargeBody struct {
     DestinationWalletID string `json:"wallet_id"`
     Amount float64 `json:"amount"`
     Pins map[string]int `json:"pins"`
-    Meta string `json:"meta"`
+    Meta models.Meta `json:"meta"`
+    QuoteID string `json:"quote_id"`
+}
+
+type objectRedeemUseBody struct {
+    Meta models.Meta `json:"meta"`
 }
 
 type objectOpenBody struct {
@@ -72,12 +84,15 @@ func init() {
     Object = ObjectController{ &Base }
 }
 
+// Get reads objects through the repositories. Handlers that change objects
+// use the database as they lock rows, retry transactions and append events
+// and webhooks in the transaction of the change (see models.Repos)
 type ObjectController struct {
     *BaseController
 }
 
 // create a new object
-func NewObject(pin string, objType string, service models.Service, wallet models.Wallet, balance float64, meta string) models.Object {
+func NewObject(pin string, objType string, service models.Service, wallet models.Wallet, balance float64, meta models.Meta) models.Object {
     
     // make sure valueless objects have no balance
     if objType == models.ObjectValueless {
@@ -85,7 +100,7 @@ func NewObject(pin string, objType string, service models.Service, wallet models
     }
 
     return models.Object {
-        ObjectID: bson.NewObjectId().Hex(),
+        ObjectID: services.NewObjectID(),
         Pin: pin,
         Type: objType,
         Wallet: wallet,
@@ -104,6 +119,44 @@ func TotalBalance (objects []models.Object) float64 {
     return sum
 }
 
+// generate n unique pins for objects of an issuer. 
+// The pin prefix is determined by the issuer's base currency
+func newObjectPins(db *gorm.DB, issuer *models.Identity, n int) ([]string, error) {
+    currency, found := config.FindCurrency(strings.ToUpper(issuer.BaseCurrency))
+    if !found {
+        return nil, errors.New("no pin prefix for currency " + issuer.BaseCurrency)
+    }
+    return services.NewUniqueObjectPins(currency.PinPrefix, n, func(pins []string) ([]string, error) {
+        return models.FindExistingObjectPins(db, pins)
+    })
+}
+
+// generate a unique pin for an object of an issuer
+func newObjectPin(db *gorm.DB, issuer *models.Identity) (string, error) {
+    pins, err := newObjectPins(db, issuer, 1)
+    if err != nil {
+        return "", err
+    }
+    return pins[0], nil
+}
+
+// create an object of an issuer. A pin taken by a concurrent create is
+// replaced with a new one
+func createObject(db *gorm.DB, object *models.Object, issuer *models.Identity) error {
+    return models.CreateObjectRetryingPin(db, object, services.MaxPinAttempts, func() (string, error) {
+        return newObjectPin(db, issuer)
+    })
+}
+
+// sum of a slice of amounts
+func TotalAmount(amounts []float64) float64 {
+    sum := 0.0
+    for _, amount := range amounts {
+        sum += amount
+    }
+    return sum
+}
+
 // create object controller
 func (c *ObjectController) Create(res http.ResponseWriter, req services.AuxRequestContext, log *config.CustomLog, db *services.DB) {
      
@@ -117,152 +170,119 @@ func (c *ObjectController) Create(res http.ResponseWriter, req services.AuxReque
     // TODO: get client id from access token
     clientID := "kl14zFDq4SHlmmmVNHgLtE0LqCo8BTjyShOH"
 
-    // get db transaction object
-    dbTx, err := db.GetPostgresHandleWithRepeatableReadTrans()
-    if err != nil {
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "", "server error")
-        return
-    }
-
-    // get service
-    service, _, _ := models.FindServiceByClientId(dbTx, clientID)
-
-    // ensure service is an issuer
-    if !service.Identity.Issuer {
-        dbTx.Rollback()
-        services.Res(res).Error(401, "unauthorized_service", "service is not an issuer")
+    // type and max uses do not depend on the issuer
+    body.Type = strings.ToLower(body.Type)
+    if issueErr := objectTypeError(body.Type, body.MaxUses); issueErr != nil {
+        services.Res(res).Error(issueErr.status, issueErr.errType, issueErr.message)
         return
     }
 
-    // type is required
-    if validator.IsNull(body.Type) {
-        dbTx.Rollback()
-        services.Res(res).Error(400, "missing_parameter", "Missing required field: type")
-        return
-    }
+    // create in a transaction that is retried if it conflicts with a concurrent one
+    var service models.Service
+    var allNewObjects []models.Object
+    err := db.RepeatableReadTransaction(func(dbTx *gorm.DB) error {
 
-    // ensure type is valid
-    if strings.ToLower(body.Type) != models.ObjectValue && strings.ToLower(body.Type) != models.ObjectValueless {
-        dbTx.Rollback()
-        services.Res(res).Error(400, "invalid_type", "type can only be obj_value or obj_valueless")
-        return
-    }
-
-    // wallet id is required
-    if validator.IsNull(body.WalletID) {
-        dbTx.Rollback()
-        services.Res(res).Error(400, "missing_parameter", "Missing required field: wallet_id")
-        return
-    }
+        // get service
+        var found bool
+        var err error
+        service, found, err = models.FindServiceByClientId(dbTx, clientID)
+        if err != nil {
+            return err
+        }
 
-    // ensure number of objects is greater than 0
-    if body.NumberOfObjects < 1 {
-        dbTx.Rollback()
-        services.Res(res).Error(400, "invalid_number_objects", "number_objects must be atleast 1 but not more than 100")
-        return
-    }
+        // ensure service is an issuer
+        if !found || !service.Identity.Issuer {
+            services.Res(res).Error(401, "unauthorized_service", "service is not an issuer")
+            return errResponseWritten
+        }
 
-    // ensure number of objects is not greater than 100(the limit)
-    if body.NumberOfObjects > 100 {
-        dbTx.Rollback()
-        services.Res(res).Error(400, "invalid_number_objects", "number_objects must not be more than 100")
-        return
-    }
+        // lock the issuer and reload it, so its soul balance is current
+        if err := models.LockIdentity(dbTx, service.Identity.ObjectID); err != nil {
+            return err
+        }
+        if service, _, err = models.FindServiceById(dbTx, service.ID); err != nil {
+            return err
+        }
 
-    // for object of value, validate  unit per object
-    if strings.ToLower(body.Type) == models.ObjectValue {
-       
-        if body.BalancePerObject == 0 {
-            dbTx.Rollback()
-            services.Res(res).Error(400, "missing_parameter", "Missing required field: unit_per_object")
-            return
+        // validate the objects to issue
+        if issueErr := objectIssueError(service.Identity, body.Type, body.WalletID, body.NumberOfObjects, c.settings.Limits.MaxObjectsPerRequest, body.BalancePerObject, body.Meta, c.settings.Limits.MaxMetaSize); issueErr != nil {
+            services.Res(res).Error(issueErr.status, issueErr.errType, issueErr.message)
+            return errResponseWritten
         }
 
-        if body.BalancePerObject < MinimumObjectUnit {
-            dbTx.Rollback()
-            services.Res(res).Error(400, "invalid_unit_per_object", "unit_per_object must be equal or greater than the minimum object unit which is 0.00000001")
-            return
-        } 
+        // ensure meta satisfies the issuer's meta schema
+        if reason, err := c.MetaSchemaError(service.Identity, body.Meta); err != nil {
+            return err
+        } else if reason != "" {
+            services.Res(res).ErrParam("meta").Error(400, "invalid_meta", reason)
+            return errResponseWritten
+        }
 
-        soulBalanceRequired := float64(body.NumberOfObjects) * body.BalancePerObject
-        if service.Identity.SoulBalance < soulBalanceRequired {
-            dbTx.Rollback()
-            services.Res(res).Error(400, "insufficient_soul_balance", fmt.Sprintf("not enough soul balance to create object(s). Requires %.2f soul balance", soulBalanceRequired))
-            return
+        // ensure wallet exists and is owned by the service
+        wallet, found, err := models.FindWalletByObjectID(dbTx, body.WalletID)
+        if err != nil {
+            return err
+        } else if issueErr := walletIssueError(service.Identity, wallet, found); issueErr != nil {
+            services.Res(res).Error(issueErr.status, issueErr.errType, issueErr.message)
+            return errResponseWritten
         }
 
         // update services soul balance
-        service.Identity.SoulBalance = service.Identity.SoulBalance - soulBalanceRequired
-    }
-
-    // if meta is provided, ensure it is not greater than the limit size
-    if !c.validate.IsEmpty(body.Meta) && len([]byte(body.Meta)) > MaxMetaSize {
-        dbTx.Rollback()
-        services.Res(res).Error(400, "invalid_meta_size", fmt.Sprintf("Meta contains too much data. Max size is %d bytes", MaxMetaSize))
-        return
-    }
-
-    // ensure wallet exists
-    wallet, found, err := models.FindWalletByObjectID(dbTx, body.WalletID)
-    if err != nil {
-        dbTx.Rollback()
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "", "server error")
-        return
-    } else if !found {
-        dbTx.Rollback()
-        services.Res(res).Error(404, "invalid_wallet", "wallet_id is unknown")
-        return
-    }
+        if body.Type == models.ObjectValue {
+            service.Identity.SoulBalance = service.Identity.SoulBalance - float64(body.NumberOfObjects) * body.BalancePerObject
+        }
 
-    // ensure service owns the wallet
-    if service.Identity.ObjectID != wallet.Identity.ObjectID {
-        dbTx.Rollback()
-        services.Res(res).Error(401, "invalid_wallet", "wallet is not owned by this service. Use a wallet created by this service")
-        return   
-    }
+        // generate pins
+        newPins, err := newObjectPins(dbTx, service.Identity, body.NumberOfObjects)
+        if err != nil {
+            return err
+        }
 
-    // create objects
-    allNewObjects := []models.Object{}
-    for i := 0; i < body.NumberOfObjects; i++ {
+        // create objects
+        allNewObjects = []models.Object{}
+        for i := 0; i < body.NumberOfObjects; i++ {
+            newObj := NewObject(newPins[i], body.Type, service, wallet, body.BalancePerObject, body.Meta)
+            newObj.MaxUses = body.MaxUses
+            if err := createObject(dbTx, &newObj, service.Identity); err != nil {
+                return err
+            }
+            allNewObjects = append(allNewObjects, newObj)
+        }
 
-        // generate a pin
-        countryCallCode := config.CurrencyCallCodes[strings.ToUpper(service.Identity.BaseCurrency)]
-        newPin, err := services.NewObjectPin(strconv.Itoa(countryCallCode))
-        if err != nil {
-            dbTx.Rollback()
-            c.log.Error(err.Error())
-            services.Res(res).Error(500, "", "server error")
-            return
+        // update identity's soul balance
+        if err := dbTx.Save(service.Identity).Error; err != nil {
+            return err
         }
 
-        newObj := NewObject(newPin, body.Type, service, wallet, body.BalancePerObject, body.Meta)
-        err = models.CreateObject(dbTx, &newObj)
-        if err != nil {
-            dbTx.Rollback()
-            c.log.Error(err.Error())
-            services.Res(res).Error(500, "", "server error")
-            return
+        // record domain event
+        if err := appendObjectEvent(dbTx, models.EventObjectCreated, allNewObjects); err != nil {
+            return err
         }
-        allNewObjects = append(allNewObjects, newObj)
+
+        // queue event for webhook delivery
+        return queueEvent(dbTx, EventObjectCreated, map[string]interface{}{ "objects": objectsEventData(allNewObjects) }, []uint{ service.ID }, wallet)
+    })
+    if err == errResponseWritten {
+        return
+    } else if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
     }
 
-    // update identity's soul balance
-    dbTx.Save(service.Identity).Commit()
+    metrics.ObjectsCreated.WithLabelValues(service.Identity.ObjectName).Add(float64(len(allNewObjects)))
     services.Res(res).Json(allNewObjects)
 }
 
 // get an object by its id or pin
-func (c *ObjectController) Get(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, log *config.CustomLog, db *services.DB) {
+func (c *ObjectController) Get(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, log *config.CustomLog, repos models.Repos) {
     
-    dbObj := db.GetPostgresHandle()
-    object, found, err := models.FindObjectByObjectIDOrPin(dbObj, params["id"])
+    object, found, err := repos.Objects().FindByObjectIDOrPin(services.NormalizePin(params["id"]))
     if !found {
         services.Res(res).Error(404, "not_found", "object was not found")
         return
     } else if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -278,7 +298,7 @@ func (c *ObjectController) Get(params martini.Params, res http.ResponseWriter, r
 }
 
 // merge two or more objects.
-// Only a max of 100 identitcal objects can be merged.
+// Only a limited number of identitcal objects (100 by default) can be merged.
 // All objects to be merged must exists.
 // Only similar objects can be merged.
 // Meta is not retained. Optional "meta" parameter can be 
@@ -309,9 +329,9 @@ func (c *ObjectController) Merge(res http.ResponseWriter, req services.AuxReques
         return
     }
 
-    // objects field must not contain more than 100 objects
-    if len(body.Objects) > 100 {
-        services.Res(res).Error(400, "invalid_parameter", "objects: cannot merge more than 100 objects in a request")
+    // objects field must not contain more than the limit of objects
+    if len(body.Objects) > c.settings.Limits.MaxObjectsPerRequest {
+        services.Res(res).Error(400, "invalid_parameter", fmt.Sprintf("objects: cannot merge more than %d objects in a request", c.settings.Limits.MaxObjectsPerRequest))
         return
     }
 
@@ -322,96 +342,106 @@ func (c *ObjectController) Merge(res http.ResponseWriter, req services.AuxReques
     }
 
     // if meta is provided, ensure it is not greater than the limit size
-    if !c.validate.IsEmpty(body.Meta) && len([]byte(body.Meta)) > MaxMetaSize {
-        services.Res(res).Error(400, "invalid_meta_size", fmt.Sprintf("Meta contains too much data. Max size is %d bytes", MaxMetaSize))
+    if body.Meta.Size() > c.settings.Limits.MaxMetaSize {
+        services.Res(res).Error(400, "invalid_meta_size", fmt.Sprintf("Meta contains too much data. Max size is %d bytes", c.settings.Limits.MaxMetaSize))
         return
     }
 
-    // get db transaction object
-    dbTx, err := db.GetPostgresHandleWithRepeatableReadTrans()
-    if err != nil {
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "", "server error")
-        return
-    }
+    // merge in a transaction that is retried if it conflicts with a concurrent one
+    var newObj models.Object
+    err := db.RepeatableReadTransaction(func(dbTx *gorm.DB) error {
 
-    // find all objects
-    objectsFound, err := models.FindAllObjectsByObjectID(dbTx, body.Objects)
-    if err != nil {
-        dbTx.Rollback()
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "", "server error")
-        return
-    }
+        // find and lock all objects
+        objectsFound, err := models.FindAllObjectsByObjectIDForUpdate(dbTx, body.Objects)
+        if err != nil {
+            return err
+        }
 
-    // ensure all objects where found
-    if len(objectsFound) != len(body.Objects) {
-        dbTx.Rollback()
-        services.Res(res).Error(400, "unknown_merge_objects", "one or more objects does not exists")
-        return
-    }
+        // ensure all objects where found
+        if len(objectsFound) != len(body.Objects) {
+            services.Res(res).Error(400, "unknown_merge_objects", "one or more objects does not exists")
+            return errResponseWritten
+        }
 
-    totalBalance := 0.0
-    firstObj := objectsFound[0]
-    checkObjName := firstObj.Service.Identity.ObjectName
+        totalBalance := 0.0
+        firstObj := objectsFound[0]
+        checkObjName := firstObj.Service.Identity.ObjectName
 
-    for _, object := range objectsFound {
-        
-        // ensure all objects are valuable and also ensure object's
-        if object.Type == models.ObjectValueless {
-            dbTx.Rollback()
-            services.Res(res).Error(400, "invalid_parameter", "objects: only valuable objects (object_value) can be merged")
-            return
+        for _, object := range objectsFound {
+            
+            // ensure all objects are valuable and also ensure object's
+            if object.Type == models.ObjectValueless {
+                services.Res(res).Error(400, "invalid_parameter", "objects: only valuable objects (object_value) can be merged. valueless objects are tickets and can only be redeemed")
+                return errResponseWritten
+            }
+            
+            // wallet id match the authorizing wallet id 
+            if object.Wallet.ObjectID != authWalletID {
+                services.Res(res).Error(401, "unauthorized", "objects: one or more objects belongs to a different wallet")
+                return errResponseWritten
+            }
+
+            // ensure all objects are similar by their name / same issuer.
+            // this also ensures all objects have the same base currency
+            if checkObjName != object.Service.Identity.ObjectName {
+                services.Res(res).Error(400, "invalid_parameter", "objects: only similar (by name) objects can be merged")
+                return errResponseWritten
+            }
+
+            // updated total balance
+            totalBalance += object.Balance
+
+            // delete object
+            if err := dbTx.Delete(&object).Error; err != nil {
+                return err
+            }
         }
-        
-        // wallet id match the authorizing wallet id 
-        if object.Wallet.ObjectID != authWalletID {
-            dbTx.Rollback()
-            services.Res(res).Error(401, "unauthorized", "objects: one or more objects belongs to a different wallet")
-            return
+
+        // ensure meta satisfies the issuer's meta schema
+        if reason, err := c.MetaSchemaError(firstObj.Service.Identity, body.Meta); err != nil {
+            return err
+        } else if reason != "" {
+            services.Res(res).ErrParam("meta").Error(400, "invalid_meta", reason)
+            return errResponseWritten
         }
 
-        // ensure all objects are similar by their name / same issuer.
-        // this also ensures all objects have the same base currency
-        if checkObjName != object.Service.Identity.ObjectName {
-            dbTx.Rollback()
-            services.Res(res).Error(400, "invalid_parameter", "objects: only similar (by name) objects can be merged")
-            return
+        // create a new object
+        // generate a pin
+        newPin, err := newObjectPin(dbTx, firstObj.Service.Identity)
+        if err != nil {
+            return err
         }
 
-        // updated total balance
-        totalBalance += object.Balance
+        newObj = NewObject(newPin, models.ObjectValue, firstObj.Service, firstObj.Wallet, totalBalance, body.Meta)
+        if err := createObject(dbTx, &newObj, firstObj.Service.Identity); err != nil {
+            return err
+        }
 
-        // delete object
-        dbTx.Delete(&object)
-    }
+        // record domain event
+        if err := appendObjectEvent(dbTx, models.EventObjectMerged, []models.Object{ newObj }, objectsFound...); err != nil {
+            return err
+        }
 
-    // create a new object
-    // generate a pin
-    countryCallCode := config.CurrencyCallCodes[strings.ToUpper(firstObj.Service.Identity.BaseCurrency)]
-    newPin, err := services.NewObjectPin(strconv.Itoa(countryCallCode))
-    if err != nil {
-        dbTx.Rollback()
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "", "server error")
+        // queue event for webhook delivery
+        return queueEvent(dbTx, EventObjectMerged, map[string]interface{}{ "object": objectEventData(newObj), "merged_objects": body.Objects }, []uint{ firstObj.Service.ID }, firstObj.Wallet)
+    })
+    if err == errResponseWritten {
         return
-    }
-
-    newObj := NewObject(newPin, models.ObjectValue, firstObj.Service, firstObj.Wallet, totalBalance, body.Meta)
-    err = models.CreateObject(dbTx, &newObj)
-    if err != nil {
-        dbTx.Rollback()
-        c.log.Error(err.Error())
+    } else if err != nil {
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
-    
-    dbTx.Commit()
+
     services.Res(res).Json(newObj)
 }
 
-// divide an object into two or more equal parts.
-// maxinum of 100 equal parts is allowed.
+// divide an object into two or more parts.
+// maxinum of 100 parts (by default) is allowed.
+// object is divided into `num_objects` equal parts or into the parts described
+// by `amounts`. The sum of amounts must equal the object's balance.
+// When using amounts, `metas` and `wallets` can optionally set the meta and
+// destination wallet of each part.
 // object to be splitted must belong to authorizing wallet
 func (c *ObjectController) Divide(res http.ResponseWriter, req services.AuxRequestContext, log *config.CustomLog, db *services.DB) {
     
@@ -432,102 +462,237 @@ func (c *ObjectController) Divide(res http.ResponseWriter, req services.AuxReque
         return
     }    
 
-    // number of objects must be greater than 1
-    if body.NumObjects < 2 {
-        services.Res(res).Error(400, "invalid_parameter", "num_objects: must be greater than 1")
-        return
-    }
+    if len(body.Amounts) > 0 {
 
-    // number of objects must not be greater than 100
-    if body.NumObjects > 100 {
-        services.Res(res).Error(400, "invalid_parameter", "num_objects: must be greater than 1")
-        return
-    }
+        // number of amounts must be greater than 1
+        if len(body.Amounts) < 2 {
+            services.Res(res).ErrParam("amounts").Error(400, "invalid_parameter", "amounts: must contain more than 1 amount")
+            return
+        }
 
-    dbTx, err := db.GetPostgresHandleWithRepeatableReadTrans()
-    if err != nil {
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "", "server error")
-        return
-    }
+        // number of amounts must not be greater than the limit
+        if len(body.Amounts) > c.settings.Limits.MaxObjectsPerRequest {
+            services.Res(res).ErrParam("amounts").Error(400, "invalid_parameter", fmt.Sprintf("amounts: must not contain more than %d amounts", c.settings.Limits.MaxObjectsPerRequest))
+            return
+        }
 
-    // get the object
-    object, found, err := models.FindObjectByObjectID(dbTx, body.Object)
-    if !found {
-        dbTx.Rollback()
-        services.Res(res).Error(404, "not_found", "object was not found")
-        return
-    } else if err != nil {
-        dbTx.Rollback()
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "", "server error")
-        return
-    }
+        // num_objects, if provided, must match the number of amounts
+        if body.NumObjects != 0 && body.NumObjects != len(body.Amounts) {
+            services.Res(res).ErrParam("num_objects").Error(400, "invalid_parameter", "num_objects: must equal the number of amounts")
+            return
+        }
 
-    // ensure object is a valuable type
-    if object.Type == models.ObjectValueless {
-        dbTx.Rollback()
-        services.Res(res).Error(400, "invalid_parameter", "object: object must be a valuabe type (obj_value) ")
-        return
-    }
+        // each amount must not be less than the minimum object unit
+        for _, amount := range body.Amounts {
+            if amount < MinimumObjectUnit {
+                services.Res(res).ErrParam("amounts").Error(400, "invalid_parameter", "amounts: each amount must be equal or greater than the minimum object unit which is 0.00000001")
+                return
+            }
+        }
 
-    // ensure object has enough balance (minimum of 0.000001)
-    if object.Balance < 0.000001 {
-        dbTx.Rollback()
-        services.Res(res).Error(400, "invalid_parameter", "object: object must have a minimum balance of 0.000001")
-        return
-    }
+        // metas, if provided, must have a meta for each amount
+        if body.Metas != nil && len(body.Metas) != len(body.Amounts) {
+            services.Res(res).ErrParam("metas").Error(400, "invalid_parameter", "metas: must contain a meta for each amount")
+            return
+        }
 
-    // ensure object belongs to authorizing wallet
-    if object.Wallet.ObjectID != authWalletID {
-        dbTx.Rollback()
-        services.Res(res).Error(401, "unauthorized", "object does not belong to authorizing wallet")
-        return
-    }
+        // each part meta must not be greater than the limit size
+        for _, meta := range body.Metas {
+            if meta.Size() > c.settings.Limits.MaxMetaSize {
+                services.Res(res).ErrParam("metas").Error(400, "invalid_meta_size", fmt.Sprintf("Meta contains too much data. Max size is %d bytes", c.settings.Limits.MaxMetaSize))
+                return
+            }
+        }
+
+        // wallets, if provided, must have a wallet for each amount
+        if body.Wallets != nil && len(body.Wallets) != len(body.Amounts) {
+            services.Res(res).ErrParam("wallets").Error(400, "invalid_parameter", "wallets: must contain a wallet for each amount")
+            return
+        }
 
-    // if meta is provided, ensure it is not greater than the limit size
-    if !body.InheritMeta && !c.validate.IsEmpty(body.Meta) && len([]byte(body.Meta)) > MaxMetaSize {
-        services.Res(res).Error(400, "invalid_meta_size", fmt.Sprintf("Meta contains too much data. Max size is %d bytes", MaxMetaSize))
-        return
     } else {
-        if body.InheritMeta {
-            body.Meta = object.Meta
+
+        // per part meta and wallets are only supported with amounts
+        if body.Metas != nil || body.Wallets != nil {
+            services.Res(res).ErrParam("amounts").Error(400, "invalid_parameter", "amounts: metas and wallets can only be used with amounts")
+            return
         }
-    }
 
-    // calculate new balance per object
-    newBalance := object.Balance / float64(body.NumObjects)
+        // number of objects must be greater than 1
+        if body.NumObjects < 2 {
+            services.Res(res).Error(400, "invalid_parameter", "num_objects: must be greater than 1")
+            return
+        }
 
-    // delete object
-    dbTx.Delete(&object)
+        // number of objects must not be greater than the limit
+        if body.NumObjects > c.settings.Limits.MaxObjectsPerRequest {
+            services.Res(res).Error(400, "invalid_parameter", fmt.Sprintf("num_objects: must not be greater than %d", c.settings.Limits.MaxObjectsPerRequest))
+            return
+        }
+    }
 
-    // create new objects
-    newObjects := []models.Object{}
-    for i := 0; i < body.NumObjects; i++ {
+    // divide in a transaction that is retried if it conflicts with a concurrent one
+    var newObjects []models.Object
+    err := db.RepeatableReadTransaction(func(dbTx *gorm.DB) error {
 
-        // generate a pin
-        countryCallCode := config.CurrencyCallCodes[strings.ToUpper(object.Service.Identity.BaseCurrency)]
-        newPin, err := services.NewObjectPin(strconv.Itoa(countryCallCode))
+        // get and lock the object
+        object, found, err := models.FindObjectByObjectIDForUpdate(dbTx, body.Object)
         if err != nil {
-            dbTx.Rollback()
-            c.log.Error(err.Error())
-            services.Res(res).Error(500, "", "server error")
-            return
+            return err
+        } else if !found {
+            services.Res(res).Error(404, "not_found", "object was not found")
+            return errResponseWritten
         }
 
-        newObj := NewObject(newPin, models.ObjectValue, object.Service, object.Wallet, newBalance, body.Meta)
-        err = models.CreateObject(dbTx, &newObj)
+        // ensure object is a valuable type. valueless objects (tickets) cannot be divided
+        if object.Type == models.ObjectValueless {
+            services.Res(res).Error(400, "invalid_parameter", "object: object must be a valuabe type (obj_value). valueless objects cannot be divided")
+            return errResponseWritten
+        }
+
+        // ensure object has enough balance (minimum of 0.000001)
+        if object.Balance < 0.000001 {
+            services.Res(res).Error(400, "invalid_parameter", "object: object must have a minimum balance of 0.000001")
+            return errResponseWritten
+        }
+
+        // ensure object belongs to authorizing wallet
+        if object.Wallet.ObjectID != authWalletID {
+            services.Res(res).Error(401, "unauthorized", "object does not belong to authorizing wallet")
+            return errResponseWritten
+        }
+
+        // if meta is provided, ensure it is not greater than the limit size
+        meta := body.Meta
+        if !body.InheritMeta && meta.Size() > c.settings.Limits.MaxMetaSize {
+            services.Res(res).Error(400, "invalid_meta_size", fmt.Sprintf("Meta contains too much data. Max size is %d bytes", c.settings.Limits.MaxMetaSize))
+            return errResponseWritten
+        } else if body.InheritMeta {
+            meta = object.Meta
+        }
+
+        // amounts must fit the precision of the object's currency
+        for _, amount := range body.Amounts {
+            if reason := amountPrecisionError(object.Service.Identity, amount); reason != "" {
+                services.Res(res).ErrParam("amounts").Error(400, "invalid_parameter", "amounts: " + reason)
+                return errResponseWritten
+            }
+        }
+
+        // describe the balance, meta and wallet of each part. without amounts, the
+        // object is divided into equal parts at the precision of its currency
+        places := issuerPlaces(object.Service.Identity)
+        amounts := body.Amounts
+        if len(amounts) == 0 {
+            var ok bool
+            if amounts, ok = splitAmount(object.Balance, body.NumObjects, places); !ok {
+                services.Res(res).Error(400, "invalid_parameter", fmt.Sprintf("num_objects: object's balance is too small to divide into %d objects", body.NumObjects))
+                return errResponseWritten
+            }
+        }
+
+        // sum of amounts must equal the object's balance at the precision of its currency
+        if amountUnits(TotalAmount(amounts), places) != amountUnits(object.Balance, places) {
+            services.Res(res).ErrParam("amounts").Error(400, "invalid_parameter", fmt.Sprintf("amounts: sum of amounts must equal the object's balance of %.*f", places, object.Balance))
+            return errResponseWritten
+        }
+
+        // find and lock destination wallets. Parts can only move to open
+        // wallets of the object's issuer
+        partWallets := make([]models.Wallet, len(amounts))
+        for i := range partWallets {
+            partWallets[i] = object.Wallet
+        }
+
+        if body.Wallets != nil {
+
+            walletsFound, err := models.FindAllWalletsByObjectIDForUpdate(dbTx, body.Wallets)
+            if err != nil {
+                return err
+            }
+
+            walletsByID := map[string]models.Wallet{}
+            for _, wallet := range walletsFound {
+                walletsByID[wallet.ObjectID] = wallet
+            }
+
+            for i, walletID := range body.Wallets {
+                if c.validate.IsEmpty(walletID) {
+                    continue
+                }
+                wallet, found := walletsByID[walletID]
+                if !found {
+                    services.Res(res).ErrParam("wallets").Error(404, "not_found", fmt.Sprintf("wallets: %s not found", walletID))
+                    return errResponseWritten
+                } else if wallet.Identity.ObjectID != object.Service.Identity.ObjectID {
+                    services.Res(res).ErrParam("wallets").Error(400, "invalid_parameter", fmt.Sprintf("wallets: %s is not a wallet of the object's issuer", walletID))
+                    return errResponseWritten
+                } else if wallet.Lock {
+                    services.Res(res).ErrParam("wallets").Error(400, "invalid_parameter", fmt.Sprintf("wallets: %s is locked", walletID))
+                    return errResponseWritten
+                }
+                partWallets[i] = wallet
+            }
+        }
+
+        // determine the meta of each part. use the part's meta if provided
+        partMetas := make([]models.Meta, len(amounts))
+        for i := range partMetas {
+            partMetas[i] = meta
+            if body.Metas != nil && !body.Metas[i].IsEmpty() {
+                partMetas[i] = body.Metas[i]
+            }
+
+            // ensure meta satisfies the issuer's meta schema. inherited meta is not checked
+            if body.InheritMeta && partMetas[i] == object.Meta {
+                continue
+            }
+
+            if reason, err := c.MetaSchemaError(object.Service.Identity, partMetas[i]); err != nil {
+                return err
+            } else if reason != "" {
+                services.Res(res).ErrParam("meta").Error(400, "invalid_meta", reason)
+                return errResponseWritten
+            }
+        }
+
+        // delete object
+        if err := dbTx.Delete(&object).Error; err != nil {
+            return err
+        }
+
+        // generate pins
+        newPins, err := newObjectPins(dbTx, object.Service.Identity, len(amounts))
         if err != nil {
-            dbTx.Rollback()
-            c.log.Error(err.Error())
-            services.Res(res).Error(500, "", "server error")
-            return
+            return err
         }
 
-        newObjects = append(newObjects, newObj)
+        // create new objects
+        newObjects = []models.Object{}
+        for i, amount := range amounts {
+            newObj := NewObject(newPins[i], models.ObjectValue, object.Service, partWallets[i], amount, partMetas[i])
+            if err := createObject(dbTx, &newObj, object.Service.Identity); err != nil {
+                return err
+            }
+            newObjects = append(newObjects, newObj)
+        }
+
+        // record domain event
+        if err := appendObjectEvent(dbTx, models.EventObjectDivided, newObjects, object); err != nil {
+            return err
+        }
+
+        // queue event for webhook delivery
+        return queueEvent(dbTx, EventObjectDivided, map[string]interface{}{ "object": objectEventData(object), "objects": objectsEventData(newObjects) }, []uint{ object.Service.ID }, append(partWallets, object.Wallet)...)
+    })
+    if err == errResponseWritten {
+        return
+    } else if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
     }
-    
-    dbTx.Commit()
+
     services.Res(res).Json(newObjects)
 }
 
@@ -557,82 +722,95 @@ func (c *ObjectController) Subtract(res http.ResponseWriter, req services.AuxReq
         return
     }
 
-    dbTx, err := db.GetPostgresHandleWithRepeatableReadTrans()
-    if err != nil {
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "", "server error")
-        return
-    }
+    // subtract in a transaction that is retried if it conflicts with a concurrent one
+    var newObj models.Object
+    err := db.RepeatableReadTransaction(func(dbTx *gorm.DB) error {
 
-    // get the object
-    object, found, err := models.FindObjectByObjectID(dbTx, body.Object)
-    if !found {
-        dbTx.Rollback()
-        services.Res(res).Error(404, "not_found", "object was not found")
-        return
-    } else if err != nil {
-        dbTx.Rollback()
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "", "server error")
-        return
-    }
+        // get and lock the object
+        object, found, err := models.FindObjectByObjectIDForUpdate(dbTx, body.Object)
+        if err != nil {
+            return err
+        } else if !found {
+            services.Res(res).Error(404, "not_found", "object was not found")
+            return errResponseWritten
+        }
 
-    // ensure object is a valuable type
-    if object.Type == models.ObjectValueless {
-        dbTx.Rollback()
-        services.Res(res).Error(400, "invalid_parameter", "object: object must be a valuabe type (obj_value) ")
-        return
-    }
+        // ensure object is a valuable type
+        if object.Type == models.ObjectValueless {
+            services.Res(res).Error(400, "invalid_parameter", "object: object must be a valuabe type (obj_value) ")
+            return errResponseWritten
+        }
 
-    // ensure object belongs to authorizing wallet
-    if object.Wallet.ObjectID != authWalletID {
-        dbTx.Rollback()
-        services.Res(res).Error(401, "unauthorized", "objects: object does not belong to authorizing wallet")
-        return
-    }
+        // ensure object belongs to authorizing wallet
+        if object.Wallet.ObjectID != authWalletID {
+            services.Res(res).Error(401, "unauthorized", "objects: object does not belong to authorizing wallet")
+            return errResponseWritten
+        }
 
-    // ensure object's balance is sufficient 
-    if object.Balance < body.AmountToSubtract {
-        dbTx.Rollback()
-        services.Res(res).Error(400, "invalid_parameter", "amount: object's balance is insufficient")
-        return
-    }
+        // amount must fit the precision of the object's currency
+        if reason := amountPrecisionError(object.Service.Identity, body.AmountToSubtract); reason != "" {
+            services.Res(res).Error(400, "invalid_parameter", "amount: " + reason)
+            return errResponseWritten
+        }
 
-    // if meta is provided, ensure it is not greater than the limit size
-    if !body.InheritMeta && !c.validate.IsEmpty(body.Meta) && len([]byte(body.Meta)) > MaxMetaSize {
-        services.Res(res).Error(400, "invalid_meta_size", fmt.Sprintf("Meta contains too much data. Max size is %d bytes", MaxMetaSize))
-        return
-    } else {
-        if body.InheritMeta {
-            body.Meta = object.Meta
-       }
-   }
-
-    // subtract and update object's balance
-    object.Balance = object.Balance - body.AmountToSubtract
-    dbTx.Save(&object)
-
-    // create new object
-    // generate a pin
-    countryCallCode := config.CurrencyCallCodes[strings.ToUpper(object.Service.Identity.BaseCurrency)]
-    newPin, err := services.NewObjectPin(strconv.Itoa(countryCallCode))
-    if err != nil {
-        dbTx.Rollback()
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "", "server error")
-        return
-    }
+        // ensure object's balance is sufficient 
+        if object.Balance < body.AmountToSubtract {
+            services.Res(res).Error(400, "invalid_parameter", "amount: object's balance is insufficient")
+            return errResponseWritten
+        }
 
-    newObj := NewObject(newPin, models.ObjectValue, object.Service, object.Wallet, body.AmountToSubtract, body.Meta)
-    err = models.CreateObject(dbTx, &newObj)
-    if err != nil {
-        dbTx.Rollback()
-        c.log.Error(err.Error())
+        // if meta is provided, ensure it is not greater than the limit size
+        meta := body.Meta
+        if !body.InheritMeta && meta.Size() > c.settings.Limits.MaxMetaSize {
+            services.Res(res).Error(400, "invalid_meta_size", fmt.Sprintf("Meta contains too much data. Max size is %d bytes", c.settings.Limits.MaxMetaSize))
+            return errResponseWritten
+        } else if body.InheritMeta {
+            meta = object.Meta
+        } else {
+
+            // ensure meta satisfies the issuer's meta schema
+            if reason, err := c.MetaSchemaError(object.Service.Identity, meta); err != nil {
+                return err
+            } else if reason != "" {
+                services.Res(res).ErrParam("meta").Error(400, "invalid_meta", reason)
+                return errResponseWritten
+            }
+        }
+
+        // subtract and update object's balance
+        object.Balance = object.Balance - body.AmountToSubtract
+        if err := dbTx.Save(&object).Error; err != nil {
+            return err
+        }
+
+        // create new object
+        // generate a pin
+        newPin, err := newObjectPin(dbTx, object.Service.Identity)
+        if err != nil {
+            return err
+        }
+
+        newObj = NewObject(newPin, models.ObjectValue, object.Service, object.Wallet, body.AmountToSubtract, meta)
+        if err := createObject(dbTx, &newObj, object.Service.Identity); err != nil {
+            return err
+        }
+
+        // record domain event
+        if err := appendObjectEvent(dbTx, models.EventObjectSubtracted, []models.Object{ object, newObj }); err != nil {
+            return err
+        }
+
+        // queue event for webhook delivery
+        return queueEvent(dbTx, EventObjectSubtracted, map[string]interface{}{ "object": objectEventData(object), "new_object": objectEventData(newObj) }, []uint{ object.Service.ID }, object.Wallet)
+    })
+    if err == errResponseWritten {
+        return
+    } else if err != nil {
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
 
-    dbTx.Commit()
     services.Res(res).Json(newObj)
 }
 
@@ -663,45 +841,11 @@ func (c *ObjectController) Open(params martini.Params, res http.ResponseWriter,
         return
     }
 
-    dbTx, err := db.GetPostgresHandleWithRepeatableReadTrans()
-    if err != nil {
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "", "server error")
-        return
-    }
-
-    // get the object
-    object, found, err := models.FindObjectByObjectID(dbTx, params["id"])
-    if !found {
-        dbTx.Rollback()
-        services.Res(res).Error(404, "not_found", "object was not found")
-        return
-    } else if err != nil {
-        dbTx.Rollback()
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "", "server error")
-        return
-    }
-
-    // ensure object belongs to authorizing wallet
-    if object.Wallet.ObjectID != authWalletID {
-        dbTx.Rollback()
-        services.Res(res).Error(401, "unauthorized", "objects: object does not belong to authorizing wallet")
-        return
-    }
-
-    // set object's open property to true and open_method to `open`
-    clearOpen(&object)
-    object.Open = true
-    object.OpenMethod = models.ObjectOpenDefault
-
-    // for open_timed,
-    // set 'open_time' field to indicate object open window
+    // for open_timed, the time of the end of the open window
     if body.OpenMethod == "open_timed" {
          
         // ensure time field is provided
         if body.Time == 0 {
-            dbTx.Rollback()
             services.Res(res).Error(400, "invalid_parameter", "time: open window time is required. use unix time")
             return 
         }
@@ -709,53 +853,98 @@ func (c *ObjectController) Open(params martini.Params, res http.ResponseWriter,
         // time must be in the future
         now := time.Now().UTC()
         if !now.Before(services.UnixToTime(body.Time).UTC()) {
-            dbTx.Rollback()
             services.Res(res).Error(400, "invalid_parameter", "time: use a unix time pointing to a period in the future")
             return
         }
-
-        object.OpenMethod = models.ObjectOpenTimed
-        object.OpenTime = body.Time
     }
 
-    // for open_pin
-    // open pin sets a pin for used by charge API 
+    // for open_pin, the pin used by charge API. It is hashed before
+    // the transaction so the object is not locked while hashing
+    pinHash := ""
     if body.OpenMethod == "open_pin" {
 
         // ensure pin is provided
         if c.validate.IsEmpty(body.Pin) {
-            dbTx.Rollback()
             services.Res(res).Error(400, "invalid_parameter", "pin: pin is required")
             return 
         }
 
         // pin must be numeric
         if !validator.IsNumeric(body.Pin) {
-            dbTx.Rollback()
             services.Res(res).Error(400, "invalid_parameter", "pin: pin must contain only numeric characters. e.g 4345")
             return 
         }
 
         // pin length must be between 4 - 12 characters
         if len(body.Pin) < 4 || len(body.Pin) > 12 {
-            dbTx.Rollback()
             services.Res(res).Error(400, "invalid_parameter", "pin: pin must have a minimum character length of 4 and maximum of 12")
             return 
         }
 
         // hash pin using bcrypt
-        pinHash, err := services.Bcrypt(body.Pin, 10)
-        if err != nil {
-            c.log.Error("unable to hash password. reason: " + err.Error())
+        var err error
+        if pinHash, err = services.Bcrypt(req.Request.Context(), body.Pin, 10); err != nil {
+            req.Log.Error("unable to hash password. reason: " + err.Error())
             services.Res(res).Error(500, "", "server error")
             return
         }
+    }
+
+    // open in a transaction that is retried if it conflicts with a concurrent one
+    var object models.Object
+    err := db.RepeatableReadTransaction(func(dbTx *gorm.DB) error {
+
+        // get and lock the object
+        var found bool
+        var err error
+        object, found, err = models.FindObjectByObjectIDForUpdate(dbTx, params["id"])
+        if err != nil {
+            return err
+        } else if !found {
+            services.Res(res).Error(404, "not_found", "object was not found")
+            return errResponseWritten
+        }
 
-        object.OpenMethod = models.ObjectOpenPin
-        object.OpenPin = pinHash
+        // ensure object belongs to authorizing wallet
+        if object.Wallet.ObjectID != authWalletID {
+            services.Res(res).Error(401, "unauthorized", "objects: object does not belong to authorizing wallet")
+            return errResponseWritten
+        }
+
+        // set object's open property to true and open_method to `open`
+        clearOpen(&object)
+        object.Open = true
+        object.OpenMethod = models.ObjectOpenDefault
+
+        switch body.OpenMethod {
+        case "open_timed":
+            object.OpenMethod = models.ObjectOpenTimed
+            object.OpenTime = body.Time
+        case "open_pin":
+            object.OpenMethod = models.ObjectOpenPin
+            object.OpenPin = pinHash
+        }
+
+        if err := dbTx.Save(&object).Error; err != nil {
+            return err
+        }
+
+        // record domain event
+        if err := appendObjectEvent(dbTx, models.EventObjectOpened, []models.Object{ object }); err != nil {
+            return err
+        }
+
+        // queue event for webhook delivery
+        return queueEvent(dbTx, EventObjectOpened, map[string]interface{}{ "object": objectEventData(object) }, []uint{ object.Service.ID }, object.Wallet)
+    })
+    if err == errResponseWritten {
+        return
+    } else if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
     }
 
-    dbTx.Save(&object).Commit()
     services.Res(res).Json(object)
 }
 
@@ -767,38 +956,49 @@ func (c *ObjectController) Lock(params martini.Params, res http.ResponseWriter,
     // authorizing wallet id
     authWalletID := "55c679145fe09c74ed000001"
 
-    dbTx, err := db.GetPostgresHandleWithRepeatableReadTrans()
-    if err != nil {
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "", "server error")
-        return
-    }
+    // lock in a transaction that is retried if it conflicts with a concurrent one
+    var object models.Object
+    err := db.RepeatableReadTransaction(func(dbTx *gorm.DB) error {
 
-    // get the object
-    object, found, err := models.FindObjectByObjectID(dbTx, params["id"])
-    if !found {
-        dbTx.Rollback()
-        services.Res(res).Error(404, "not_found", "object was not found")
+        // get and lock the object
+        var found bool
+        var err error
+        object, found, err = models.FindObjectByObjectIDForUpdate(dbTx, params["id"])
+        if err != nil {
+            return err
+        } else if !found {
+            services.Res(res).Error(404, "not_found", "object was not found")
+            return errResponseWritten
+        }
+
+        // ensure object belongs to authorizing wallet
+        if object.Wallet.ObjectID != authWalletID {
+            services.Res(res).Error(401, "unauthorized", "objects: object does not belong to authorizing wallet")
+            return errResponseWritten
+        }
+
+        // clear open related fields of object
+        clearOpen(&object)
+        if err := dbTx.Save(&object).Error; err != nil {
+            return err
+        }
+
+        // record domain event
+        if err := appendObjectEvent(dbTx, models.EventObjectLocked, []models.Object{ object }); err != nil {
+            return err
+        }
+
+        // queue event for webhook delivery
+        return queueEvent(dbTx, EventObjectLocked, map[string]interface{}{ "object": objectEventData(object) }, []uint{ object.Service.ID }, object.Wallet)
+    })
+    if err == errResponseWritten {
         return
     } else if err != nil {
-        dbTx.Rollback()
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
 
-    // ensure object belongs to authorizing wallet
-    if object.Wallet.ObjectID != authWalletID {
-        dbTx.Rollback()
-        services.Res(res).Error(401, "unauthorized", "objects: object does not belong to authorizing wallet")
-        return
-    }
-
-    // clear open related fields of object
-    clearOpen(&object)
-
-    // save update and commit
-    dbTx.Save(&object).Commit()
     services.Res(res).Json(object)
 }
 
@@ -816,26 +1016,15 @@ func (c *ObjectController) Charge(params martini.Params, res http.ResponseWriter
     // TODO: get client id from access token
     clientID := "kl14zFDq4SHlmmmVNHgLtE0LqCo8BTjyShOH"
 
-    // get db transaction object
-    dbTx, err := db.GetPostgresHandleWithRepeatableReadTrans()
-    if err != nil {
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "", "server error")
-        return
-    }
-
-    // get service
-    service, _, _ := models.FindServiceByClientId(dbTx, clientID)
-
     // ensure object ids is not empty
     if len(body.IDS) == 0 {
         services.Res(res).ErrParam("ids").Error(400, "invalid_parameter", "provide one or more object ids to charge")
         return
     }
 
-    // ensure object ids length is less than 100
-    if len(body.IDS) > 100 {
-        services.Res(res).ErrParam("ids").Error(400, "invalid_parameter", "only a maximum of 100 objects can be charge at a time")
+    // ensure object ids length is not more than the limit
+    if len(body.IDS) > c.settings.Limits.MaxObjectsPerRequest {
+        services.Res(res).ErrParam("ids").Error(400, "invalid_parameter", fmt.Sprintf("only a maximum of %d objects can be charge at a time", c.settings.Limits.MaxObjectsPerRequest))
         return
     }
 
@@ -852,154 +1041,395 @@ func (c *ObjectController) Charge(params martini.Params, res http.ResponseWriter
     }
 
     // if meta is provided, ensure it is not greater than the limit size
-    if !c.validate.IsEmpty(body.Meta) && len([]byte(body.Meta)) > MaxMetaSize {
-        services.Res(res).ErrParam("meta").Error(400, "invalid_parameter", fmt.Sprintf("Meta contains too much data. Max size is %d bytes", MaxMetaSize))
+    if body.Meta.Size() > c.settings.Limits.MaxMetaSize {
+        services.Res(res).ErrParam("meta").Error(400, "invalid_parameter", fmt.Sprintf("Meta contains too much data. Max size is %d bytes", c.settings.Limits.MaxMetaSize))
         return
     }
 
-    // ensure destination wallet exists
-    wallet, found, err := models.FindWalletByObjectID(dbTx, body.DestinationWalletID)
-    if err != nil {
-        dbTx.Rollback()
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "api_error", "api_error")
-        return
-    } else if !found {
-        dbTx.Rollback()
-        services.Res(res).ErrParam("wallet_id").Error(404, "not_found", "wallet_id not found")
-        return
-    }
+    // charge in a transaction that is retried if it conflicts with a concurrent one
+    var newObj models.Object
+    var chargedIssuer string
+    var chargedAmount float64
+    err := db.RepeatableReadTransaction(func(dbTx *gorm.DB) error {
 
-    // find all objects
-    objectsFound, err := models.FindAllObjectsByObjectID(dbTx, body.IDS)
-    if err != nil {
-        dbTx.Rollback()
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "api_error", "api_error")
-        return
-    }
+        // get service
+        service, _, err := models.FindServiceByClientId(dbTx, clientID)
+        if err != nil {
+            return err
+        }
 
-    // ensure all objects exists
-    if len(objectsFound) != len(body.IDS) {
-        dbTx.Rollback()
-        services.Res(res).ErrParam("ids").Error(404, "object_error", "one or more objects do not exist")
-        return
-    }
-    
-    // sort object by balance in descending order
-    sort.Sort(services.ByObjectBalance(objectsFound))
-
-    // objects to charge
-    objectsToCharge := []models.Object{}
-
-    // validate each object
-    // check open status (timed and pin)
-    // collect the required objects to sufficiently 
-    // complete a charge from the list of found objects
-    for _, object := range objectsFound {
-
-        // as long as the total balance of objects to be charged is not above charge amount
-        // keep setting aside objects to charge from.
-        // once we have the required objects to cover charge amount, stop processing other objects
-        if TotalBalance(objectsToCharge) < body.Amount {
-            objectsToCharge = append(objectsToCharge, object)
-        } else {
-            break
+        // ensure destination wallet exists
+        wallet, found, err := models.FindWalletByObjectID(dbTx, body.DestinationWalletID)
+        if err != nil {
+            return err
+        } else if !found {
+            services.Res(res).ErrParam("wallet_id").Error(404, "not_found", "wallet_id not found")
+            return errResponseWritten
         }
 
-        // ensure service is the issuer of object
-        if object.Service.ObjectID != service.ObjectID {
-            dbTx.Rollback()
-            services.Res(res).ErrParam("ids").Error(402, "object_error", fmt.Sprintf("%s: service cannot charge an object not issued by it", object.ObjectID))
-            return
+        // amount must fit the precision of the charging issuer's currency
+        if reason := amountPrecisionError(service.Identity, body.Amount); reason != "" {
+            services.Res(res).ErrParam("amount").Error(400, "invalid_parameter", "amount: " + reason)
+            return errResponseWritten
         }
 
-        // ensure object is open
-        if !object.Open {
-            dbTx.Rollback()
-            services.Res(res).ErrParam("ids").Error(402, "object_error", fmt.Sprintf("%s: object is not opened and cannot be charged", object.ObjectID))
-            return
+        // with a quote, objects of another currency are charged at the quote's locked rate.
+        // the amount charged from the objects is the quoted source amount
+        var quote models.Quote
+        chargeAmount := body.Amount
+        if !c.validate.IsEmpty(body.QuoteID) {
+            quote, found, err = models.FindQuoteByObjectID(dbTx, body.QuoteID)
+            if err != nil {
+                return err
+            } else if !found || quote.ServiceID != service.ID {
+                services.Res(res).ErrParam("quote_id").Error(404, "not_found", "quote_id not found")
+                return errResponseWritten
+            }
 
-        } else {
+            if quote.Used {
+                services.Res(res).ErrParam("quote_id").Error(402, "quote_error", "quote has already been used")
+                return errResponseWritten
+            }
+
+            if quote.Expired() {
+                services.Res(res).ErrParam("quote_id").Error(402, "quote_error", "quote has expired")
+                return errResponseWritten
+            }
+
+            if quote.Amount != body.Amount {
+                services.Res(res).ErrParam("amount").Error(400, "invalid_parameter", "amount must match the quoted amount")
+                return errResponseWritten
+            }
+
+            chargeAmount = quote.SourceAmount
+        }
 
-            // for object with open_timed open method, ensure time is not passed
-            if object.OpenMethod == models.ObjectOpenTimed {
-                objectOpenTime := services.UnixToTime(object.OpenTime).UTC()
-                now := time.Now().UTC()
-                if now.After(objectOpenTime) {
-                    dbTx.Rollback()
-                    services.Res(res).ErrParam("ids").Error(402, "object_error", fmt.Sprintf("%s: object open time period has expired", object.ObjectID))
-                    return
+        // ensure meta satisfies the charging issuer's meta schema
+        if reason, err := c.MetaSchemaError(service.Identity, body.Meta); err != nil {
+            return err
+        } else if reason != "" {
+            services.Res(res).ErrParam("meta").Error(400, "invalid_meta", reason)
+            return errResponseWritten
+        }
+
+        // find and lock all objects
+        objectsFound, err := models.FindAllObjectsByObjectIDForUpdate(dbTx, body.IDS)
+        if err != nil {
+            return err
+        }
+
+        // ensure all objects exists
+        if len(objectsFound) != len(body.IDS) {
+            services.Res(res).ErrParam("ids").Error(404, "object_error", "one or more objects do not exist")
+            return errResponseWritten
+        }
+        
+        // sort object by balance in descending order
+        sort.Sort(services.ByObjectBalance(objectsFound))
+
+        // objects to charge
+        objectsToCharge := []models.Object{}
+
+        // validate each object
+        // check open status (timed and pin)
+        // collect the required objects to sufficiently 
+        // complete a charge from the list of found objects
+        for _, object := range objectsFound {
+
+            // as long as the total balance of objects to be charged is not above charge amount
+            // keep setting aside objects to charge from.
+            // once we have the required objects to cover charge amount, stop processing other objects
+            if TotalBalance(objectsToCharge) < chargeAmount {
+                objectsToCharge = append(objectsToCharge, object)
+            } else {
+                break
+            }
+
+            if quote.ID == 0 {
+
+                // ensure service is the issuer of object
+                if object.Service.ObjectID != service.ObjectID {
+                    services.Res(res).ErrParam("ids").Error(402, "object_error", fmt.Sprintf("%s: service cannot charge an object not issued by it", object.ObjectID))
+                    return errResponseWritten
+                }
+
+            } else {
+
+                // ensure objects are of the quoted currency and issued by a single service
+                if object.Service.Identity == nil || object.Service.Identity.BaseCurrency != quote.FromCurrency {
+                    services.Res(res).ErrParam("ids").Error(402, "object_error", fmt.Sprintf("%s: object is not of the quoted currency (%s)", object.ObjectID, quote.FromCurrency))
+                    return errResponseWritten
+                }
+                if object.Service.ObjectID != objectsToCharge[0].Service.ObjectID {
+                    services.Res(res).ErrParam("ids").Error(402, "object_error", fmt.Sprintf("%s: objects charged with a quote must be issued by the same service", object.ObjectID))
+                    return errResponseWritten
                 }
             }
 
-            // for object with open_pin open method, ensure pin is provided and 
-            // it matches. Pin should be found in the optional pin object of the request body
-            if object.OpenMethod == models.ObjectOpenPin {
-                if pin, found := body.Pins[object.ObjectID]; found {
-                    
-                    // ensure pin provided matches objects pin
-                    if !services.BcryptCompare(object.OpenPin, strconv.Itoa(pin)) {
-                        dbTx.Rollback()
-                        services.Res(res).ErrParam("ids").Error(402, "object_error", fmt.Sprintf("%s: pin provided to open object is invalid", object.ObjectID))
-                        return
+            // valueless objects (tickets) have no balance to charge. they can only be redeemed
+            if object.Type == models.ObjectValueless {
+                services.Res(res).ErrParam("ids").Error(402, "object_error", fmt.Sprintf("%s: valueless objects cannot be charged, use redeem_use instead", object.ObjectID))
+                return errResponseWritten
+            }
+
+            // ensure object is open
+            if !object.Open {
+                services.Res(res).ErrParam("ids").Error(402, "object_error", fmt.Sprintf("%s: object is not opened and cannot be charged", object.ObjectID))
+                return errResponseWritten
+
+            } else {
+
+                // for object with open_timed open method, ensure time is not passed
+                if object.OpenMethod == models.ObjectOpenTimed {
+                    objectOpenTime := services.UnixToTime(object.OpenTime).UTC()
+                    now := time.Now().UTC()
+                    if now.After(objectOpenTime) {
+                        services.Res(res).ErrParam("ids").Error(402, "object_error", fmt.Sprintf("%s: object open time period has expired", object.ObjectID))
+                        return errResponseWritten
                     }
+                }
 
-                } else {
-                    dbTx.Rollback()
-                    services.Res(res).ErrParam("ids").Error(402, "object_error", fmt.Sprintf("%s: object pin not found in pin parameter of request body", object.ObjectID))
-                    return
+                // for object with open_pin open method, ensure pin is provided and 
+                // it matches. Pin should be found in the optional pin object of the request body
+                if object.OpenMethod == models.ObjectOpenPin {
+                    if pin, found := body.Pins[object.ObjectID]; found {
+                        
+                        // ensure pin provided matches objects pin
+                        if !services.BcryptCompare(req.Request.Context(), object.OpenPin, strconv.Itoa(pin)) {
+                            metrics.PinFailures.WithLabelValues(metrics.PinInvalid).Inc()
+                            services.Res(res).ErrParam("ids").Error(402, "object_error", fmt.Sprintf("%s: pin provided to open object is invalid", object.ObjectID))
+                            return errResponseWritten
+                        }
+
+                    } else {
+                        metrics.PinFailures.WithLabelValues(metrics.PinMissing).Inc()
+                        services.Res(res).ErrParam("ids").Error(402, "object_error", fmt.Sprintf("%s: object pin not found in pin parameter of request body", object.ObjectID))
+                        return errResponseWritten
+                    }
                 }
             }
         }
-    }
 
-    totalObjectsBalance := TotalBalance(objectsToCharge)
+        totalObjectsBalance := TotalBalance(objectsToCharge)
+
+        // ensure total balance of objects to charge is sufficient for charge amount
+        if totalObjectsBalance < chargeAmount {
+            services.Res(res).ErrParam("amount").Error(402, "invalid_parameter", fmt.Sprintf("object%s total balance not sufficient to cover charge amount", services.SIfNotZero(len(body.IDS))))
+            return errResponseWritten
+        }
+        
+        lastObj := objectsToCharge[len(objectsToCharge) - 1]
+
+        // the new object is issued by the charging service. With a quote, the destination wallet
+        // receives an object of the charging service for the quoted amount in its currency. The
+        // charging issuer's soul balance backs the new object and the charged objects' issuer
+        // gets back the source amount in its soul balance
+        if quote.ID != 0 {
+            target, err := models.AddToSoulInTransaction(dbTx, service.Identity.ObjectID, -quote.Amount)
+            if err != nil {
+                return err
+            } else if target.SoulBalance < 0 {
+                services.Res(res).ErrParam("quote_id").Error(402, "insufficient_soul_balance", fmt.Sprintf("not enough soul balance to issue the quoted amount. Requires %.2f soul balance", quote.Amount))
+                return errResponseWritten
+            }
+            if _, err := models.AddToSoulInTransaction(dbTx, lastObj.Service.Identity.ObjectID, chargeAmount); err != nil {
+                return err
+            }
+
+            // use the quote. fails if a concurrent charge used it first
+            if used, err := models.UseQuote(dbTx, &quote); err != nil {
+                return err
+            } else if !used {
+                services.Res(res).ErrParam("quote_id").Error(402, "quote_error", "quote has already been used")
+                return errResponseWritten
+            }
+        }
+
+        // if there is excess, the last object is always the supplement object.
+        // deduct from last object's balance, update object and remove it from the objectsToCharge list
+        if totalObjectsBalance > chargeAmount {
+            lastObj.Balance = totalObjectsBalance - chargeAmount
+            objectsToCharge = objectsToCharge[0:len(objectsToCharge)-1]
+            if err := dbTx.Save(&lastObj).Error; err != nil {
+                return err
+            }
+        }
+
+        // delete the objects to charge
+        for _, object := range objectsToCharge {
+            if err := dbTx.Delete(&object).Error; err != nil {
+                return err
+            }
+        }
+
+        // create new object. set balance to the amount charged in the charging issuer's currency
+        // generate a pin
+        newPin, err := newObjectPin(dbTx, service.Identity)
+        if err != nil {
+            return err
+        }
 
-    // ensure total balance of objects to charge is sufficient for charge amount
-    if totalObjectsBalance < body.Amount {
-        dbTx.Rollback()
-        services.Res(res).ErrParam("amount").Error(402, "invalid_parameter", fmt.Sprintf("object%s total balance not sufficient to cover charge amount", services.SIfNotZero(len(body.IDS))))
+        newObj = NewObject(newPin, models.ObjectValue, service, wallet, body.Amount, body.Meta)
+        if err := createObject(dbTx, &newObj, service.Identity); err != nil {
+            return err
+        }
+
+        // describe the charge. the remainder object is the last charged object if it was partially charged
+        chargedObjects := []string{}
+        sourceWallets := []models.Wallet{ wallet }
+        for _, object := range objectsToCharge {
+            chargedObjects = append(chargedObjects, object.ObjectID)
+            sourceWallets = append(sourceWallets, object.Wallet)
+        }
+        chargeData := map[string]interface{}{ "object": objectEventData(newObj), "amount": body.Amount, "charged_objects": chargedObjects }
+        if totalObjectsBalance > chargeAmount {
+            chargeData["remainder_object"] = objectEventData(lastObj)
+            sourceWallets = append(sourceWallets, lastObj.Wallet)
+        }
+        notifyServices := []uint{ service.ID }
+        if quote.ID != 0 {
+            chargeData["quote"] = quote
+            if lastObj.Service.ID != service.ID {
+                notifyServices = append(notifyServices, lastObj.Service.ID)
+            }
+        }
+
+        // record domain event. the remainder object is updated, charged objects are deleted
+        chargeUpserted := []models.Object{ newObj }
+        if totalObjectsBalance > chargeAmount {
+            chargeUpserted = append(chargeUpserted, lastObj)
+        }
+        if err := appendObjectEvent(dbTx, models.EventObjectCharged, chargeUpserted, objectsToCharge...); err != nil {
+            return err
+        }
+
+        // the amount charged from the objects is counted for the objects' issuer
+        chargedAmount = chargeAmount
+        if lastObj.Service.Identity != nil {
+            chargedIssuer = lastObj.Service.Identity.ObjectName
+        }
+
+        // queue event for webhook delivery
+        return queueEvent(dbTx, EventObjectCharged, chargeData, notifyServices, sourceWallets...)
+    })
+    if err == errResponseWritten {
+        return
+    } else if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "api_error", "server error")
         return
     }
-    
-    lastObj := objectsToCharge[len(objectsToCharge) - 1]
 
-    // if there is excess, the last object is always the supplement object.
-    // deduct from last object's balance, update object and remove it from the objectsToCharge list
-    if totalObjectsBalance > body.Amount {
-        lastObj.Balance = totalObjectsBalance - body.Amount
-        objectsToCharge = objectsToCharge[0:len(objectsToCharge)-1]
-        dbTx.Save(&lastObj)
-    }
+    metrics.ChargedAmount.WithLabelValues(chargedIssuer).Add(chargedAmount)
+    services.Res(res).Json(newObj)
+}
 
-    // delete the objects to charge
-    for _, object := range objectsToCharge {
-        dbTx.Delete(&object)
+// redeem a single use of a valueless object (ticket). Only the issuing service can
+// redeem a ticket. Each use is recorded and if the ticket has a use limit (max_uses),
+// the number of remaining uses is reduced until the ticket is exhausted
+func (c *ObjectController) RedeemUse(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, log *config.CustomLog, db *services.DB) {
+
+    // parse body. body is optional
+    var body objectRedeemUseBody
+    if req.ContentLength != 0 {
+        if err := c.ParseJsonBody(req, &body); err != nil {
+            services.Res(res).Error(400, "invalid_body", "request body is invalid or malformed. Expects valid json body")
+            return 
+        }
     }
 
-    // create new object. set balance to charge balance
-    // generate a pin
-    countryCallCode := config.CurrencyCallCodes[strings.ToUpper(service.Identity.BaseCurrency)]
-    newPin, err := services.NewObjectPin(strconv.Itoa(countryCallCode))
-    if err != nil {
-        dbTx.Rollback()
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "api_error", "server error")
+    // if meta is provided, ensure it is not greater than the limit size
+    if body.Meta.Size() > c.settings.Limits.MaxMetaSize {
+        services.Res(res).ErrParam("meta").Error(400, "invalid_parameter", fmt.Sprintf("Meta contains too much data. Max size is %d bytes", c.settings.Limits.MaxMetaSize))
         return
     }
 
-    newObj := NewObject(newPin, models.ObjectValue, service, wallet, body.Amount, body.Meta)
-    err = models.CreateObject(dbTx, &newObj)
-    if err != nil {
-        dbTx.Rollback()
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "api_error", "server error")
+    // TODO: get client id from access token
+    clientID := "kl14zFDq4SHlmmmVNHgLtE0LqCo8BTjyShOH"
+
+    // redeem in a transaction that is retried if it conflicts with a concurrent one.
+    // The object is locked so concurrent redeems cannot use the same use
+    var service models.Service
+    var object models.Object
+    var use models.ObjectUse
+    err := db.RepeatableReadTransaction(func(dbTx *gorm.DB) error {
+
+        // get service
+        var err error
+        if service, _, err = models.FindServiceByClientId(dbTx, clientID); err != nil {
+            return err
+        }
+
+        // get the object by id or pin, then lock it
+        found := false
+        object, found, err = models.FindObjectByObjectIDOrPin(dbTx, services.NormalizePin(params["id"]))
+        if err == nil && found {
+            object, found, err = models.FindObjectByObjectIDForUpdate(dbTx, object.ObjectID)
+        }
+        if err != nil {
+            return err
+        } else if !found {
+            services.Res(res).Error(404, "not_found", "object was not found")
+            return errResponseWritten
+        }
+
+        // only valueless objects can be redeemed
+        if object.Type != models.ObjectValueless {
+            services.Res(res).Error(400, "invalid_parameter", "object: only valueless objects (obj_valueless) can be redeemed")
+            return errResponseWritten
+        }
+
+        // ensure service is the issuer of the object
+        if object.Service.ObjectID != service.ObjectID {
+            services.Res(res).Error(401, "unauthorized", "service cannot redeem an object not issued by it")
+            return errResponseWritten
+        }
+
+        // ensure the ticket still has uses left
+        if object.MaxUses > 0 && object.Uses >= object.MaxUses {
+            services.Res(res).Error(402, "object_error", "object has no remaining uses")
+            return errResponseWritten
+        }
+
+        // record the use
+        object.Uses = object.Uses + 1
+        use = models.ObjectUse{
+            ObjectID: services.NewObjectID(),
+            TicketID: sql.NullInt64{ Int64: int64(object.ID), Valid: true },
+            ServiceID: object.ServiceID,
+            WalletID: object.WalletID,
+            Meta: body.Meta,
+        }
+
+        if err := models.CreateObjectUse(dbTx, &use); err != nil {
+            return err
+        }
+
+        if err := dbTx.Save(&object).Error; err != nil {
+            return err
+        }
+
+        // record domain event
+        if err := appendObjectEvent(dbTx, models.EventObjectRedeemed, []models.Object{ object }); err != nil {
+            return err
+        }
+
+        // queue event for webhook delivery
+        return queueEvent(dbTx, EventObjectRedeemed, map[string]interface{}{ "object": objectEventData(object), "use": use }, []uint{ service.ID }, object.Wallet)
+    })
+    if err == errResponseWritten {
+        return
+    } else if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
         return
     }
 
-    dbTx.Save(&newObj).Commit()
-    services.Res(res).Json(newObj)
+    respObj, _ := services.StructToJsonToMap(use)
+    respObj["object"] = object.ObjectID
+    respObj["uses"] = object.Uses
+    if object.MaxUses > 0 {
+        respObj["remaining_uses"] = object.MaxUses - object.Uses
+    }
+    services.Res(res).Json(respObj)
 }
-
-
@@ -0,0 +1,573 @@
+package controllers
+
+import (
+    "net/http"
+    "github.com/ownode/services"
+    "github.com/ownode/config"
+    "github.com/ownode/models"
+    "github.com/go-martini/martini"
+    "github.com/jinzhu/gorm"
+    "encoding/json"
+    "encoding/csv"
+    "database/sql"
+    "strings"
+    "strconv"
+    "errors"
+    "fmt"
+    "io"
+    "sync"
+    "time"
+    "github.com/ownode/metrics"
+)
+
+var (
+    ObjectBatch ObjectBatchController
+
+    // maximum number of rows a batch can contain
+    MaxBatchRows = 50000
+
+    // maximum number of objects a single batch row can create
+    MaxObjectsPerBatchRow = 10000
+
+    // maximum number of rows processed in a single transaction
+    BatchChunkSize = 500
+
+    // maximum number of objects created in a single transaction. A chunk
+    // always has at least one row, so this must not be below MaxObjectsPerBatchRow
+    BatchChunkObjects = 10000
+
+    // how long a claim on a batch lasts. Claims are renewed before every chunk,
+    // an instance that stops renewing loses the batch to the next instance to resume it
+    BatchLeaseDuration = 5 * time.Minute
+
+    // number of objects inserted by a single insert statement
+    BatchInsertSize = 1000
+
+    // returned when a chunk is not saved because another instance claimed its batch
+    errBatchClaimLost = errors.New("claim on batch was lost")
+)
+
+type objectBatchRow struct {
+    WalletID string             `json:"wallet_id"`
+    NumberOfObjects int         `json:"number_objects"`
+    BalancePerObject float64    `json:"unit_per_object"`
+    Meta models.Meta            `json:"meta"`
+}
+
+type objectBatchCreateBody struct {
+    Type string                 `json:"type"`
+    MaxUses int                 `json:"max_uses"`
+    Rows []objectBatchRow       `json:"rows"`
+}
+
+func init() {
+    ObjectBatch = ObjectBatchController{ BaseController: &Base, stopping: make(chan struct{}), owner: services.NewObjectID() }
+}
+
+type ObjectBatchController struct {
+    *BaseController
+
+    // batches being processed in the background
+    processing sync.WaitGroup
+
+    // closed to stop processing batches. They resume on the next start
+    stopping chan struct{}
+
+    // identifies this instance in the claims of the batches it processes
+    owner string
+}
+
+// parse batch rows from a csv source. The first line must be a header
+// containing at least the wallet_id and number_objects columns
+func parseBatchCSV(r io.Reader) ([]objectBatchRow, error) {
+
+    reader := csv.NewReader(r)
+    reader.TrimLeadingSpace = true
+
+    header, err := reader.Read()
+    if err != nil {
+        return nil, errors.New("csv: unable to read header")
+    }
+
+    columns := map[string]int{}
+    for i, name := range header {
+        columns[strings.ToLower(strings.TrimSpace(name))] = i
+    }
+
+    for _, required := range []string{ "wallet_id", "number_objects" } {
+        if _, ok := columns[required]; !ok {
+            return nil, errors.New("csv: missing required column: " + required)
+        }
+    }
+
+    // returns the value of a column in a record or an empty string
+    // if the column is not in the header
+    get := func(record []string, column string) string {
+        if i, ok := columns[column]; ok && i < len(record) {
+            return strings.TrimSpace(record[i])
+        }
+        return ""
+    }
+
+    rows := []objectBatchRow{}
+    for line := 2; ; line++ {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, fmt.Errorf("csv: line %d is malformed", line)
+        }
+
+        row := objectBatchRow{ WalletID: get(record, "wallet_id"), Meta: models.MetaFromString(get(record, "meta")) }
+
+        if row.NumberOfObjects, err = strconv.Atoi(get(record, "number_objects")); err != nil {
+            return nil, fmt.Errorf("csv: line %d: number_objects must be an integer", line)
+        }
+
+        if unit := get(record, "unit_per_object"); unit != "" {
+            if row.BalancePerObject, err = strconv.ParseFloat(unit, 64); err != nil {
+                return nil, fmt.Errorf("csv: line %d: unit_per_object must be a number", line)
+            }
+        }
+
+        rows = append(rows, row)
+    }
+
+    return rows, nil
+}
+
+// parse the body of a batch create request. Supports a json body, a raw csv body
+// (text/csv with type passed as query) or a multipart upload of a csv or json list in the `file` field
+func (c *ObjectBatchController) parseBody(req services.AuxRequestContext) (objectBatchCreateBody, error) {
+
+    var body objectBatchCreateBody
+    contentType := strings.ToLower(req.Header.Get("Content-Type"))
+
+    switch {
+    case services.StringStartsWith(contentType, "multipart/form-data"):
+        file, header, err := req.FormFile("file")
+        if err != nil {
+            return body, errors.New("file: upload a csv or json file in the `file` field")
+        }
+        defer file.Close()
+
+        body.Type = req.FormValue("type")
+        if maxUses := req.FormValue("max_uses"); maxUses != "" {
+            if body.MaxUses, err = strconv.Atoi(maxUses); err != nil {
+                return body, errors.New("max_uses: must be an integer")
+            }
+        }
+
+        if services.StringEndsWith(strings.ToLower(header.Filename), ".csv") {
+            body.Rows, err = parseBatchCSV(file)
+            return body, err
+        }
+
+        if err := json.NewDecoder(file).Decode(&body.Rows); err != nil {
+            return body, errors.New("file: json file must contain a list of rows")
+        }
+
+    case services.StringStartsWith(contentType, "text/csv"):
+        var err error
+        query := req.URL.Query()
+        body.Type = query.Get("type")
+        if maxUses := query.Get("max_uses"); maxUses != "" {
+            if body.MaxUses, err = strconv.Atoi(maxUses); err != nil {
+                return body, errors.New("max_uses: must be an integer")
+            }
+        }
+        body.Rows, err = parseBatchCSV(req.Body)
+        return body, err
+
+    default:
+        if err := c.ParseJsonBody(req, &body); err != nil {
+            return body, errors.New("request body is invalid or malformed. Expects valid json body")
+        }
+    }
+
+    return body, nil
+}
+
+// create an object batch. Rows are validated and processed asynchronously.
+// Use the batch id to follow the progress of the batch
+func (c *ObjectBatchController) Create(res http.ResponseWriter, req services.AuxRequestContext, log *config.CustomLog, db *services.DB) {
+
+    // TODO: get client id from access token
+    clientID := "kl14zFDq4SHlmmmVNHgLtE0LqCo8BTjyShOH"
+
+    body, err := c.parseBody(req)
+    if err != nil {
+        services.Res(res).Error(400, "invalid_body", err.Error())
+        return
+    }
+
+    // get service
+    service, found, err := models.FindServiceByClientId(db.GetPostgresHandle(), clientID)
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    } else if !found || !service.Identity.Issuer {
+        services.Res(res).Error(401, "unauthorized_service", "service is not an issuer")
+        return
+    }
+
+    // validate type and max uses
+    body.Type = strings.ToLower(body.Type)
+    if issueErr := objectTypeError(body.Type, body.MaxUses); issueErr != nil {
+        services.Res(res).Error(issueErr.status, issueErr.errType, issueErr.message)
+        return
+    }
+
+    // ensure rows are provided
+    if len(body.Rows) == 0 {
+        services.Res(res).ErrParam("rows").Error(400, "missing_parameter", "Missing required field: rows")
+        return
+    }
+
+    // ensure rows do not exceed the limit
+    if len(body.Rows) > MaxBatchRows {
+        services.Res(res).ErrParam("rows").Error(400, "invalid_parameter", fmt.Sprintf("rows: a batch cannot contain more than %d rows", MaxBatchRows))
+        return
+    }
+
+    rows, err := json.Marshal(body.Rows)
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    batch := models.ObjectBatch{
+        ObjectID: services.NewObjectID(),
+        ServiceID: sql.NullInt64{ Int64: int64(service.ID), Valid: true },
+        Type: body.Type,
+        MaxUses: body.MaxUses,
+        Status: models.BatchPending,
+        TotalRows: len(body.Rows),
+        Rows: string(rows),
+    }
+
+    if err := models.CreateObjectBatch(db.GetPostgresHandle(), &batch); err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    c.start(db, batch.ID)
+
+    services.Res(res).Json(batch)
+}
+
+// get the status and progress of a batch including the rows that failed.
+// use `failures_limit` query to set the number of failures returned. max is 1000
+func (c *ObjectBatchController) Get(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, log *config.CustomLog, db *services.DB) {
+
+    // TODO: get client id from access token
+    clientID := "kl14zFDq4SHlmmmVNHgLtE0LqCo8BTjyShOH"
+
+    dbCon := db.GetPostgresHandle()
+
+    // get service
+    service, _, _ := models.FindServiceByClientId(dbCon, clientID)
+
+    batch, found, err := models.FindObjectBatchByObjectID(dbCon, params["id"])
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    } else if !found {
+        services.Res(res).Error(404, "not_found", "batch was not found")
+        return
+    }
+
+    // ensure batch was created by the service
+    if batch.ServiceID.Int64 != int64(service.ID) {
+        services.Res(res).Error(401, "unauthorized", "batch was not created by this service")
+        return
+    }
+
+    failuresLimit := 100
+    if qLimit := req.URL.Query().Get("failures_limit"); !c.validate.IsEmpty(qLimit) {
+        if limit, err := strconv.Atoi(qLimit); err == nil && limit > 0 {
+            failuresLimit = limit
+            if failuresLimit > 1000 {
+                failuresLimit = 1000
+            }
+        }
+    }
+
+    failures, err := models.FindObjectBatchFailures(dbCon, batch.ID, failuresLimit)
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    respObj, _ := services.StructToJsonToMap(batch)
+    respObj["failures"] = failures
+    services.Res(res).Json(respObj)
+}
+
+// resume processing of batches that were not completed.
+// Should be called on start up. Batches claimed by another running
+// instance are skipped
+func (c *ObjectBatchController) Resume(db *services.DB) {
+    batches, err := models.FindUnfinishedObjectBatches(db.GetPostgresHandle())
+    if err != nil {
+        c.log.Error("unable to find unfinished batches. reason: " + err.Error())
+        return
+    }
+    for _, batch := range batches {
+        c.start(db, batch.ID)
+    }
+}
+
+// stop processing batches and wait for the chunks being processed to complete.
+// Unfinished batches resume when the server starts again
+func (c *ObjectBatchController) Stop() {
+    close(c.stopping)
+    c.processing.Wait()
+}
+
+// process a batch in the background
+func (c *ObjectBatchController) start(db *services.DB, batchID uint) {
+    c.processing.Add(1)
+    go func() {
+        defer c.processing.Done()
+        c.process(db, batchID)
+    }()
+}
+
+// process the rows of a batch in chunks. Each chunk is processed in its own transaction
+// and the progress of the batch is saved with the chunk. The batch is claimed
+// first and left alone if another instance holds it
+func (c *ObjectBatchController) process(db *services.DB, batchID uint) {
+
+    if claimed, err := models.ClaimObjectBatch(db.GetPostgresHandle(), batchID, c.owner, BatchLeaseDuration); err != nil {
+        c.log.Error(fmt.Sprintf("batch %d: unable to claim batch. reason: %s", batchID, err.Error()))
+        return
+    } else if !claimed {
+        return
+    }
+
+    batch, found, err := models.FindObjectBatchById(db.GetPostgresHandle(), batchID)
+    if err != nil || !found {
+        c.log.Error(fmt.Sprintf("batch %d: unable to load batch", batchID))
+        return
+    }
+
+    rows := []objectBatchRow{}
+    if err := json.Unmarshal([]byte(batch.Rows), &rows); err != nil {
+        c.fail(db, &batch, "unable to read batch rows")
+        return
+    }
+
+    batch.Status = models.BatchProcessing
+    if err := models.SetObjectBatchStatus(db.GetPostgresHandle(), batch.ID, c.owner, batch.Status, ""); err != nil {
+        c.log.Error(err.Error())
+        return
+    }
+
+    for batch.ProcessedRows < len(rows) {
+
+        // progress is saved with every chunk, so a stopped batch continues from the next chunk
+        select {
+        case <-c.stopping:
+            if err := models.ReleaseObjectBatch(db.GetPostgresHandle(), batch.ID, c.owner); err != nil {
+                c.log.Error(err.Error())
+            }
+            return
+        default:
+        }
+
+        // renew the claim. Another instance took over if it expired
+        if claimed, err := models.ClaimObjectBatch(db.GetPostgresHandle(), batch.ID, c.owner, BatchLeaseDuration); err != nil || !claimed {
+            c.log.Error(fmt.Sprintf("batch %s: lost claim on batch", batch.ObjectID))
+            return
+        }
+
+        end := chunkEnd(rows, batch.ProcessedRows)
+
+        // conflicts with concurrent transactions were retried. The batch is
+        // left to the instance that claimed it if the claim was lost, and
+        // resumes on the next start if it still conflicts
+        if err := c.processChunk(db, &batch, rows, end); err == errBatchClaimLost {
+            c.log.Error(fmt.Sprintf("batch %s: lost claim on batch", batch.ObjectID))
+            return
+        } else if models.IsRetryableError(err) {
+            c.log.Error(fmt.Sprintf("batch %s: %s", batch.ObjectID, err.Error()))
+            if err := models.ReleaseObjectBatch(db.GetPostgresHandle(), batch.ID, c.owner); err != nil {
+                c.log.Error(err.Error())
+            }
+            return
+        } else if err != nil {
+            c.log.Error(fmt.Sprintf("batch %s: %s", batch.ObjectID, err.Error()))
+            c.fail(db, &batch, fmt.Sprintf("unable to process rows %d to %d", batch.ProcessedRows + 1, end))
+            return
+        }
+    }
+
+    batch.Status = models.BatchCompleted
+    if err := models.SetObjectBatchStatus(db.GetPostgresHandle(), batch.ID, c.owner, batch.Status, ""); err != nil {
+        c.log.Error(err.Error())
+    }
+}
+
+// the end of the chunk of rows starting at start. A chunk has at most BatchChunkSize
+// rows and creates at most BatchChunkObjects objects, but always has at least one row.
+// Rows with an invalid number of objects fail and create none
+func chunkEnd(rows []objectBatchRow, start int) int {
+    end, objects := start, 0
+    for end < len(rows) && end - start < BatchChunkSize {
+        n := rows[end].NumberOfObjects
+        if n < 1 || n > MaxObjectsPerBatchRow {
+            n = 0
+        }
+        if end > start && objects + n > BatchChunkObjects {
+            break
+        }
+        objects += n
+        end++
+    }
+    return end
+}
+
+// mark a batch as failed. Only its status and error are saved
+func (c *ObjectBatchController) fail(db *services.DB, batch *models.ObjectBatch, reason string) {
+    batch.Status = models.BatchFailed
+    batch.Error = reason
+    if err := models.SetObjectBatchStatus(db.GetPostgresHandle(), batch.ID, c.owner, batch.Status, reason); err != nil {
+        c.log.Error(err.Error())
+    }
+}
+
+// process rows from the batch's processed rows up to `end`. Invalid rows are recorded
+// as failures, valid rows are created with bulk inserts. The chunk runs in a
+// transaction that is retried if it conflicts with a concurrent one and is
+// rolled back with errBatchClaimLost if another instance took over the batch
+func (c *ObjectBatchController) processChunk(db *services.DB, batch *models.ObjectBatch, rows []objectBatchRow, end int) error {
+
+    var updated models.ObjectBatch
+    var issuer string
+    err := db.RepeatableReadTransaction(func(dbTx *gorm.DB) error {
+
+        // get service. Loaded in the transaction to get the current soul balance
+        service, found, err := models.FindServiceById(dbTx, uint(batch.ServiceID.Int64))
+        if err != nil {
+            return err
+        } else if !found {
+            return errors.New("unable to load batch service")
+        }
+
+        // load all wallets referenced in the chunk
+        walletIDs := []string{}
+        for _, row := range rows[batch.ProcessedRows:end] {
+            walletIDs = append(walletIDs, row.WalletID)
+        }
+
+        wallets, err := models.FindAllWalletsByObjectID(dbTx, walletIDs)
+        if err != nil {
+            return err
+        }
+
+        walletsByID := map[string]models.Wallet{}
+        for _, wallet := range wallets {
+            walletsByID[wallet.ObjectID] = wallet
+        }
+
+        newObjects := []models.Object{}
+        failures := []models.ObjectBatchFailure{}
+
+        for i := batch.ProcessedRows; i < end; i++ {
+
+            row := rows[i]
+            wallet, walletFound := walletsByID[row.WalletID]
+
+            // rows are checked like the objects of a create request
+            reason := ""
+            if issueErr := objectIssueError(service.Identity, batch.Type, row.WalletID, row.NumberOfObjects, MaxObjectsPerBatchRow, row.BalancePerObject, row.Meta, c.settings.Limits.MaxMetaSize); issueErr != nil {
+                reason = issueErr.message
+            } else if issueErr := walletIssueError(service.Identity, wallet, walletFound); issueErr != nil {
+                reason = issueErr.message
+            } else if reason, err = c.MetaSchemaError(service.Identity, row.Meta); err != nil {
+                return err
+            }
+
+            if reason != "" {
+                failures = append(failures, models.ObjectBatchFailure{
+                    BatchID: sql.NullInt64{ Int64: int64(batch.ID), Valid: true },
+                    RowNumber: i + 1,
+                    WalletID: row.WalletID,
+                    Reason: reason,
+                })
+                continue
+            }
+
+            if batch.Type == models.ObjectValue {
+                service.Identity.SoulBalance = service.Identity.SoulBalance - float64(row.NumberOfObjects) * row.BalancePerObject
+            }
+
+            for j := 0; j < row.NumberOfObjects; j++ {
+                newObj := NewObject("", batch.Type, service, wallet, row.BalancePerObject, row.Meta)
+                newObj.MaxUses = batch.MaxUses
+                newObjects = append(newObjects, newObj)
+            }
+        }
+
+        // generate pins for all objects of the chunk
+        newPins, err := newObjectPins(dbTx, service.Identity, len(newObjects))
+        if err != nil {
+            return err
+        }
+        for i := range newObjects {
+            newObjects[i].Pin = newPins[i]
+        }
+
+        err = models.BulkCreateObjects(dbTx, newObjects, BatchInsertSize, services.MaxPinAttempts, func(n int) ([]string, error) {
+            return newObjectPins(dbTx, service.Identity, n)
+        })
+        if err != nil {
+            return err
+        }
+
+        // record domain event
+        if len(newObjects) > 0 {
+            if err := appendObjectEvent(dbTx, models.EventObjectCreated, newObjects); err != nil {
+                return err
+            }
+        }
+
+        for _, failure := range failures {
+            if err := models.CreateObjectBatchFailure(dbTx, &failure); err != nil {
+                return err
+            }
+        }
+
+        // update soul balance
+        if err := dbTx.Save(service.Identity).Error; err != nil {
+            return err
+        }
+
+        // save the progress of the batch if this instance still holds it
+        updated = *batch
+        updated.ProcessedRows = end
+        updated.FailedRows = batch.FailedRows + len(failures)
+        updated.ObjectsCreated = batch.ObjectsCreated + len(newObjects)
+        if advanced, err := models.AdvanceObjectBatch(dbTx, updated, c.owner, batch.ProcessedRows); err != nil {
+            return err
+        } else if !advanced {
+            return errBatchClaimLost
+        }
+
+        issuer = service.Identity.ObjectName
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    metrics.ObjectsCreated.WithLabelValues(issuer).Add(float64(updated.ObjectsCreated - batch.ObjectsCreated))
+
+    *batch = updated
+    return nil
+}
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+    "testing"
+    "github.com/ownode/models"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestChunkEnd(t *testing.T) {
+    assert := assert.New(t)
+    rows := []objectBatchRow{ { NumberOfObjects: 6000 }, { NumberOfObjects: 3000 }, { NumberOfObjects: 2000 }, { NumberOfObjects: -1 }, { NumberOfObjects: 1 } }
+    assert.Equal(2, chunkEnd(rows, 0), "chunk is bounded by the number of objects")
+    assert.Equal(5, chunkEnd(rows, 2), "invalid rows create no objects")
+    assert.Equal(5, chunkEnd(rows, 4))
+
+    big := []objectBatchRow{ { NumberOfObjects: MaxObjectsPerBatchRow }, { NumberOfObjects: 1 } }
+    assert.Equal(1, chunkEnd(big, 0), "a chunk has at least one row")
+
+    small := make([]objectBatchRow, BatchChunkSize + 10)
+    for i := range small {
+        small[i].NumberOfObjects = 1
+    }
+    assert.Equal(BatchChunkSize, chunkEnd(small, 0), "chunk is bounded by the number of rows")
+}
+
+func TestObjectIssueError(t *testing.T) {
+    assert := assert.New(t)
+    issuer := &models.Identity{ ObjectID: "issuer", BaseCurrency: "USD", SoulBalance: 10 }
+
+    assert.Nil(objectIssueError(issuer, models.ObjectValue, "wallet", 2, 10, 5, "", 100))
+    assert.Equal("missing_parameter", objectIssueError(issuer, models.ObjectValue, "", 1, 10, 1, "", 100).errType)
+    assert.Equal("invalid_number_objects", objectIssueError(issuer, models.ObjectValue, "wallet", 11, 10, 1, "", 100).errType)
+    assert.Equal("invalid_unit_per_object", objectIssueError(issuer, models.ObjectValue, "wallet", 1, 10, 1.005, "", 100).errType, "precision of the currency")
+    assert.Equal("insufficient_soul_balance", objectIssueError(issuer, models.ObjectValue, "wallet", 3, 10, 5, "", 100).errType)
+    assert.Nil(objectIssueError(issuer, models.ObjectValueless, "wallet", 3, 10, 0, "", 100), "valueless objects use no soul balance")
+
+    assert.Equal("invalid_type", objectTypeError("obj_other", 0).errType)
+    assert.Equal("invalid_max_uses", objectTypeError(models.ObjectValue, 2).errType)
+    assert.Nil(objectTypeError(models.ObjectValueless, 2))
+
+    assert.Equal(404, walletIssueError(issuer, models.Wallet{}, false).status)
+    assert.Equal(401, walletIssueError(issuer, models.Wallet{ Identity: models.Identity{ ObjectID: "other" } }, true).status)
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+    "github.com/ownode/models"
+    validator "github.com/asaskevich/govalidator"
+    "fmt"
+)
+
+// a reason objects cannot be issued. Handlers respond with it,
+// batches record its message as the reason a row failed
+type issueError struct {
+    status int
+    errType string
+    message string
+}
+
+// check the type and max uses of objects to issue. type must be lower case
+func objectTypeError(objType string, maxUses int) *issueError {
+
+    // type is required
+    if validator.IsNull(objType) {
+        return &issueError{ 400, "missing_parameter", "Missing required field: type" }
+    }
+
+    // ensure type is valid
+    if objType != models.ObjectValue && objType != models.ObjectValueless {
+        return &issueError{ 400, "invalid_type", "type can only be obj_value or obj_valueless" }
+    }
+
+    // max uses can only be set on valueless objects (tickets) and must not be negative.
+    // zero means the ticket can be used an unlimited number of times
+    if maxUses != 0 && objType != models.ObjectValueless {
+        return &issueError{ 400, "invalid_max_uses", "max_uses can only be set on obj_valueless objects" }
+    } else if maxUses < 0 {
+        return &issueError{ 400, "invalid_max_uses", "max_uses must not be negative" }
+    }
+
+    return nil
+}
+
+// check that an issuer can issue `number` objects of a type with balancePerObject
+// each to a wallet. maxObjects is the maximum number of objects issued at once
+func objectIssueError(issuer *models.Identity, objType, walletID string, number, maxObjects int, balancePerObject float64, meta models.Meta, maxMetaSize int) *issueError {
+
+    // wallet id is required
+    if validator.IsNull(walletID) {
+        return &issueError{ 400, "missing_parameter", "Missing required field: wallet_id" }
+    }
+
+    // ensure number of objects is within the limit
+    if number < 1 {
+        return &issueError{ 400, "invalid_number_objects", fmt.Sprintf("number_objects must be atleast 1 but not more than %d", maxObjects) }
+    } else if number > maxObjects {
+        return &issueError{ 400, "invalid_number_objects", fmt.Sprintf("number_objects must not be more than %d", maxObjects) }
+    }
+
+    // for object of value, validate unit per object and the issuer's soul balance
+    if objType == models.ObjectValue {
+
+        if balancePerObject == 0 {
+            return &issueError{ 400, "missing_parameter", "Missing required field: unit_per_object" }
+        }
+
+        if balancePerObject < MinimumObjectUnit {
+            return &issueError{ 400, "invalid_unit_per_object", "unit_per_object must be equal or greater than the minimum object unit which is 0.00000001" }
+        }
+
+        if reason := amountPrecisionError(issuer, balancePerObject); reason != "" {
+            return &issueError{ 400, "invalid_unit_per_object", "unit_per_object: " + reason }
+        }
+
+        if soulBalanceRequired := float64(number) * balancePerObject; issuer.SoulBalance < soulBalanceRequired {
+            return &issueError{ 400, "insufficient_soul_balance", fmt.Sprintf("not enough soul balance to create object(s). Requires %.2f soul balance", soulBalanceRequired) }
+        }
+    }
+
+    // if meta is provided, ensure it is not greater than the limit size
+    if meta.Size() > maxMetaSize {
+        return &issueError{ 400, "invalid_meta_size", fmt.Sprintf("Meta contains too much data. Max size is %d bytes", maxMetaSize) }
+    }
+
+    return nil
+}
+
+// check that a wallet can receive objects of an issuer
+func walletIssueError(issuer *models.Identity, wallet models.Wallet, found bool) *issueError {
+
+    // ensure wallet exists
+    if !found {
+        return &issueError{ 404, "invalid_wallet", "wallet_id is unknown" }
+    }
+
+    // ensure issuer owns the wallet
+    if issuer.ObjectID != wallet.Identity.ObjectID {
+        return &issueError{ 401, "invalid_wallet", "wallet is not owned by this service. Use a wallet created by this service" }
+    }
+
+    return nil
+}
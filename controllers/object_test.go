@@ -0,0 +1,55 @@
+package controllers
+
+import (
+    "encoding/json"
+    "net/http/httptest"
+    "testing"
+    "github.com/go-martini/martini"
+    "github.com/ownode/models"
+    "github.com/ownode/services"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestObjectGet(t *testing.T) {
+    assert := assert.New(t)
+    repos := models.NewMemoryRepos()
+    identity := models.Identity{ ObjectID: services.NewObjectID(), FullName: "gold shop", Email: "gold@shop.com", Issuer: true, ObjectName: "gold", BaseCurrency: "USD", SoulBalance: 100 }
+    assert.Nil(repos.Identities().Create(&identity))
+    service := models.Service{ ObjectID: services.NewObjectID(), Name: "gold", Identity: &identity }
+    assert.Nil(repos.Services().Create(&service))
+    wallet := models.Wallet{ ObjectID: services.NewObjectID(), Handle: "john", Identity: identity }
+    assert.Nil(repos.Wallets().Create(&wallet))
+    object := NewObject("1234", models.ObjectValue, service, wallet, 10, "")
+    assert.Nil(repos.Objects().Create(&object))
+
+    // objects are found by id or pin. Private fields of identities are removed
+    for _, id := range []string{ object.ObjectID, "1234" } {
+        res := httptest.NewRecorder()
+        Object.Get(martini.Params{ "id": id }, res, newJsonRequest(""), nil, repos)
+        assert.Equal(200, res.Code)
+        var found map[string]interface{}
+        assert.Nil(json.Unmarshal(res.Body.Bytes(), &found))
+        assert.Equal(object.ObjectID, found["id"])
+        assert.NotContains(found["service"].(map[string]interface{})["identity"], "soul_balance")
+        assert.NotContains(found["wallet"].(map[string]interface{})["identity"], "email")
+    }
+
+    res := httptest.NewRecorder()
+    Object.Get(martini.Params{ "id": "unknown" }, res, newJsonRequest(""), nil, repos)
+    assert.Equal(404, errorStatus(res))
+}
+
+func TestSplitAmount(t *testing.T) {
+    assert := assert.New(t)
+    parts, ok := splitAmount(10, 3, 2)
+    assert.True(ok)
+    assert.Equal([]float64{ 3.33, 3.33, 3.34 }, parts, "remainder goes to the last part")
+    assert.Equal(amountUnits(10, 2), amountUnits(TotalAmount(parts), 2))
+
+    parts, ok = splitAmount(200, 4, 2)
+    assert.True(ok)
+    assert.Equal([]float64{ 50, 50, 50, 50 }, parts)
+
+    _, ok = splitAmount(0.02, 3, 2)
+    assert.False(ok, "every part needs a unit of the currency")
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+    "net/http"
+    "github.com/ownode/services"
+    "github.com/go-martini/martini"
+    "github.com/ownode/metrics"
+)
+
+var Pin PinController
+
+func init() {
+    Pin = PinController{ &Base }
+}
+
+type PinController struct {
+    *BaseController
+}
+
+// check that a pin is well formed (length, digits and luhn check digit).
+// The pin is not looked up so no object data is returned
+func (c *PinController) Validate(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext) {
+
+    if !services.IsValidPin(params["pin"]) {
+        metrics.PinFailures.WithLabelValues(metrics.PinMalformed).Inc()
+        services.Res(res).Json(map[string]interface{}{
+            "valid": false,
+        })
+        return
+    }
+
+    services.Res(res).Json(map[string]interface{}{
+        "valid": true,
+        "formatted": services.FormatPin(params["pin"]),
+    })
+}
@@ -0,0 +1,208 @@
+package controllers
+
+import (
+    "net/http"
+    "github.com/ownode/models"
+    "github.com/ownode/services"
+    "github.com/go-martini/martini"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// maximum number of points of a numbers series
+var MaxSeriesPoints = 1000
+
+// numbers of a wallet per issuer at the end of each interval
+func seriesPoint(start time.Time, ledger *models.WalletLedger, issuers *issuerResolver) (map[string]interface{}, error) {
+    stats := map[string]models.LedgerStats{}
+    issuerByID := map[string]walletIssuer{}
+    for serviceID, s := range ledger.Stats() {
+        issuer, err := issuers.Issuer(serviceID)
+        if err != nil {
+            return nil, err
+        }
+        total := stats[issuer.ID]
+        total.ObjectCount += s.ObjectCount
+        total.ValueObjectCount += s.ValueObjectCount
+        total.ValuelessObjectCount += s.ValuelessObjectCount
+        total.Balance += s.Balance
+        stats[issuer.ID] = total
+        issuerByID[issuer.ID] = issuer
+    }
+
+    ids := []string{}
+    for id := range stats {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+
+    byIssuer := []map[string]interface{}{}
+    for _, id := range ids {
+        byIssuer = append(byIssuer, map[string]interface{}{
+            "issuer": id,
+            "object_name": issuerByID[id].ObjectName,
+            "currency": issuerByID[id].Currency,
+            "object_count": stats[id].ObjectCount,
+            "valuable_object_count": stats[id].ValueObjectCount,
+            "valueless_object_count": stats[id].ValuelessObjectCount,
+            "valueable_object_balance": stats[id].Balance,
+        })
+    }
+
+    return map[string]interface{}{ "time": start, "by_issuer": byIssuer }, nil
+}
+
+// get the numbers of a wallet over time, grouped by issuer.
+// supports `interval` (hour, day (default), week, month), `from` and `to` (unix timestamps).
+// Defaults to the last 30 days. Each point holds the numbers at the end of its interval
+func (c *WalletController) NumbersSeries(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    // TODO: get from access token
+    // authorizing wallet id
+    authWalletID := "55c679145fe09c74ed000001"
+
+    dbCon := db.GetPostgresHandle()
+
+    // get wallet
+    wallet, found, err := models.FindWalletByObjectID(dbCon, params["id"])
+    if !found {
+        services.Res(res).Error(404, "not_found", "wallet not found")
+        return
+    } else if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    // ensure wallet matches authorizing wallet
+    if wallet.ObjectID != authWalletID {
+        services.Res(res).Error(401, "unauthorized", "client does not have permission to access wallet")
+        return
+    }
+
+    query := req.URL.Query()
+    interval := query.Get("interval")
+    if c.validate.IsEmpty(interval) {
+        interval = "day"
+    } else if !services.StringInStringSlice(services.Intervals, interval) {
+        services.Res(res).ErrParam("interval").Error(400, "invalid_parameter", "interval can only be " + strings.Join(services.Intervals, ", "))
+        return
+    }
+
+    to := time.Now().UTC()
+    if qTo := query.Get("to"); !c.validate.IsEmpty(qTo) {
+        ts, err := strconv.ParseInt(qTo, 10, 64)
+        if err != nil {
+            services.Res(res).ErrParam("to").Error(400, "invalid_parameter", "to must be a unix timestamp")
+            return
+        }
+        to = services.UnixToTime(ts).UTC()
+    }
+    from := to.AddDate(0, 0, -30)
+    if qFrom := query.Get("from"); !c.validate.IsEmpty(qFrom) {
+        ts, err := strconv.ParseInt(qFrom, 10, 64)
+        if err != nil {
+            services.Res(res).ErrParam("from").Error(400, "invalid_parameter", "from must be a unix timestamp")
+            return
+        }
+        from = services.UnixToTime(ts).UTC()
+    }
+    if to.Before(from) {
+        services.Res(res).ErrParam("to").Error(400, "invalid_parameter", "to must not be before from")
+        return
+    }
+
+    // determine the intervals
+    start, _ := services.IntervalStart(from, interval)
+    starts := []time.Time{}
+    for t := start; !t.After(to); t = services.NextInterval(t, interval) {
+        if len(starts) == MaxSeriesPoints {
+            services.Res(res).ErrParam("interval").Error(400, "invalid_parameter", fmt.Sprintf("series can have at most %d points. use a larger interval or a shorter period", MaxSeriesPoints))
+            return
+        }
+        starts = append(starts, t)
+    }
+
+    // replay the wallet's events, taking a point at the end of each interval
+    issuers := newIssuerResolver(dbCon)
+    ledger := models.NewWalletLedger(int64(wallet.ID))
+    series := []map[string]interface{}{}
+    next := 0
+    sequence := int64(0)
+
+    takePoints := func(until time.Time) error {
+        for next < len(starts) && !services.NextInterval(starts[next], interval).After(until) {
+            point, err := seriesPoint(starts[next], ledger, issuers)
+            if err != nil {
+                return err
+            }
+            series = append(series, point)
+            next++
+        }
+        return nil
+    }
+
+    // read every page of events from one snapshot, so no event is skipped
+    snapshot, endSnapshot, err := models.BeginEventSnapshot(dbCon)
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+    defer endSnapshot()
+
+    for done := false; !done && next < len(starts); {
+        events, err := models.FindWalletObjectEventsAfter(snapshot, wallet.ID, sequence, StatementPageSize)
+        if err != nil {
+            req.Log.Error(err.Error())
+            services.Res(res).Error(500, "", "server error")
+            return
+        }
+        done = len(events) < StatementPageSize
+
+        for _, event := range events {
+            sequence = event.Sequence
+
+            // close the intervals that ended before this event
+            if err := takePoints(event.CreatedAt); err != nil {
+                req.Log.Error(err.Error())
+                services.Res(res).Error(500, "", "server error")
+                return
+            }
+            if next == len(starts) {
+                break
+            }
+
+            if _, err := ledger.Apply(event); err == models.ErrLedgerTooLarge {
+                services.Res(res).Error(400, "too_many_objects", fmt.Sprintf("numbers can only be replayed for wallets with at most %d objects", models.MaxLedgerObjects))
+                return
+            } else if err != nil {
+                req.Log.Error(err.Error())
+                services.Res(res).Error(500, "", "server error")
+                return
+            }
+        }
+    }
+
+    // remaining intervals hold the current numbers
+    for next < len(starts) {
+        point, err := seriesPoint(starts[next], ledger, issuers)
+        if err != nil {
+            req.Log.Error(err.Error())
+            services.Res(res).Error(500, "", "server error")
+            return
+        }
+        series = append(series, point)
+        next++
+    }
+
+    services.Res(res).Json(map[string]interface{}{
+        "interval": interval,
+        "from": start,
+        "to": to,
+        "series": series,
+    })
+}
@@ -3,7 +3,6 @@ package controllers
 import (
     "net/http"
     "github.com/ownode/models"
-	"gopkg.in/mgo.v2/bson"
     "github.com/ownode/services"
     "github.com/ownode/config"
     "github.com/go-martini/martini"
@@ -35,7 +34,7 @@ type ServiceController struct {
 }
 
 // create a service
-func (c *ServiceController) Create(res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+func (c *ServiceController) Create(res http.ResponseWriter, req services.AuxRequestContext, repos models.Repos) {
 
 	// parse request body
 	var body createBody
@@ -94,28 +93,18 @@ func (c *ServiceController) Create(res http.ResponseWriter, req services.AuxRequ
 
 	// create identity
 	newIdentity := &models.Identity {
-        ObjectID: bson.NewObjectId().Hex(),
+        ObjectID: services.NewObjectID(),
         FullName: body.FullName,
         Email: body.Email,
     }
 
-    // start db transaction
-    dbTx := db.GetPostgresHandle().Begin()
-
-    if err := models.CreateIdentity(dbTx, newIdentity); err != nil {
-    	dbTx.Rollback()
-    	c.log.Error(err.Error())
-		services.Res(res).Error(500, "", "server error")
-		return
-    }
-
 	// create client credentials
 	clientId := services.GetRandString(services.GetRandNumRange(32, 42))
 	clientSecret := services.GetRandString(services.GetRandNumRange(32, 42))
 
 	// create new service object
 	newService := models.Service {
-		ObjectID: bson.NewObjectId().Hex(),
+		ObjectID: services.NewObjectID(),
 		Name: body.ServiceName,
 		Description: body.Description,
 		ClientID: clientId,
@@ -123,32 +112,33 @@ func (c *ServiceController) Create(res http.ResponseWriter, req services.AuxRequ
 		Identity: newIdentity,
 	} 
 
-	// create service
-	err := models.CreateService(dbTx, &newService)
+	// create identity and service in a transaction
+	err := repos.Transaction(func(tx models.Repos) error {
+		if err := tx.Identities().Create(newIdentity); err != nil {
+			return err
+		}
+		return tx.Services().Create(&newService)
+	})
 	if err != nil {
-		dbTx.Rollback()
-		c.log.Error(err.Error())
+		req.Log.Error(err.Error())
 		services.Res(res).Error(500, "", "server error")
 		return
 	}
 
-	// commit db transaction
-	dbTx.Commit()
-
 	// send response
 	respObj, _ := services.StructToJsonToMap(newService)
 	services.Res(res).Json(respObj)
 }
 
 // get a service
-func(c *ServiceController) Get(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+func(c *ServiceController) Get(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, repos models.Repos) {
 	
-	service, found, err := models.FindServiceByObjectID(db.GetPostgresHandle(), params["id"])
+	service, found, err := repos.Services().FindByObjectID(params["id"])
     if !found {
         services.Res(res).Error(404, "not_found", "service was not found")
         return
     } else if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -158,7 +148,7 @@ func(c *ServiceController) Get(params martini.Params, res http.ResponseWriter, r
 }
 
 // enable a service to issuer status
-func(c *ServiceController) EnableIssuer(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+func(c *ServiceController) EnableIssuer(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, repos models.Repos) {
 	
 	// parse request body
 	var body enableIssuerBody
@@ -174,9 +164,9 @@ func(c *ServiceController) EnableIssuer(params martini.Params, res http.Response
 	}
 
 	// ensure service exists
-	service, found, err := models.FindServiceByObjectID(db.GetPostgresHandle(), body.ServiceID)
+	service, found, err := repos.Services().FindByObjectID(body.ServiceID)
 	if err != nil {
-		c.log.Error(err.Error())
+		req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
 	} else if !found {
@@ -191,9 +181,9 @@ func(c *ServiceController) EnableIssuer(params martini.Params, res http.Response
 	}
 
 	// ensure no other service has used the object name
-	identity, found, err := models.FindIdentityByObjectName(db.GetPostgresHandle(), body.ObjectName)
+	identity, found, err := repos.Identities().FindByObjectName(body.ObjectName)
 	if err != nil {
-		c.log.Error(err.Error())
+		req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
 	} else if found && identity.ObjectID != service.Identity.ObjectID {
@@ -208,8 +198,8 @@ func(c *ServiceController) EnableIssuer(params martini.Params, res http.Response
     }
 
     // base currency must be supported
-    if !services.StringInStringSlice(config.SupportedBaseCurrencies, body.BaseCurrency){
-        services.Res(res).Error(400, "invalid_base_currency", "base currency is unknown")
+    if !config.IsActiveCurrency(body.BaseCurrency) {
+        services.Res(res).Error(400, "invalid_base_currency", "base currency is unknown or no longer in use")
         return
     }
 
@@ -217,9 +207,32 @@ func(c *ServiceController) EnableIssuer(params martini.Params, res http.Response
 	service.Identity.Issuer = true
 	service.Identity.ObjectName = strings.ToLower(body.ObjectName)
 	service.Identity.BaseCurrency = body.BaseCurrency
-	db.GetPostgresHandle().Save(&service)
+	if err := repos.Identities().Save(service.Identity); err != nil {
+		req.Log.Error(err.Error())
+		services.Res(res).Error(500, "", "server error")
+		return
+	}
 
 	respObj, _ := services.StructToJsonToMap(service)
 	respObj["identity"].(map[string]interface{})["soul_balance"] = service.Identity.SoulBalance
 	services.Res(res).Json(respObj)
-}	
\ No newline at end of file
+}
+
+var servicesListing = newListing("services")
+
+// list services. A service sees the services of its identity, back office sees all.
+// supports cursor, limit, sort (id, created_at) and filter_gte_date_created, filter_lte_date_created
+func (c *ServiceController) List(res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+	caller, ok := c.ListCaller(req, res, db)
+	if !ok {
+		return
+	}
+
+	dbCon := db.GetPostgresHandle().Model(&models.Service{})
+	if caller != nil {
+		dbCon = dbCon.Where("services.identity_id = ?", caller.IdentityID.Int64)
+	}
+
+	c.WriteList(req, res, servicesListing, dbCon, &[]models.Service{}, "Identity")
+}
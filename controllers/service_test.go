@@ -0,0 +1,51 @@
+package controllers
+
+import (
+    "encoding/json"
+    "net/http/httptest"
+    "testing"
+    "github.com/go-martini/martini"
+    "github.com/ownode/models"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestServiceCreateGetAndEnableIssuer(t *testing.T) {
+    assert := assert.New(t)
+    repos := models.NewMemoryRepos()
+
+    res := httptest.NewRecorder()
+    Service.Create(res, newJsonRequest(`{"full_name":"Gold Shop","service_name":"gold","description":"sells gold"}`), repos)
+    assert.Equal(400, errorStatus(res))
+
+    create := func(name string) map[string]interface{} {
+        res := httptest.NewRecorder()
+        Service.Create(res, newJsonRequest(`{"full_name":"Shop","service_name":"` + name + `","description":"sells things","email":"shop@shop.com"}`), repos)
+        assert.Equal(200, res.Code)
+        var service map[string]interface{}
+        assert.Nil(json.Unmarshal(res.Body.Bytes(), &service))
+        return service
+    }
+    gold, silver := create("gold"), create("silver")
+
+    res = httptest.NewRecorder()
+    Service.Get(martini.Params{ "id": gold["id"].(string) }, res, newJsonRequest(""), repos)
+    assert.Equal(200, res.Code)
+    assert.Contains(res.Body.String(), gold["identity"].(map[string]interface{})["id"].(string))
+
+    res = httptest.NewRecorder()
+    Service.EnableIssuer(nil, res, newJsonRequest(`{"service_id":"` + gold["id"].(string) + `","object_name":"gold","base_currency":"USD"}`), repos)
+    assert.Equal(200, res.Code)
+    identity, found, err := repos.Identities().FindByObjectID(gold["identity"].(map[string]interface{})["id"].(string))
+    assert.Nil(err)
+    assert.True(found)
+    assert.True(identity.Issuer)
+
+    // object names are unique
+    res = httptest.NewRecorder()
+    Service.EnableIssuer(nil, res, newJsonRequest(`{"service_id":"` + silver["id"].(string) + `","object_name":"gold","base_currency":"USD"}`), repos)
+    assert.Equal(400, errorStatus(res))
+
+    res = httptest.NewRecorder()
+    Service.EnableIssuer(nil, res, newJsonRequest(`{"service_id":"unknown","object_name":"silver","base_currency":"USD"}`), repos)
+    assert.Equal(404, errorStatus(res))
+}
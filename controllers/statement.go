@@ -0,0 +1,328 @@
+package controllers
+
+import (
+    "net/http"
+    "github.com/ownode/models"
+    "github.com/ownode/services"
+    "github.com/go-martini/martini"
+    "github.com/jinzhu/gorm"
+    "encoding/csv"
+    "encoding/json"
+    "io"
+    "sort"
+    "strconv"
+    "time"
+)
+
+// number of events read per query when building a statement
+var StatementPageSize = 500
+
+// a line of a statement. opening and closing lines hold the balance of an issuer's
+// objects, movement lines hold a change of balance and the running balance
+type statementRow struct {
+    Type string `json:"type"`
+    Sequence int64 `json:"sequence,omitempty"`
+    Date time.Time `json:"date"`
+    Event string `json:"event,omitempty"`
+    ObjectID string `json:"object_id,omitempty"`
+    Issuer string `json:"issuer"`
+    ObjectName string `json:"object_name"`
+    Currency string `json:"currency"`
+    Amount float64 `json:"amount"`
+    Balance float64 `json:"balance"`
+}
+
+// writes statement rows in a format
+type statementWriter interface {
+    Row(row statementRow) error
+    Close() error
+}
+
+type csvStatementWriter struct {
+    w *csv.Writer
+}
+
+func (s *csvStatementWriter) Row(row statementRow) error {
+    sequence := ""
+    if row.Sequence != 0 {
+        sequence = strconv.FormatInt(row.Sequence, 10)
+    }
+    return s.w.Write([]string{ row.Type, sequence, row.Date.Format(time.RFC3339), row.Event, row.ObjectID, row.Issuer, row.ObjectName, row.Currency,
+        strconv.FormatFloat(row.Amount, 'f', -1, 64), strconv.FormatFloat(row.Balance, 'f', -1, 64) })
+}
+
+func (s *csvStatementWriter) Close() error {
+    s.w.Flush()
+    return s.w.Error()
+}
+
+type ndjsonStatementWriter struct {
+    enc *json.Encoder
+}
+
+func (s *ndjsonStatementWriter) Row(row statementRow) error {
+    return s.enc.Encode(row)
+}
+
+func (s *ndjsonStatementWriter) Close() error {
+    return nil
+}
+
+// writes a json object with the rows in a `rows` array without holding them in memory
+type jsonStatementWriter struct {
+    w io.Writer
+    rows int
+}
+
+func (s *jsonStatementWriter) Row(row statementRow) error {
+    data, err := json.Marshal(row)
+    if err != nil {
+        return err
+    }
+    if s.rows > 0 {
+        if _, err := io.WriteString(s.w, ","); err != nil {
+            return err
+        }
+    }
+    s.rows++
+    _, err = s.w.Write(data)
+    return err
+}
+
+func (s *jsonStatementWriter) Close() error {
+    _, err := io.WriteString(s.w, "]}")
+    return err
+}
+
+// issuer of the objects of a service
+type walletIssuer struct {
+    ID string
+    ObjectName string
+    Currency string
+}
+
+// resolves and caches the issuers of services
+type issuerResolver struct {
+    db *gorm.DB
+    issuers map[int64]walletIssuer
+}
+
+func newIssuerResolver(db *gorm.DB) *issuerResolver {
+    return &issuerResolver{ db, map[int64]walletIssuer{} }
+}
+
+// issuer of the objects of a service
+func (r *issuerResolver) Issuer(serviceID int64) (walletIssuer, error) {
+    if issuer, ok := r.issuers[serviceID]; ok {
+        return issuer, nil
+    }
+    issuer := walletIssuer{}
+    service, found, err := models.FindServiceById(r.db, uint(serviceID))
+    if err != nil {
+        return issuer, err
+    } else if found && service.Identity != nil {
+        issuer = walletIssuer{ service.Identity.ObjectID, service.Identity.ObjectName, service.Identity.BaseCurrency }
+    }
+    r.issuers[serviceID] = issuer
+    return issuer, nil
+}
+
+// export the statement of a wallet. The statement contains the opening balance per issuer at `from`,
+// every movement of the wallet's value objects until `to` and the closing balance per issuer at `to`.
+// `from` and `to` are unix timestamps. `format` is csv, ndjson or json (default).
+// Movements are derived from the events ledger and streamed as they are read
+func (c *WalletController) Statement(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    // TODO: get from access token
+    // authorizing wallet id
+    authWalletID := "55c679145fe09c74ed000001"
+
+    dbCon := db.GetPostgresHandle()
+
+    // get wallet
+    wallet, found, err := models.FindWalletByObjectID(dbCon, params["id"])
+    if !found {
+        services.Res(res).Error(404, "not_found", "wallet not found")
+        return
+    } else if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    // ensure wallet matches authorizing wallet
+    if wallet.ObjectID != authWalletID {
+        services.Res(res).Error(401, "unauthorized", "client does not have permission to access wallet")
+        return
+    }
+
+    query := req.URL.Query()
+    from := time.Unix(0, 0).UTC()
+    to := time.Now().UTC()
+    if qFrom := query.Get("from"); !c.validate.IsEmpty(qFrom) {
+        ts, err := strconv.ParseInt(qFrom, 10, 64)
+        if err != nil {
+            services.Res(res).ErrParam("from").Error(400, "invalid_parameter", "from must be a unix timestamp")
+            return
+        }
+        from = services.UnixToTime(ts).UTC()
+    }
+    if qTo := query.Get("to"); !c.validate.IsEmpty(qTo) {
+        ts, err := strconv.ParseInt(qTo, 10, 64)
+        if err != nil {
+            services.Res(res).ErrParam("to").Error(400, "invalid_parameter", "to must be a unix timestamp")
+            return
+        }
+        to = services.UnixToTime(ts).UTC()
+    }
+    if to.Before(from) {
+        services.Res(res).ErrParam("to").Error(400, "invalid_parameter", "to must not be before from")
+        return
+    }
+
+    format := query.Get("format")
+    if c.validate.IsEmpty(format) {
+        format = "json"
+    }
+
+    var writer statementWriter
+    switch format {
+    case "csv":
+        res.Header().Set("Content-Type", "text/csv")
+        res.Header().Set("Content-Disposition", "attachment; filename=statement-" + wallet.ObjectID + ".csv")
+        csvWriter := csv.NewWriter(res)
+        csvWriter.Write([]string{ "type", "sequence", "date", "event", "object_id", "issuer", "object_name", "currency", "amount", "balance" })
+        writer = &csvStatementWriter{ csvWriter }
+    case "ndjson":
+        res.Header().Set("Content-Type", "application/x-ndjson")
+        writer = &ndjsonStatementWriter{ json.NewEncoder(res) }
+    case "json":
+        res.Header().Set("Content-Type", "application/json")
+        header, _ := json.Marshal(map[string]interface{}{ "wallet": wallet.ObjectID, "from": from, "to": to })
+        io.WriteString(res, string(header[:len(header) - 1]) + `,"rows":[`)
+        writer = &jsonStatementWriter{ w: res }
+    default:
+        services.Res(res).ErrParam("format").Error(400, "invalid_parameter", "format can only be csv, ndjson or json")
+        return
+    }
+
+    issuers := newIssuerResolver(dbCon)
+
+    // write the balance of each issuer, ordered by issuer
+    runningBalances := map[string]float64{}
+    writeBalances := func(rowType string, date time.Time, ledger *models.WalletLedger) error {
+        balances := map[string]float64{}
+        issuerByID := map[string]walletIssuer{}
+        for serviceID, balance := range ledger.Balances() {
+            issuer, err := issuers.Issuer(serviceID)
+            if err != nil {
+                return err
+            }
+            balances[issuer.ID] += balance
+            issuerByID[issuer.ID] = issuer
+        }
+        ids := []string{}
+        for id := range balances {
+            ids = append(ids, id)
+        }
+        sort.Strings(ids)
+        for _, id := range ids {
+            issuer := issuerByID[id]
+            runningBalances[id] = balances[id]
+            if err := writer.Row(statementRow{ Type: rowType, Date: date, Issuer: id, ObjectName: issuer.ObjectName, Currency: issuer.Currency, Balance: balances[id] }); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+
+    flusher, _ := res.(http.Flusher)
+    ledger := models.NewWalletBalanceLedger(int64(wallet.ID))
+    openingWritten := false
+    sequence := int64(0)
+
+    // read every page of events from one snapshot, so no event is skipped
+    snapshot, endSnapshot, err := models.BeginEventSnapshot(dbCon)
+    if err != nil {
+        req.Log.Error(err.Error())
+        return
+    }
+    defer endSnapshot()
+
+    // replay the wallet's events. events before `from` build the opening balance
+    for done := false; !done; {
+        events, err := models.FindWalletObjectEventsAfter(snapshot, wallet.ID, sequence, StatementPageSize)
+        if err != nil {
+            req.Log.Error(err.Error())
+            return
+        }
+        if len(events) < StatementPageSize {
+            done = true
+        }
+
+        for _, event := range events {
+            sequence = event.Sequence
+            if event.CreatedAt.After(to) {
+                done = true
+                break
+            }
+
+            if !openingWritten && !event.CreatedAt.Before(from) {
+                if err := writeBalances("opening", from, ledger); err != nil {
+                    req.Log.Error(err.Error())
+                    return
+                }
+                openingWritten = true
+            }
+
+            movements, err := ledger.Apply(event)
+            if err != nil {
+                req.Log.Error(err.Error())
+                return
+            }
+            if !openingWritten {
+                continue
+            }
+
+            for _, movement := range movements {
+                issuer, err := issuers.Issuer(movement.ServiceID)
+                if err != nil {
+                    req.Log.Error(err.Error())
+                    return
+                }
+                runningBalances[issuer.ID] += movement.Amount
+                row := statementRow{
+                    Type: "movement",
+                    Sequence: movement.Sequence,
+                    Date: movement.CreatedAt,
+                    Event: movement.Event,
+                    ObjectID: movement.ObjectID,
+                    Issuer: issuer.ID,
+                    ObjectName: issuer.ObjectName,
+                    Currency: issuer.Currency,
+                    Amount: movement.Amount,
+                    Balance: runningBalances[issuer.ID],
+                }
+                if err := writer.Row(row); err != nil {
+                    return
+                }
+            }
+        }
+
+        if flusher != nil {
+            flusher.Flush()
+        }
+    }
+
+    if !openingWritten {
+        if err := writeBalances("opening", from, ledger); err != nil {
+            req.Log.Error(err.Error())
+            return
+        }
+    }
+    if err := writeBalances("closing", to, ledger); err != nil {
+        req.Log.Error(err.Error())
+        return
+    }
+    writer.Close()
+}
@@ -3,15 +3,23 @@ package controllers
 import (
     "net/http"
     "github.com/ownode/models"
-	"gopkg.in/mgo.v2/bson"
     "github.com/ownode/services"
+    "github.com/ownode/tracing"
     "github.com/go-martini/martini"
     "time"
-    "strconv"
+    "strings"
+    "fmt"
+    "io"
+    "github.com/jinzhu/gorm"
     validator "github.com/asaskevich/govalidator"
 )
 
-var Wallet WalletController
+var (
+    Wallet WalletController
+
+    // interval between keep alive comments on event streams
+    WalletEventsHeartbeat = 15 * time.Second
+)
 
 type walletCreateBody struct {
 	IdentityId string  `json:"identity_id"`
@@ -20,15 +28,24 @@ type walletCreateBody struct {
 }
 
 func init() {
-    Wallet = WalletController{ &Base }
+    Wallet = WalletController{ BaseController: &Base, closing: make(chan struct{}) }
 }
 
 type WalletController struct {
     *BaseController
+
+    // closed to end the event streams when the server shuts down
+    closing chan struct{}
+}
+
+// end the event streams. Clients reconnect and resume from their last event.
+// Called when the server shuts down, as streams would otherwise keep it waiting
+func (c *WalletController) CloseStreams() {
+    close(c.closing)
 }
 
 // create a wallet
-func (c *WalletController) Create(res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+func (c *WalletController) Create(res http.ResponseWriter, req services.AuxRequestContext, repos models.Repos) {
 
     // parse body
     var body walletCreateBody
@@ -56,23 +73,23 @@ func (c *WalletController) Create(res http.ResponseWriter, req services.AuxReque
     }
 
     // identity id must exist
-    identity, found, err := models.FindIdentityByObjectID(db.GetPostgresHandle(), body.IdentityId)
+    identity, found, err := repos.Identities().FindByObjectID(body.IdentityId)
     if !found {
         services.Res(res).Error(404, "invalid_identity", "identity_id is unknown")
         return
     } else if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
 
     // handle must be unique across wallets
-    _, found, err = models.FindWalletByHandle(db.GetPostgresHandle(), body.Handle)
+    _, found, err = repos.Wallets().FindByHandle(body.Handle)
     if found {
         services.Res(res).Error(400, "handle_registered", "handle has been registered to another wallet")
         return
     } else if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -84,9 +101,9 @@ func (c *WalletController) Create(res http.ResponseWriter, req services.AuxReque
     }
 
     // securely hash password
-    hashedPassword, err := services.Bcrypt(body.Password, 10)
+    hashedPassword, err := services.Bcrypt(req.Request.Context(), body.Password, 10)
     if err != nil {
-        c.log.Error("unable to hash password. reason: " + err.Error())
+        req.Log.Error("unable to hash password. reason: " + err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     } else {
@@ -95,16 +112,16 @@ func (c *WalletController) Create(res http.ResponseWriter, req services.AuxReque
 
     // create wallet object
     newWallet := models.Wallet {
-        ObjectID: bson.NewObjectId().Hex(),
+        ObjectID: services.NewObjectID(),
         Identity: identity,
         Handle: body.Handle,
         Password: body.Password,
     }
 
     // create wallet
-    err = models.CreateWallet(db.GetPostgresHandle(), &newWallet)
+    err = repos.Wallets().Create(&newWallet)
     if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -114,14 +131,14 @@ func (c *WalletController) Create(res http.ResponseWriter, req services.AuxReque
 }
 
 // get a wallet
-func (c *WalletController) Get(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+func (c *WalletController) Get(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, repos models.Repos) {
 
-    wallet, found, err := models.FindWalletByObjectID(db.GetPostgresHandle(), params["id"])
+    wallet, found, err := repos.Wallets().FindByObjectID(params["id"])
     if !found {
         services.Res(res).Error(404, "not_found", "service was not found")
         return
     } else if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -130,12 +147,86 @@ func (c *WalletController) Get(params martini.Params, res http.ResponseWriter, r
     services.Res(res).Json(respObj)
 }
 
-// list object
+// escape a string so it is matched literally in a LIKE pattern
+func likeEscape(str string) string {
+    return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(str)
+}
+
+// listing of objects. shared by object collections
+var objectListing = &services.Listing{
+    Sorts: map[string]string{
+        "id": "objects.id",
+        "balance": "objects.balance",
+        "created_at": "objects.created_at",
+    },
+    SortFields: map[string]string{
+        "balance": "Balance",
+        "created_at": "CreatedAt",
+    },
+    DefaultSort: "id",
+    IDColumn: "objects.id",
+    IDField: "ID",
+    FilterPrefix: "filter_",
+    DefaultLimit: 20,
+    MaxLimit: 100,
+    Filters: []services.ListFilter{
+        services.EnumFilter("filter_type", "objects.type", []string{ models.ObjectValue, models.ObjectValueless }),
+        services.BoolFilter("filter_open", "objects.open"),
+        services.EnumFilter("filter_open_method", "objects.open_method", []string{ models.ObjectOpenDefault, models.ObjectOpenTimed, models.ObjectOpenPin }),
+        services.TimeFilter("filter_gte_date_created", "objects.created_at", ">="),
+        services.TimeFilter("filter_lte_date_created", "objects.created_at", "<="),
+        services.NumberFilter("filter_gte_balance", "objects.balance", ">="),
+        services.NumberFilter("filter_lte_balance", "objects.balance", "<="),
+        services.ListFilter{ Param: "filter_service", Apply: func(db *gorm.DB, value string) (*gorm.DB, error) {
+            service, found, err := models.FindServiceByObjectID(db.New(), value)
+            if err != nil {
+                return db, err
+            } else if !found {
+                return db, &services.ListError{ Param: "filter_service", Message: "filter_service: service not found" }
+            }
+            return db.Where("objects.service_id = ?", service.ID), nil
+        }},
+        services.ListFilter{ Param: "filter_object_name", Apply: func(db *gorm.DB, value string) (*gorm.DB, error) {
+            return db.Where("objects.service_id IN (SELECT services.id FROM services JOIN identities ON identities.id = services.identity_id WHERE identities.object_name = ?)", value), nil
+        }},
+        services.ListFilter{ Param: "filter_meta_search", Apply: func(db *gorm.DB, value string) (*gorm.DB, error) {
+            // sqlite's LIKE ignores case and needs the escape character set
+            if models.IsSQLite(db) {
+                return db.Where("objects.meta LIKE ? ESCAPE '\\'", "%" + likeEscape(value) + "%"), nil
+            }
+            return db.Where("objects.meta::text ILIKE ?", "%" + likeEscape(value) + "%"), nil
+        }},
+    },
+}
+
+var walletsListing = newListing("wallets", services.BoolFilter("filter_lock", "wallets.lock"))
+
+// list wallets. A service sees the wallets of its identity, back office sees all.
+// supports cursor, limit, sort (id, created_at) and filter_lock, filter_gte_date_created,
+// filter_lte_date_created
+func (c *WalletController) ListWallets(res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    caller, ok := c.ListCaller(req, res, db)
+    if !ok {
+        return
+    }
+
+    dbCon := db.GetPostgresHandle().Model(&models.Wallet{})
+    if caller != nil {
+        dbCon = dbCon.Where("wallets.identity_id = ?", caller.IdentityID.Int64)
+    }
+
+    c.WriteList(req, res, walletsListing, dbCon, &[]models.Wallet{}, "Identity")
+}
+
+// list objects of a wallet
 // supports
-// - pagination using 'page' query. Use per_page to set the number of results per page. max is 100
-// - filters: filter_type, filter_service, filter_open, filter_open_method, filter_gte_date_created
-//   filter_lte_date_created
-// - sorting: sort_balance, sort_date_created
+// - cursor pagination using 'cursor' query set to the next_cursor of the previous page. Use limit to
+//   set the number of results per page. max is 100
+// - filters: filter_type, filter_service, filter_open, filter_open_method, filter_gte_date_created,
+//   filter_lte_date_created, filter_gte_balance, filter_lte_balance, filter_object_name (issuer object name),
+//   filter_meta_search (text search of meta), meta.<field> (e.g meta.order_id=123)
+// - sorting: sort=<field> or sort=-<field> for descending order. fields: id, balance, created_at
 func (c *WalletController) List(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
     
     // TODO: get from access token
@@ -150,7 +241,7 @@ func (c *WalletController) List(params martini.Params, res http.ResponseWriter,
         services.Res(res).Error(404, "not_found", "wallet not found")
         return
     } else if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -162,154 +253,73 @@ func (c *WalletController) List(params martini.Params, res http.ResponseWriter,
     }
 
     query := req.URL.Query()
-    qPage := query.Get("page")
-    if c.validate.IsEmpty(qPage) {
-        qPage = "0"
-    } else if !validator.IsNumeric(qPage) {
-        services.Res(res).Error(400, "invalid_parameter", "page query value must be numeric")
-        return 
-    } 
-
-    q := make(map[string]interface{})
-    q["wallet_id"] = wallet.ID
-    order := "id asc"
-    limitPerPage := int64(2)
-    offset := int64(0)
-    currentPage, err := strconv.ParseInt(qPage, 0, 64)
-    if err != nil {
-        c.log.Error(err.Error())
-        services.Res(res).Error(500, "", "server error")
-        return
-    }
-
-    // set limit per page if provided in query
-    qPerPage := query.Get("per_page")
-    if !c.validate.IsEmpty(qPerPage) {
-        if validator.IsNumeric(qPerPage) {
-            qPerPage, _ := strconv.ParseInt(qPerPage, 0, 64)
-            if qPerPage > 100 {
-                qPerPage = 100
-            } else if qPerPage <= 0 {
-                qPerPage = limitPerPage
-            }
-            limitPerPage = qPerPage
+    dbCon = dbCon.Model(models.Object{}).Where("objects.wallet_id = ?", wallet.ID)
+
+    // apply meta filters included in query. e.g meta.order_id=123 or meta.event.seat=A1.
+    // Values are compared as text. sqlite's json_extract returns booleans as numbers
+    sqlite := models.IsSQLite(dbCon)
+    for key, values := range query {
+        if !services.StringStartsWith(key, "meta.") {
+            continue
         }
-    }
-
-    // set current page default and calculate offset
-    if currentPage <= 1 {
-        currentPage = 1
-        offset = 0;
-    } else {
-        offset = (int64(limitPerPage) * currentPage) - int64(limitPerPage)
-    }
-
-
-    // apply type filter if included in query
-    filterType := query.Get("filter_type")
-    if !c.validate.IsEmpty(filterType) && services.StringInStringSlice([]string{"obj_value","obj_valueless"}, filterType) {
-        q["type"] = filterType
-    }
-
-    // apply service filter if included in query
-    filterService := query.Get("filter_service")
-    if !c.validate.IsEmpty(filterService) {
-
-        // find service
-        service, found, err := models.FindServiceByObjectID(db.GetPostgresHandle(), filterService)
-        if err != nil {
-            c.log.Error(err.Error())
-            services.Res(res).Error(500, "", "server error")
-            return
+        field := strings.TrimPrefix(key, "meta.")
+        path, ok := services.MetaFieldToJSONPath(field)
+        if sqlite {
+            path, ok = services.MetaFieldToSQLitePath(field)
         }
-
-        if found {
-            q["service_id"] = service.ID
-        }
-    }
-
-    // apply open filter if included in query
-    filterOpen := query.Get("filter_open")
-    if !c.validate.IsEmpty(filterOpen) && services.StringInStringSlice([]string{"true","false"}, filterOpen) {
-        q["open"] = filterOpen
-    }
-
-    // apply open_method filter if included in query
-    filterOpenMethod := query.Get("filter_open_method")
-    if !c.validate.IsEmpty(filterOpenMethod) && services.StringInStringSlice([]string{"open","open_timed","open_pin"}, filterOpenMethod) {
-        q["open_method"] = filterOpenMethod
-    }
-
-    // apply filter_gte_date_created filter if included in query
-    filterGTEDateCreated := query.Get("filter_gte_date_created")
-    if !c.validate.IsEmpty(filterGTEDateCreated) {
-        if validator.IsNumeric(filterGTEDateCreated) {
-            ts, _ := strconv.ParseInt(filterGTEDateCreated, 0, 64)
-            dbCon = dbCon.Where("created_at >= ?", services.UnixToTime(ts).UTC().Format(time.RFC3339Nano))
+        if !ok {
+            services.Res(res).ErrParam(key).Error(400, "invalid_parameter", "meta filter field can only contain letters, numbers, underscores and dots")
+            return
         }
-    }
-
-    // apply filter_lte_date_created filter if included in query
-    filterLTEDateCreated := query.Get("filter_lte_date_created")
-    if !c.validate.IsEmpty(filterLTEDateCreated) {
-        if validator.IsNumeric(filterLTEDateCreated) {
-            ts, _ := strconv.ParseInt(filterLTEDateCreated, 0, 64)
-            dbCon = dbCon.Where("created_at <= ?", services.UnixToTime(ts).UTC().Format(time.RFC3339Nano))
+        if sqlite {
+            dbCon = dbCon.Where("(CASE json_type(objects.meta, ?) WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ELSE CAST(json_extract(objects.meta, ?) AS TEXT) END) = ?", path, path, values[0])
+        } else {
+            dbCon = dbCon.Where("objects.meta #>> ? = ?", path, values[0])
         }
     }
 
-    // the below connection is used for sorting/ordering 
-    var dbConSort = dbCon
-
-    // apply sort_balance sort if included
-    sortBalance := query.Get("sort_balance")
-    if !c.validate.IsEmpty(sortBalance) {
-        orderVal := "asc"
-        if (sortBalance == "-1") {
-            orderVal = "desc"
-        } 
-        dbConSort = dbCon.Order("objects.balance " + orderVal)
+    listQuery, err := objectListing.Parse(dbCon, query)
+    if listErr, ok := err.(*services.ListError); ok {
+        services.Res(res).ErrParam(listErr.Param).Error(400, "invalid_parameter", listErr.Message)
+        return
+    } else if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
     }
 
-    // apply ort_date_created sort if included
-    sortDateCreated := query.Get("sort_date_created")
-    if !c.validate.IsEmpty(sortDateCreated) {
-        orderVal := "asc"
-        if (sortDateCreated == "-1") {
-            orderVal = "desc"
-        } 
-        dbConSort = dbConSort.Order("objects.created_at " + orderVal)
+    // count all objects matching the filters
+    var objectsCount int64
+    if err := listQuery.DB.Count(&objectsCount).Error; err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
     }
 
-    // find objects associated with wallet
     objects := []models.Object{}
-    var objectsCount int64
-    
-    // count number of objects. I didnt use dbConSort as count will throw an error
-    dbCon.Model(models.Object{}).Where(q).Count(&objectsCount)
-    
-    // set the original db connection to the sort connection
-    dbCon = dbConSort       
-
-    // calculate number of pages
-    numPages := services.Round(float64(objectsCount) / float64(limitPerPage))
-
-    // fetch the objects
-    dbCon.Where(q).Preload("Service.Identity").Preload("Wallet.Identity").Limit(limitPerPage).Offset(offset).Order(order).Find(&objects)
+    nextCursor, err := listQuery.Find(listQuery.Page().Preload("Service.Identity").Preload("Wallet.Identity"), &objects)
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
     
-    // prepare response
+    // prepare response. pins are masked in lists
     respObj, _ := services.StructToJsonToSlice(objects)
     if len(respObj) == 0 {
         respObj = []map[string]interface{}{}
     }
+    for i, object := range objects {
+        respObj[i]["pin"] = services.MaskPin(object.Pin)
+    }
 
     services.Res(res).Json(map[string]interface{}{
         "results": respObj,
         "_metadata": map[string]interface{}{
             "total_count": objectsCount,
-            "per_page": limitPerPage,
-            "page_count": numPages,
-            "page": currentPage,
+            "limit": listQuery.Limit,
+            "has_more": nextCursor != "",
+            "next_cursor": nextCursor,
         }, 
     })
 }
@@ -328,11 +338,11 @@ func (c *WalletController) Numbers(params martini.Params, res http.ResponseWrite
     count := int64(0)
 
     // predefine default query values
-    query.Set("object_count", "true")
-    query.Set("distinct_object_count", "true")
-    query.Set("valuable_object_count", "true")
-    query.Set("valueless_object_count", "true")
-    query.Set("valueable_object_balance", "true")
+    for _, field := range []string{ "object_count", "distinct_object_count", "valuable_object_count", "valueless_object_count", "valueable_object_balance", "by_issuer" } {
+        if c.validate.IsEmpty(query.Get(field)) {
+            query.Set(field, "true")
+        }
+    }
 
     // get wallet
     wallet, found, err := models.FindWalletByObjectID(dbCon, params["id"])
@@ -340,7 +350,7 @@ func (c *WalletController) Numbers(params martini.Params, res http.ResponseWrite
         services.Res(res).Error(404, "not_found", "wallet not found")
         return
     } else if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -368,15 +378,12 @@ func (c *WalletController) Numbers(params martini.Params, res http.ResponseWrite
     distinctObjectCountField := query.Get("distinct_object_count")
     if !c.validate.IsEmpty(distinctObjectCountField) && services.StringInStringSlice([]string{"true","false"}, distinctObjectCountField) {
         if distinctObjectCountField == "true" {
-            row, err := dbCon.Raw("SELECT COUNT(*) FROM (SELECT DISTINCT service_id FROM objects WHERE wallet_id = ?) AS distinct_object_count;", wallet.ID).Rows()
+            err := models.ScanRow(dbCon, []interface{}{ &count }, "SELECT COUNT(*) FROM (SELECT DISTINCT service_id FROM objects WHERE wallet_id = ?) AS distinct_object_count;", wallet.ID)
             if err != nil {
-                c.log.Error(err.Error())
+                req.Log.Error(err.Error())
                 services.Res(res).Error(500, "", "server error")
                 return
             }
-            if row.Next() {
-                row.Scan(&count)
-            }
             resp["distinct_object_count"] = count
             count = 0
         }
@@ -384,7 +391,7 @@ func (c *WalletController) Numbers(params martini.Params, res http.ResponseWrite
 
     // valuable_object_count
     valuableObjectCountField := query.Get("valuable_object_count")
-    if !c.validate.IsEmpty(distinctObjectCountField) && services.StringInStringSlice([]string{"true","false"}, valuableObjectCountField) {
+    if !c.validate.IsEmpty(valuableObjectCountField) && services.StringInStringSlice([]string{"true","false"}, valuableObjectCountField) {
         if valuableObjectCountField == "true" {
             q := map[string]interface{}{
                 "wallet_id": wallet.ID,
@@ -412,19 +419,16 @@ func (c *WalletController) Numbers(params martini.Params, res http.ResponseWrite
 
     // valueable_object_balance
     valuableObjectBalanceField := query.Get("valueable_object_balance")
-    if !c.validate.IsEmpty(distinctObjectCountField) && services.StringInStringSlice([]string{"true","false"}, valuableObjectBalanceField) {
+    if !c.validate.IsEmpty(valuableObjectBalanceField) && services.StringInStringSlice([]string{"true","false"}, valuableObjectBalanceField) {
         if valuableObjectBalanceField == "true" {
-            row, err := dbCon.Raw("SELECT SUM(balance) AS total_balance FROM objects WHERE wallet_id = ? AND type = ?;", wallet.ID, models.ObjectValue).Rows()
+            balance := 0.0
+            err := models.ScanRow(dbCon, []interface{}{ &balance }, "SELECT COALESCE(SUM(balance), 0) AS total_balance FROM objects WHERE wallet_id = ? AND type = ?;", wallet.ID, models.ObjectValue)
             if err != nil {
-                c.log.Error(err.Error())
+                req.Log.Error(err.Error())
                 services.Res(res).Error(500, "", "server error")
                 return
             }
-            if row.Next() {
-                row.Scan(&count)
-            }
-            resp["valueable_object_balance"] = count
-            count = 0
+            resp["valueable_object_balance"] = balance
         }
     }
 
@@ -458,7 +462,7 @@ func (c *WalletController) Numbers(params martini.Params, res http.ResponseWrite
 
     // opened_timed_object_count
     openedTimedObjectCountField := query.Get("opened_timed_object_count")
-    if !c.validate.IsEmpty(openedObjectCountField) && services.StringInStringSlice([]string{"true","false"}, openedTimedObjectCountField) {
+    if !c.validate.IsEmpty(openedTimedObjectCountField) && services.StringInStringSlice([]string{"true","false"}, openedTimedObjectCountField) {
         if openedTimedObjectCountField == "true" {
             q := map[string]interface{}{
                 "wallet_id": wallet.ID,
@@ -486,9 +490,68 @@ func (c *WalletController) Numbers(params martini.Params, res http.ResponseWrite
         }
     }
 
+    // by_issuer: the numbers above per issuer
+    byIssuerField := query.Get("by_issuer")
+    if byIssuerField == "true" {
+        byIssuer, err := walletNumbersByIssuer(dbCon, wallet.ID)
+        if err != nil {
+            req.Log.Error(err.Error())
+            services.Res(res).Error(500, "", "server error")
+            return
+        }
+        resp["by_issuer"] = byIssuer
+    }
+
     services.Res(res).Json(resp)
 }
 
+// numbers of a wallet's objects per issuer, computed in a single query
+func walletNumbersByIssuer(db *gorm.DB, walletID uint) (result []map[string]interface{}, err error) {
+    stmt := `SELECT identities.object_id, identities.object_name, identities.base_currency,
+        COUNT(*),
+        COUNT(*) FILTER (WHERE objects.type = ?),
+        COUNT(*) FILTER (WHERE objects.type = ?),
+        COALESCE(SUM(objects.balance) FILTER (WHERE objects.type = ?), 0),
+        COUNT(*) FILTER (WHERE objects.open),
+        COUNT(*) FILTER (WHERE NOT objects.open)
+        FROM objects
+        JOIN services ON services.id = objects.service_id
+        JOIN identities ON identities.id = services.identity_id
+        WHERE objects.wallet_id = ?
+        GROUP BY identities.object_id, identities.object_name, identities.base_currency
+        ORDER BY identities.object_id`
+    span := tracing.StartRawSpan(db, stmt)
+    defer func() { tracing.EndSpan(span, err) }()
+
+    rows, err := db.Raw(stmt, models.ObjectValue, models.ObjectValueless, models.ObjectValue, walletID).Rows()
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    result = []map[string]interface{}{}
+    for rows.Next() {
+        var issuer, objectName, currency string
+        var objectCount, valuableCount, valuelessCount, openedCount, lockedCount int64
+        var balance float64
+        if err := rows.Scan(&issuer, &objectName, &currency, &objectCount, &valuableCount, &valuelessCount, &balance, &openedCount, &lockedCount); err != nil {
+            return nil, err
+        }
+        result = append(result, map[string]interface{}{
+            "issuer": issuer,
+            "object_name": objectName,
+            "currency": currency,
+            "object_count": objectCount,
+            "valuable_object_count": valuableCount,
+            "valueless_object_count": valuelessCount,
+            "valueable_object_balance": balance,
+            "opened_object_count": openedCount,
+            "locked_object_count": lockedCount,
+        })
+    }
+    return result, rows.Err()
+}
+
 // lock a wallet. A lock on a wallet prevents charges on opened objects
 func (c *WalletController) Lock(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
     
@@ -498,7 +561,7 @@ func (c *WalletController) Lock(params martini.Params, res http.ResponseWriter,
 
     dbTx, err := db.GetPostgresHandleWithRepeatableReadTrans()
     if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -511,7 +574,7 @@ func (c *WalletController) Lock(params martini.Params, res http.ResponseWriter,
         return
     } else if err != nil {
         dbTx.Rollback()
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -527,7 +590,25 @@ func (c *WalletController) Lock(params martini.Params, res http.ResponseWriter,
     wallet.Lock = true
 
     // save and commit
-    dbTx.Save(&wallet).Commit()
+    dbTx.Save(&wallet)
+
+    // record domain event
+    if err := models.AppendWalletEvent(dbTx, services.NewObjectID(), models.EventWalletLocked, wallet); err != nil {
+        dbTx.Rollback()
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    // queue event for webhook delivery
+    if err := queueEvent(dbTx, EventWalletLocked, map[string]interface{}{ "wallet": walletEventData(wallet) }, nil, wallet); err != nil {
+        dbTx.Rollback()
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    dbTx.Commit()
     services.Res(res).Json(wallet)
 }
 
@@ -540,7 +621,7 @@ func (c *WalletController) Open(params martini.Params, res http.ResponseWriter,
 
     dbTx, err := db.GetPostgresHandleWithRepeatableReadTrans()
     if err != nil {
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -553,7 +634,7 @@ func (c *WalletController) Open(params martini.Params, res http.ResponseWriter,
         return
     } else if err != nil {
         dbTx.Rollback()
-        c.log.Error(err.Error())
+        req.Log.Error(err.Error())
         services.Res(res).Error(500, "", "server error")
         return
     }
@@ -569,7 +650,175 @@ func (c *WalletController) Open(params martini.Params, res http.ResponseWriter,
     wallet.Lock = false
 
     // save and commit
-    dbTx.Save(&wallet).Commit()
+    dbTx.Save(&wallet)
+
+    // record domain event
+    if err := models.AppendWalletEvent(dbTx, services.NewObjectID(), models.EventWalletOpened, wallet); err != nil {
+        dbTx.Rollback()
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    // queue event for webhook delivery
+    if err := queueEvent(dbTx, EventWalletOpened, map[string]interface{}{ "wallet": walletEventData(wallet) }, nil, wallet); err != nil {
+        dbTx.Rollback()
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    dbTx.Commit()
     services.Res(res).Json(wallet)
 }
-    
\ No newline at end of file
+    
+// write a wallet event as a server-sent event
+func writeWalletEvent(w io.Writer, event models.WalletEvent) error {
+    _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Cursor(), event.Event, string(event.Data))
+    return err
+}
+
+// stream the activity of a wallet as server-sent events.
+// Requires a token scoped to the wallet passed as a bearer token or in the
+// `access_token` query (for clients that cannot set headers). Events missed
+// while disconnected are replayed from the ledger using `Last-Event-ID`
+func (c *WalletController) Events(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    // get token from authorization header or query
+    tokenString := req.URL.Query().Get("access_token")
+    if authorization := req.Header.Get("Authorization"); services.StringStartsWith(strings.ToLower(authorization), "bearer ") {
+        tokenString = strings.TrimSpace(authorization[len("bearer "):])
+    }
+    if c.validate.IsEmpty(tokenString) {
+        services.Res(res).Error(401, "unauthorized", "missing access token")
+        return
+    }
+
+    // ensure token is scoped to the wallet
+    authWalletID, err := parseWalletJWTToken(c.settings.Auth.SigningKey, tokenString)
+    if err != nil {
+        services.Res(res).Error(401, "unauthorized", "access token is invalid or has expired")
+        return
+    } else if authWalletID != params["id"] {
+        services.Res(res).Error(401, "unauthorized", "client does not have permission to access wallet")
+        return
+    }
+
+    wallet, found, err := models.FindWalletByObjectID(db.GetPostgresHandle(), params["id"])
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    } else if !found {
+        services.Res(res).Error(404, "not_found", "wallet not found")
+        return
+    }
+
+    // get the cursor of the last event the client received. A bare event id
+    // is accepted from clients that connected before events had cursors
+    cursor := models.WalletEventCursor{}
+    lastEventID := req.Header.Get("Last-Event-ID")
+    if c.validate.IsEmpty(lastEventID) {
+        lastEventID = req.URL.Query().Get("last_event_id")
+    }
+    if !c.validate.IsEmpty(lastEventID) {
+        var complete bool
+        cursor, complete, err = models.ParseWalletEventCursor(lastEventID)
+        if err != nil {
+            services.Res(res).ErrParam("last_event_id").Error(400, "invalid_parameter", "last event id is invalid")
+            return
+        }
+        if !complete {
+            if cursor, err = models.FindWalletEventCursor(db.GetPostgresHandle(), wallet.ID, cursor.ID); err != nil {
+                req.Log.Error(err.Error())
+                services.Res(res).Error(500, "", "server error")
+                return
+            }
+        }
+    }
+
+    flusher, ok := res.(http.Flusher)
+    if !ok {
+        services.Res(res).Error(500, "", "streaming is not supported")
+        return
+    }
+
+    res.Header().Set("Content-Type", "text/event-stream")
+    res.Header().Set("Cache-Control", "no-cache")
+    res.Header().Set("Connection", "keep-alive")
+    res.Header().Set("X-Accel-Buffering", "no")
+    res.WriteHeader(200)
+    fmt.Fprint(res, "retry: 3000\n\n")
+    flusher.Flush()
+
+    heartbeat := time.NewTicker(WalletEventsHeartbeat)
+    defer heartbeat.Stop()
+    done := req.Request.Context().Done()
+    topic := models.WalletEventTopic(wallet.ID)
+
+    // send the events after the cursor. Returns false if the stream failed
+    catchUp := func() bool {
+        for {
+            events, err := models.FindWalletEventsAfter(db.GetPostgresHandle(), wallet.ID, cursor, 100)
+            if err != nil {
+                req.Log.Error(err.Error())
+                return false
+            }
+            for _, event := range events {
+                if err := writeWalletEvent(res, event); err != nil {
+                    return false
+                }
+                cursor = event.Cursor()
+            }
+            flusher.Flush()
+            if len(events) < 100 {
+                return true
+            }
+        }
+    }
+
+    // subscribe before catching up so no event is missed between the two.
+    // Announcements only wake the stream, events are always read from the
+    // ledger in cursor order. The ledger is also read on every heartbeat
+    // for events held back by a transaction that was running.
+    // A subscription is closed if the client falls behind, in which case
+    // we subscribe again
+    for {
+        sub, unsubscribe := services.Events.Subscribe(topic)
+        if !catchUp() {
+            unsubscribe()
+            return
+        }
+
+        resubscribe := false
+        for !resubscribe {
+            select {
+            case <-done:
+                unsubscribe()
+                return
+            case <-c.closing:
+                unsubscribe()
+                return
+            case <-heartbeat.C:
+                if _, err := fmt.Fprint(res, ": ping\n\n"); err != nil {
+                    unsubscribe()
+                    return
+                }
+                if !catchUp() {
+                    unsubscribe()
+                    return
+                }
+            case _, ok := <-sub:
+                if !ok {
+                    resubscribe = true
+                    continue
+                }
+                if !catchUp() {
+                    unsubscribe()
+                    return
+                }
+            }
+        }
+        unsubscribe()
+    }
+}
@@ -0,0 +1,57 @@
+package controllers
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "github.com/go-martini/martini"
+    "github.com/ownode/config"
+    "github.com/ownode/models"
+    "github.com/ownode/services"
+    "github.com/stretchr/testify/assert"
+)
+
+func newJsonRequest(body string) services.AuxRequestContext {
+    req, _ := http.NewRequest("POST", "/", strings.NewReader(body))
+    req.Header.Set("Content-Type", "application/json")
+    arc := services.NewAuxRequestContext(nil, req)
+    arc.Log = config.Log()
+    return arc
+}
+
+// status of the api error in a response
+func errorStatus(res *httptest.ResponseRecorder) int {
+    var apiError services.APIError
+    json.Unmarshal(res.Body.Bytes(), &apiError)
+    return apiError.Error.Status
+}
+
+func TestWalletCreateAndGet(t *testing.T) {
+    assert := assert.New(t)
+    repos := models.NewMemoryRepos()
+    identity := models.Identity{ ObjectID: services.NewObjectID(), FullName: "john doe", Email: "john@doe.com" }
+    assert.Nil(repos.Identities().Create(&identity))
+
+    // unknown identity
+    res := httptest.NewRecorder()
+    Wallet.Create(res, newJsonRequest(`{"identity_id":"unknown","handle":"john","password":"secret1"}`), repos)
+    assert.Equal(404, errorStatus(res))
+
+    res = httptest.NewRecorder()
+    Wallet.Create(res, newJsonRequest(`{"identity_id":"` + identity.ObjectID + `","handle":"john","password":"secret1"}`), repos)
+    assert.Equal(200, res.Code)
+    var created map[string]interface{}
+    assert.Nil(json.Unmarshal(res.Body.Bytes(), &created))
+
+    // handle already used
+    res = httptest.NewRecorder()
+    Wallet.Create(res, newJsonRequest(`{"identity_id":"` + identity.ObjectID + `","handle":"john","password":"secret2"}`), repos)
+    assert.Equal(400, errorStatus(res))
+
+    res = httptest.NewRecorder()
+    Wallet.Get(martini.Params{ "id": created["id"].(string) }, res, newJsonRequest(""), repos)
+    assert.Equal(200, res.Code)
+    assert.Contains(res.Body.String(), identity.ObjectID)
+}
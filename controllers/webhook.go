@@ -0,0 +1,254 @@
+package controllers
+
+import (
+    "net/http"
+    "github.com/ownode/models"
+    "github.com/ownode/services"
+    "github.com/go-martini/martini"
+    validator "github.com/asaskevich/govalidator"
+    "database/sql"
+    "fmt"
+    "net/url"
+    "strings"
+    "strconv"
+    "time"
+)
+
+var Webhook WebhookController
+
+type webhookCreateBody struct {
+    URL string `json:"url"`
+    Events []string `json:"events"`
+}
+
+func init() {
+    Webhook = WebhookController{ &Base }
+}
+
+type WebhookController struct {
+    *BaseController
+}
+
+// response representation of an endpoint
+func webhookEndpointResp(endpoint models.WebhookEndpoint) map[string]interface{} {
+    respObj, _ := services.StructToJsonToMap(endpoint)
+    respObj["events"] = endpoint.EventList()
+    return respObj
+}
+
+// find a webhook endpoint and ensure it belongs to the authorizing service.
+// writes an error response and returns false if not
+func (c *WebhookController) authorizedEndpoint(id string, service models.Service, req services.AuxRequestContext, res http.ResponseWriter, db *services.DB) (models.WebhookEndpoint, bool) {
+    endpoint, found, err := models.FindWebhookEndpointByObjectID(db.GetPostgresHandle(), id)
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return endpoint, false
+    } else if !found || endpoint.ServiceID.Int64 != int64(service.ID) {
+        services.Res(res).Error(404, "not_found", "webhook endpoint was not found")
+        return endpoint, false
+    }
+    return endpoint, true
+}
+
+// register a webhook endpoint for the authorizing service.
+// The endpoint receives the events listed in `events` or all events if not provided.
+// The secret used to sign payloads is only returned on creation
+func (c *WebhookController) Create(res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    // TODO: get client id from access token
+    clientID := "kl14zFDq4SHlmmmVNHgLtE0LqCo8BTjyShOH"
+
+    var body webhookCreateBody
+    if err := c.ParseJsonBody(req, &body); err != nil {
+        services.Res(res).Error(400, "invalid_body", "request body is invalid or malformed. Expects valid json body")
+        return
+    }
+
+    // url is required
+    if validator.IsNull(body.URL) {
+        services.Res(res).Error(400, "missing_parameter", "Missing required field: url")
+        return
+    }
+
+    // url must be a valid http(s) url
+    lowerURL := strings.ToLower(body.URL)
+    if !validator.IsURL(body.URL) || (!services.StringStartsWith(lowerURL, "http://") && !services.StringStartsWith(lowerURL, "https://")) {
+        services.Res(res).ErrParam("url").Error(400, "invalid_parameter", "url must be a valid http or https url")
+        return
+    }
+    if len(body.URL) > services.MaxWebhookURLLength {
+        services.Res(res).ErrParam("url").Error(400, "invalid_parameter", fmt.Sprintf("url must not be longer than %d characters", services.MaxWebhookURLLength))
+        return
+    }
+
+    // url must not point at loopback or private addresses
+    endpointURL, err := url.Parse(body.URL)
+    if err != nil || services.CheckWebhookHost(endpointURL.Hostname()) != nil {
+        services.Res(res).ErrParam("url").Error(400, "invalid_parameter", "url must not point to a loopback or private address")
+        return
+    }
+
+    // events must be known
+    for _, event := range body.Events {
+        if !services.StringInStringSlice(AllEvents, event) {
+            services.Res(res).ErrParam("events").Error(400, "invalid_parameter", "events: unknown event " + event)
+            return
+        }
+    }
+
+    service, found, err := models.FindServiceByClientId(db.GetPostgresHandle(), clientID)
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    } else if !found {
+        services.Res(res).Error(401, "unauthorized", "service not found")
+        return
+    }
+
+    endpoint := models.WebhookEndpoint{
+        ObjectID: services.NewObjectID(),
+        ServiceID: sql.NullInt64{ Int64: int64(service.ID), Valid: true },
+        URL: body.URL,
+        Secret: "whsec_" + services.GetRandString(32),
+        Events: strings.Join(body.Events, ","),
+        Active: true,
+    }
+
+    if err := models.CreateWebhookEndpoint(db.GetPostgresHandle(), &endpoint); err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    respObj := webhookEndpointResp(endpoint)
+    respObj["secret"] = endpoint.Secret
+    services.Res(res).Json(respObj)
+}
+
+// list the webhook endpoints of the authorizing service
+func (c *WebhookController) List(res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    // TODO: get client id from access token
+    clientID := "kl14zFDq4SHlmmmVNHgLtE0LqCo8BTjyShOH"
+
+    service, _, _ := models.FindServiceByClientId(db.GetPostgresHandle(), clientID)
+    endpoints, err := models.FindWebhookEndpointsByServiceID(db.GetPostgresHandle(), service.ID)
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    respObj := []map[string]interface{}{}
+    for _, endpoint := range endpoints {
+        respObj = append(respObj, webhookEndpointResp(endpoint))
+    }
+    services.Res(res).Json(respObj)
+}
+
+// remove a webhook endpoint. pending deliveries of the endpoint are dead lettered
+func (c *WebhookController) Delete(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    // TODO: get client id from access token
+    clientID := "kl14zFDq4SHlmmmVNHgLtE0LqCo8BTjyShOH"
+
+    service, _, _ := models.FindServiceByClientId(db.GetPostgresHandle(), clientID)
+    endpoint, ok := c.authorizedEndpoint(params["id"], service, req, res, db)
+    if !ok {
+        return
+    }
+
+    endpoint.Active = false
+    if err := db.GetPostgresHandle().Save(&endpoint).Error; err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    services.Res(res).Json(webhookEndpointResp(endpoint))
+}
+
+// list the deliveries of a webhook endpoint, most recent first.
+// supports `status` (pending, delivered, dead) and `limit` (max 100) queries
+func (c *WebhookController) Deliveries(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    // TODO: get client id from access token
+    clientID := "kl14zFDq4SHlmmmVNHgLtE0LqCo8BTjyShOH"
+
+    service, _, _ := models.FindServiceByClientId(db.GetPostgresHandle(), clientID)
+    endpoint, ok := c.authorizedEndpoint(params["id"], service, req, res, db)
+    if !ok {
+        return
+    }
+
+    query := req.URL.Query()
+    status := query.Get("status")
+    if !c.validate.IsEmpty(status) && !services.StringInStringSlice([]string{ models.DeliveryPending, models.DeliveryDelivered, models.DeliveryDead }, status) {
+        services.Res(res).ErrParam("status").Error(400, "invalid_parameter", "status can only be pending, delivered or dead")
+        return
+    }
+
+    limit := 20
+    if qLimit := query.Get("limit"); !c.validate.IsEmpty(qLimit) {
+        if l, err := strconv.Atoi(qLimit); err == nil && l > 0 && l <= 100 {
+            limit = l
+        }
+    }
+
+    deliveries, err := models.FindWebhookDeliveriesByEndpointID(db.GetPostgresHandle(), endpoint.ID, status, limit)
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    services.Res(res).Json(deliveries)
+}
+
+// schedule a delivery for immediate redelivery. Typically used for dead lettered deliveries
+func (c *WebhookController) Redeliver(params martini.Params, res http.ResponseWriter, req services.AuxRequestContext, db *services.DB) {
+
+    // TODO: get client id from access token
+    clientID := "kl14zFDq4SHlmmmVNHgLtE0LqCo8BTjyShOH"
+
+    dbCon := db.GetPostgresHandle()
+    service, _, _ := models.FindServiceByClientId(dbCon, clientID)
+
+    delivery, found, err := models.FindWebhookDeliveryByObjectID(dbCon, params["id"])
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    } else if !found {
+        services.Res(res).Error(404, "not_found", "delivery was not found")
+        return
+    }
+
+    // ensure the delivery's endpoint belongs to the service and is still active
+    endpoint, found, err := models.FindWebhookEndpointById(dbCon, uint(delivery.EndpointID.Int64))
+    if err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    } else if !found || endpoint.ServiceID.Int64 != int64(service.ID) {
+        services.Res(res).Error(404, "not_found", "delivery was not found")
+        return
+    } else if !endpoint.Active {
+        services.Res(res).Error(400, "invalid_endpoint", "delivery endpoint has been removed")
+        return
+    }
+
+    delivery.Status = models.DeliveryPending
+    delivery.Attempts = 0
+    delivery.LastError = ""
+    delivery.NextAttemptAt = time.Now().UTC()
+    if err := dbCon.Save(&delivery).Error; err != nil {
+        req.Log.Error(err.Error())
+        services.Res(res).Error(500, "", "server error")
+        return
+    }
+
+    services.Res(res).Json(delivery)
+}
@@ -0,0 +1,103 @@
+// Metrics module defines the prometheus metrics of the server and
+// serves them in the prometheus text format on /metrics
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// registry of all metrics of the server
+var Registry = prometheus.NewRegistry()
+
+var (
+	// requests by route pattern, method and status
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ownode_http_requests_total",
+		Help: "HTTP requests by route, method and status.",
+	}, []string{ "route", "method", "status" })
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "ownode_http_request_duration_seconds",
+		Help: "Latency of HTTP requests by route, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{ "route", "method", "status" })
+
+	// transactions run again after a serialization failure or deadlock
+	TransactionRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ownode_db_transaction_retries_total",
+		Help: "Transactions retried after a serialization failure or deadlock.",
+	})
+
+	// transactions that still conflicted after their last attempt
+	TransactionConflicts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ownode_db_transaction_conflicts_total",
+		Help: "Transactions that failed because they conflicted on every attempt.",
+	})
+
+	// objects issued by create and batch requests, by issuer object name
+	ObjectsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ownode_objects_created_total",
+		Help: "Objects issued by create and batch requests, by issuer.",
+	}, []string{ "issuer" })
+
+	// amount charged from objects, by the issuer of the charged objects
+	ChargedAmount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ownode_charged_amount_total",
+		Help: "Amount charged from objects, by issuer of the objects.",
+	}, []string{ "issuer" })
+
+	// amount added to the soul balance of issuers
+	SoulRenewed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ownode_soul_renewed_total",
+		Help: "Amount added to soul balances, by issuer.",
+	}, []string{ "issuer" })
+
+	// pins that were wrong, missing or malformed
+	PinFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ownode_pin_failures_total",
+		Help: "Failed pin checks by reason.",
+	}, []string{ "reason" })
+
+	// requests rejected by a policy, by policy path
+	PolicyRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ownode_policy_rejections_total",
+		Help: "Requests rejected by policies, by policy path.",
+	}, []string{ "policy" })
+)
+
+// reasons of pin failures
+const (
+	PinInvalid = "invalid"
+	PinMissing = "missing"
+	PinMalformed = "malformed"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		TransactionRetries,
+		TransactionConflicts,
+		ObjectsCreated,
+		ChargedAmount,
+		SoulRenewed,
+		PinFailures,
+		PolicyRejections,
+	)
+}
+
+// export the connection pool stats of a database
+func RegisterDatabase(db *sql.DB) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, "ownode"))
+}
+
+// serve the metrics of the registry
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+	"github.com/go-martini/martini"
+	"github.com/ownode/metrics"
+)
+
+var routeType = reflect.TypeOf((*martini.Route)(nil)).Elem()
+
+// count requests and measure their latency by route pattern, method and status.
+// Requests that match no route are recorded with the route `unmatched`
+func Metrics() interface{} {
+	return func(c martini.Context, res http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		c.Next()
+
+		labels := []string{ routePattern(c), req.Method, strconv.Itoa(responseStatus(res)) }
+		metrics.HTTPRequests.WithLabelValues(labels...).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	}
+}
+
+// the pattern of the route a request matched or `unmatched`.
+// The router maps the matched route into the context
+func routePattern(c martini.Context) string {
+	if r := c.Get(routeType); r.IsValid() && !r.IsNil() {
+		return r.Interface().(martini.Route).Pattern()
+	}
+	return "unmatched"
+}
+
+// the status code written to a response
+func responseStatus(res http.ResponseWriter) int {
+	if rw, ok := res.(martini.ResponseWriter); ok && rw.Status() != 0 {
+		return rw.Status()
+	}
+	return http.StatusOK
+}
@@ -6,6 +6,9 @@ import (
 	"github.com/go-martini/martini"
 	"strings"
 	"github.com/ownode/services"
+	"github.com/ownode/metrics"
+	"github.com/ownode/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"regexp"
 )
 
@@ -55,15 +58,23 @@ func (pol *policy) IsMatch(policyPath, requestPath string, reqMethod string) boo
 }
 
 // call all policies for the current request.
-// policies for the current matching URL path is called
+// policies for the current matching URL path is called.
+// A policy that writes a response rejects the request.
+// The policies of a path are traced in a span
 func (pol *policy) Process() {
 	for path, funcList := range pol.policies {
 		if pol.IsMatch(path, pol.req.URL.Path, pol.req.Method) {
+			_, span := tracing.Tracer.Start(pol.req.Request.Context(), "policy " + path)
 			for _, f := range funcList {
 				if pol.req.Written() == false {
 					f(pol.res, pol.req, pol.log)
+					if pol.req.Written() {
+						metrics.PolicyRejections.WithLabelValues(path).Inc()
+					}
 				}
 			}
+			span.SetAttributes(attribute.Bool("policy.rejected", pol.req.Written()))
+			span.End()
 		}
 	}
 }
@@ -0,0 +1,51 @@
+package middlewares
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+	"github.com/go-martini/martini"
+	"github.com/ownode/config"
+	"github.com/ownode/services"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// request ids accepted from clients. Other ids are replaced with a generated one
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// add the auxilliary request context of a request. The request keeps the id in its
+// X-Request-ID header or is given a new one. The id is echoed in the response and
+// every line of the request's log, along with the ids of the request's trace and span.
+// The log is mapped for handlers that ask for a log and a line with redacted
+// headers is logged once the request is served
+func RequestContext() interface{} {
+	return func(c martini.Context, res http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(services.RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = services.NewObjectID()
+		}
+		res.Header().Set(services.RequestIDHeader, id)
+
+		log := config.Log().WithField("request_id", id)
+		if span := trace.SpanContextFromContext(req.Context()); span.IsValid() {
+			log = log.WithFields(map[string]interface{}{ "trace_id": span.TraceID().String(), "span_id": span.SpanID().String() })
+		}
+		arc := services.NewAuxRequestContext(c, req)
+		arc.ID = id
+		arc.Log = log
+		c.Map(arc)
+		c.Map(log)
+
+		start := time.Now()
+		c.Next()
+
+		log.WithFields(map[string]interface{}{
+			"method": req.Method,
+			"path": services.RedactURL(req.URL),
+			"status": responseStatus(res),
+			"duration_ms": time.Since(start).Seconds() * 1000,
+			"remote_addr": req.RemoteAddr,
+			"headers": services.RedactHeader(req.Header),
+		}).Info("request")
+	}
+}
@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"net/http"
+	"github.com/go-martini/martini"
+	"github.com/ownode/services"
+	"github.com/ownode/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// start a span for every request, continuing the trace of the request's
+// traceparent header. The span is named after the matched route. Later
+// handlers get the request with the span in its context
+func Tracing() interface{} {
+	return func(c martini.Context, res http.ResponseWriter, req *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := tracing.Tracer.Start(ctx, req.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", services.RedactURL(req.URL)),
+		))
+		defer span.End()
+
+		c.Map(req.WithContext(ctx))
+		c.Next()
+
+		route, status := routePattern(c), responseStatus(res)
+		span.SetName(req.Method + " " + route)
+		span.SetAttributes(attribute.String("http.route", route), attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
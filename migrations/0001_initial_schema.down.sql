@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS quotes;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS wallet_events;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_endpoints;
+DROP TABLE IF EXISTS object_batch_failures;
+DROP TABLE IF EXISTS object_batches;
+DROP TABLE IF EXISTS object_uses;
+DROP TABLE IF EXISTS objects;
+DROP TABLE IF EXISTS wallets;
+DROP TABLE IF EXISTS services;
+DROP TABLE IF EXISTS identities;
+DROP TABLE IF EXISTS tokens;
@@ -0,0 +1,184 @@
+-- schema previously created by AutoMigrate. Tables are only created if missing
+-- so databases created by AutoMigrate can adopt versioned migrations
+
+CREATE TABLE IF NOT EXISTS tokens (
+	id serial PRIMARY KEY,
+	token varchar(255) NOT NULL UNIQUE,
+	type varchar(255),
+	expires_in timestamp with time zone,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS identities (
+	id serial PRIMARY KEY,
+	object_id varchar(255) NOT NULL UNIQUE,
+	full_name varchar(255),
+	email varchar(255),
+	issuer boolean,
+	soul_balance numeric,
+	object_name varchar(255),
+	base_currency varchar(255),
+	meta_schema text,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS services (
+	id serial PRIMARY KEY,
+	name varchar(255),
+	identity_id bigint,
+	object_id varchar(255) NOT NULL UNIQUE,
+	description varchar(255),
+	client_id varchar(255) NOT NULL UNIQUE,
+	client_secret varchar(255),
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS wallets (
+	id serial PRIMARY KEY,
+	object_id varchar(255) NOT NULL UNIQUE,
+	identity_id bigint,
+	handle varchar(255),
+	password varchar(255),
+	lock boolean,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS objects (
+	id bigserial PRIMARY KEY,
+	object_id varchar(255) NOT NULL UNIQUE,
+	pin varchar(255) NOT NULL UNIQUE,
+	type varchar(255),
+	wallet_id bigint,
+	service_id bigint,
+	balance numeric,
+	meta jsonb,
+	open boolean,
+	open_method varchar(255),
+	open_time bigint,
+	open_pin varchar(255),
+	max_uses integer,
+	uses integer,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS object_uses (
+	id bigserial PRIMARY KEY,
+	object_id varchar(255) NOT NULL UNIQUE,
+	ticket_id bigint,
+	service_id bigint,
+	wallet_id bigint,
+	meta jsonb,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS object_batches (
+	id serial PRIMARY KEY,
+	object_id varchar(255) NOT NULL UNIQUE,
+	service_id bigint,
+	type varchar(255),
+	max_uses integer,
+	status varchar(255),
+	total_rows integer,
+	processed_rows integer,
+	failed_rows integer,
+	objects_created integer,
+	error varchar(255),
+	rows text,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS object_batch_failures (
+	id bigserial PRIMARY KEY,
+	batch_id bigint,
+	row_number integer,
+	wallet_id varchar(255),
+	reason varchar(255),
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+	id serial PRIMARY KEY,
+	object_id varchar(255) NOT NULL UNIQUE,
+	service_id bigint,
+	url varchar(255) NOT NULL,
+	secret varchar(255),
+	events varchar(255),
+	active boolean,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id bigserial PRIMARY KEY,
+	object_id varchar(255) NOT NULL UNIQUE,
+	endpoint_id bigint,
+	event_id varchar(255),
+	event varchar(255),
+	payload text,
+	status varchar(255),
+	attempts integer,
+	next_attempt_at timestamp with time zone,
+	last_error varchar(255),
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS wallet_events (
+	id bigserial PRIMARY KEY,
+	wallet_id bigint,
+	event varchar(255),
+	data jsonb,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS idx_wallet_events_wallet_id ON wallet_events (wallet_id);
+
+CREATE TABLE IF NOT EXISTS events (
+	sequence bigserial PRIMARY KEY,
+	event_id varchar(255) NOT NULL UNIQUE,
+	type varchar(255),
+	data jsonb,
+	created_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS idx_events_type ON events (type);
+
+-- events are looked up by the wallets they changed
+CREATE INDEX IF NOT EXISTS idx_events_data_wallets ON events USING gin ((data->'wallets'));
+
+CREATE TABLE IF NOT EXISTS quotes (
+	id serial PRIMARY KEY,
+	object_id varchar(255) NOT NULL UNIQUE,
+	service_id integer,
+	from_currency varchar(255),
+	to_currency varchar(255),
+	rate numeric,
+	amount numeric,
+	source_amount numeric,
+	rate_time timestamp with time zone,
+	expires_at timestamp with time zone,
+	used boolean,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone
+);
+
+-- meta columns were previously created as text. convert them to jsonb,
+-- existing meta is kept as a json string
+DO $$
+DECLARE
+	t text;
+BEGIN
+	FOREACH t IN ARRAY ARRAY['objects', 'object_uses'] LOOP
+		IF (SELECT data_type FROM information_schema.columns WHERE table_name = t AND column_name = 'meta') = 'text' THEN
+			EXECUTE format('ALTER TABLE %I ALTER COLUMN meta TYPE jsonb USING CASE WHEN meta IS NULL OR meta = '''' THEN NULL ELSE to_jsonb(meta) END', t);
+		END IF;
+	END LOOP;
+END
+$$;
@@ -0,0 +1,5 @@
+ALTER TABLE objects DROP CONSTRAINT IF EXISTS chk_objects_balance;
+ALTER TABLE objects DROP CONSTRAINT IF EXISTS fk_objects_service;
+ALTER TABLE objects DROP CONSTRAINT IF EXISTS fk_objects_wallet;
+ALTER TABLE wallets DROP CONSTRAINT IF EXISTS fk_wallets_identity;
+ALTER TABLE services DROP CONSTRAINT IF EXISTS fk_services_identity;
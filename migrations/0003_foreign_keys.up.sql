@@ -0,0 +1,6 @@
+-- fails if existing rows reference missing wallets, services or identities
+ALTER TABLE services ADD CONSTRAINT fk_services_identity FOREIGN KEY (identity_id) REFERENCES identities (id);
+ALTER TABLE wallets ADD CONSTRAINT fk_wallets_identity FOREIGN KEY (identity_id) REFERENCES identities (id);
+ALTER TABLE objects ADD CONSTRAINT fk_objects_wallet FOREIGN KEY (wallet_id) REFERENCES wallets (id);
+ALTER TABLE objects ADD CONSTRAINT fk_objects_service FOREIGN KEY (service_id) REFERENCES services (id);
+ALTER TABLE objects ADD CONSTRAINT chk_objects_balance CHECK (balance >= 0);
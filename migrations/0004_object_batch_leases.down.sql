@@ -0,0 +1,2 @@
+ALTER TABLE object_batches DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE object_batches DROP COLUMN IF EXISTS lease_owner;
@@ -0,0 +1,4 @@
+-- the transaction that created a wallet event. Streams resume from the
+-- transaction and id of the last event sent, as ids are not assigned in commit order
+ALTER TABLE wallet_events ADD COLUMN IF NOT EXISTS tx_id bigint NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_wallet_events_wallet_id_tx_id ON wallet_events (wallet_id, tx_id, id);
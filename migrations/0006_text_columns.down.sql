@@ -0,0 +1,4 @@
+ALTER TABLE webhook_endpoints ALTER COLUMN url TYPE varchar(255) USING left(url, 255);
+ALTER TABLE object_batch_failures ALTER COLUMN reason TYPE varchar(255) USING left(reason, 255);
+ALTER TABLE object_batches ALTER COLUMN error TYPE varchar(255) USING left(error, 255);
+ALTER TABLE webhook_deliveries ALTER COLUMN last_error TYPE varchar(255) USING left(last_error, 255);
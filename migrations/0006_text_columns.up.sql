@@ -0,0 +1,6 @@
+-- errors of deliveries and batches include urls and driver messages that
+-- are often longer than 255 characters. Endpoint urls are limited by the api
+ALTER TABLE webhook_deliveries ALTER COLUMN last_error TYPE text;
+ALTER TABLE object_batches ALTER COLUMN error TYPE text;
+ALTER TABLE object_batch_failures ALTER COLUMN reason TYPE text;
+ALTER TABLE webhook_endpoints ALTER COLUMN url TYPE text;
@@ -0,0 +1,267 @@
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// migrations of the sqlite schema. Versions match the postgres migrations
+//go:embed sqlite/*.sql
+var sqliteFiles embed.FS
+
+// database drivers migrations are written for
+const (
+	Postgres = "postgres"
+	SQLite = "sqlite3"
+)
+
+// a numbered schema change. Up applies the change and Down reverts it
+type Migration struct {
+	Version int
+	Name string
+	Up string
+	Down string
+}
+
+// state of a migration in a database
+type Status struct {
+	Migration
+	Applied bool
+	AppliedAt time.Time
+}
+
+// migration files are named <version>_<name>.<up|down>.sql
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// key of the advisory lock held while a migration runs
+const lockKey = 7461223
+
+// the migrations of the server for a database driver in version order
+func All(driver string) ([]Migration, error) {
+	switch driver {
+	case Postgres:
+		return Parse(files)
+	case SQLite:
+		sub, err := fs.Sub(sqliteFiles, "sqlite")
+		if err != nil {
+			return nil, err
+		}
+		return Parse(sub)
+	}
+	return nil, fmt.Errorf("migrations: unsupported driver %s", driver)
+}
+
+// parse the migration files of a file system. Every migration must have an up and a down file
+func Parse(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{ Version: version, Name: match[2] }
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations: version %d is used by %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrations: %04d_%s must have an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// create the table that records applied migrations
+func ensureTable(db *sql.DB, driver string) error {
+	timeType := "timestamp with time zone"
+	if driver == SQLite {
+		timeType = "datetime"
+	}
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at ` + timeType + ` NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	return err
+}
+
+// check if the table that records applied migrations exists
+func tableExists(db *sql.DB, driver string) (bool, error) {
+	query := "SELECT to_regclass('schema_migrations') IS NOT NULL"
+	if driver == SQLite {
+		query = "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')"
+	}
+	var exists bool
+	return exists, db.QueryRow(query).Scan(&exists)
+}
+
+// get the status of each migration. Only reads the database, migrations
+// are not applied if the table that records them does not exist
+func GetStatus(db *sql.DB, driver string, migrations []Migration) ([]Status, error) {
+	statuses := []Status{}
+	if exists, err := tableExists(db, driver); err != nil {
+		return nil, err
+	} else if !exists {
+		for _, m := range migrations {
+			statuses = append(statuses, Status{ Migration: m })
+		}
+		return statuses, nil
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, m := range migrations {
+		at, ok := applied[m.Version]
+		statuses = append(statuses, Status{ Migration: m, Applied: ok, AppliedAt: at })
+	}
+	return statuses, nil
+}
+
+// the migrations not applied to a database
+func Pending(db *sql.DB, driver string, migrations []Migration) ([]Migration, error) {
+	statuses, err := GetStatus(db, driver, migrations)
+	if err != nil {
+		return nil, err
+	}
+	pending := []Migration{}
+	for _, s := range statuses {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// run a migration in a transaction. The advisory lock keeps concurrent
+// runs from applying or reverting the same migration twice. sqlite
+// transactions take the database write lock when they begin
+func run(db *sql.DB, driver string, m Migration, up bool) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	if driver == Postgres {
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", lockKey); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+
+	var applied bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.Version).Scan(&applied); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if applied == up {
+		tx.Rollback()
+		return false, nil
+	}
+
+	script, record := m.Up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)"
+	if !up {
+		script, record = m.Down, "DELETE FROM schema_migrations WHERE version = $1 AND name = $2"
+	}
+	if _, err := tx.Exec(script); err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("migration %04d_%s: %s", m.Version, m.Name, err)
+	}
+	if _, err := tx.Exec(record, m.Version, m.Name); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// apply the pending migrations in version order. Returns the migrations applied
+func Up(db *sql.DB, driver string, migrations []Migration) ([]Migration, error) {
+	if err := ensureTable(db, driver); err != nil {
+		return nil, err
+	}
+	pending, err := Pending(db, driver, migrations)
+	if err != nil {
+		return nil, err
+	}
+	done := []Migration{}
+	for _, m := range pending {
+		ran, err := run(db, driver, m, true)
+		if err != nil {
+			return done, err
+		}
+		if ran {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// revert the last `steps` applied migrations. Returns the migrations reverted
+func Down(db *sql.DB, driver string, migrations []Migration, steps int) ([]Migration, error) {
+	statuses, err := GetStatus(db, driver, migrations)
+	if err != nil {
+		return nil, err
+	}
+	done := []Migration{}
+	for i := len(statuses) - 1; i >= 0 && len(done) < steps; i-- {
+		if !statuses[i].Applied {
+			continue
+		}
+		ran, err := run(db, driver, statuses[i].Migration, false)
+		if err != nil {
+			return done, err
+		}
+		if ran {
+			done = append(done, statuses[i].Migration)
+		}
+	}
+	return done, nil
+}
@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllMigrationsParse(t *testing.T) {
+	assert := assert.New(t)
+	migrations, err := All(Postgres)
+	assert.Nil(err)
+	assert.True(len(migrations) > 0)
+	for i, m := range migrations {
+		assert.Equal(i + 1, m.Version, "versions must have no gaps")
+	}
+
+	// every migration has a sqlite equivalent
+	sqlite, err := All(SQLite)
+	assert.Nil(err)
+	assert.Equal(len(migrations), len(sqlite))
+	for i, m := range sqlite {
+		assert.Equal(migrations[i].Version, m.Version)
+		assert.Equal(migrations[i].Name, m.Name)
+	}
+
+	_, err = All("mysql")
+	assert.NotNil(err)
+}
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+	migrations, err := Parse(fstest.MapFS{
+		"0002_second.up.sql": { Data: []byte("up 2") },
+		"0002_second.down.sql": { Data: []byte("down 2") },
+		"0001_first.up.sql": { Data: []byte("up 1") },
+		"0001_first.down.sql": { Data: []byte("down 1") },
+		"readme.md": { Data: []byte("ignored") },
+	})
+	assert.Nil(err)
+	assert.Equal(2, len(migrations))
+	assert.Equal("first", migrations[0].Name)
+	assert.Equal("down 2", migrations[1].Down)
+
+	// a migration without a down file
+	_, err = Parse(fstest.MapFS{ "0001_first.up.sql": { Data: []byte("up 1") } })
+	assert.NotNil(err)
+
+	// a version used by two migrations
+	_, err = Parse(fstest.MapFS{
+		"0001_first.up.sql": { Data: []byte("up") },
+		"0001_other.down.sql": { Data: []byte("down") },
+	})
+	assert.NotNil(err)
+}
@@ -0,0 +1,167 @@
+-- sqlite schema for local development and tests. Foreign keys and the
+-- balance check are declared here since sqlite cannot add constraints later
+
+CREATE TABLE tokens (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	token varchar(255) NOT NULL UNIQUE,
+	type varchar(255),
+	expires_in datetime,
+	created_at datetime,
+	updated_at datetime
+);
+
+CREATE TABLE identities (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	object_id varchar(255) NOT NULL UNIQUE,
+	full_name varchar(255),
+	email varchar(255),
+	issuer boolean,
+	soul_balance numeric,
+	object_name varchar(255),
+	base_currency varchar(255),
+	meta_schema text,
+	created_at datetime,
+	updated_at datetime
+);
+
+CREATE TABLE services (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	name varchar(255),
+	identity_id bigint REFERENCES identities (id),
+	object_id varchar(255) NOT NULL UNIQUE,
+	description varchar(255),
+	client_id varchar(255) NOT NULL UNIQUE,
+	client_secret varchar(255),
+	created_at datetime,
+	updated_at datetime
+);
+
+CREATE TABLE wallets (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	object_id varchar(255) NOT NULL UNIQUE,
+	identity_id bigint REFERENCES identities (id),
+	handle varchar(255),
+	password varchar(255),
+	lock boolean,
+	created_at datetime,
+	updated_at datetime
+);
+
+CREATE TABLE objects (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	object_id varchar(255) NOT NULL UNIQUE,
+	pin varchar(255) NOT NULL UNIQUE,
+	type varchar(255),
+	wallet_id bigint REFERENCES wallets (id),
+	service_id bigint REFERENCES services (id),
+	balance numeric CHECK (balance >= 0),
+	meta text,
+	open boolean,
+	open_method varchar(255),
+	open_time bigint,
+	open_pin varchar(255),
+	max_uses integer,
+	uses integer,
+	created_at datetime,
+	updated_at datetime
+);
+
+CREATE TABLE object_uses (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	object_id varchar(255) NOT NULL UNIQUE,
+	ticket_id bigint,
+	service_id bigint,
+	wallet_id bigint,
+	meta text,
+	created_at datetime,
+	updated_at datetime
+);
+
+CREATE TABLE object_batches (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	object_id varchar(255) NOT NULL UNIQUE,
+	service_id bigint,
+	type varchar(255),
+	max_uses integer,
+	status varchar(255),
+	total_rows integer,
+	processed_rows integer,
+	failed_rows integer,
+	objects_created integer,
+	error varchar(255),
+	rows text,
+	created_at datetime,
+	updated_at datetime
+);
+
+CREATE TABLE object_batch_failures (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	batch_id bigint,
+	row_number integer,
+	wallet_id varchar(255),
+	reason varchar(255),
+	created_at datetime,
+	updated_at datetime
+);
+
+CREATE TABLE webhook_endpoints (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	object_id varchar(255) NOT NULL UNIQUE,
+	service_id bigint,
+	url varchar(255) NOT NULL,
+	secret varchar(255),
+	events varchar(255),
+	active boolean,
+	created_at datetime,
+	updated_at datetime
+);
+
+CREATE TABLE webhook_deliveries (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	object_id varchar(255) NOT NULL UNIQUE,
+	endpoint_id bigint,
+	event_id varchar(255),
+	event varchar(255),
+	payload text,
+	status varchar(255),
+	attempts integer,
+	next_attempt_at datetime,
+	last_error varchar(255),
+	created_at datetime,
+	updated_at datetime
+);
+
+CREATE TABLE wallet_events (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	wallet_id bigint,
+	event varchar(255),
+	data text,
+	created_at datetime,
+	updated_at datetime
+);
+CREATE INDEX idx_wallet_events_wallet_id ON wallet_events (wallet_id);
+
+CREATE TABLE events (
+	sequence integer PRIMARY KEY AUTOINCREMENT,
+	event_id varchar(255) NOT NULL UNIQUE,
+	type varchar(255),
+	data text,
+	created_at datetime
+);
+CREATE INDEX idx_events_type ON events (type);
+
+CREATE TABLE quotes (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	object_id varchar(255) NOT NULL UNIQUE,
+	service_id integer,
+	from_currency varchar(255),
+	to_currency varchar(255),
+	rate numeric,
+	amount numeric,
+	source_amount numeric,
+	rate_time datetime,
+	expires_at datetime,
+	used boolean,
+	created_at datetime,
+	updated_at datetime
+);
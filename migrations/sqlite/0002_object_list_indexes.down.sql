@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_objects_wallet_id_created_at;
+DROP INDEX IF EXISTS idx_objects_created_at;
+DROP INDEX IF EXISTS idx_objects_service_id;
+DROP INDEX IF EXISTS idx_objects_wallet_id;
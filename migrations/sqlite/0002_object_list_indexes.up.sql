@@ -0,0 +1,5 @@
+-- indexes used to list the objects of a wallet
+CREATE INDEX IF NOT EXISTS idx_objects_wallet_id ON objects (wallet_id);
+CREATE INDEX IF NOT EXISTS idx_objects_service_id ON objects (service_id);
+CREATE INDEX IF NOT EXISTS idx_objects_created_at ON objects (created_at);
+CREATE INDEX IF NOT EXISTS idx_objects_wallet_id_created_at ON objects (wallet_id, created_at, id);
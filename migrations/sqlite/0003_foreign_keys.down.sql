@@ -0,0 +1,2 @@
+-- the constraints are part of 0001_initial_schema
+SELECT 1;
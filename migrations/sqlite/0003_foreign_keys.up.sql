@@ -0,0 +1,3 @@
+-- sqlite cannot add constraints to existing tables.
+-- The foreign keys and balance check are declared in 0001_initial_schema
+SELECT 1;
@@ -0,0 +1,2 @@
+ALTER TABLE object_batches DROP COLUMN lease_expires_at;
+ALTER TABLE object_batches DROP COLUMN lease_owner;
@@ -0,0 +1,3 @@
+-- the instance processing a batch and when its claim expires
+ALTER TABLE object_batches ADD COLUMN lease_owner varchar(255);
+ALTER TABLE object_batches ADD COLUMN lease_expires_at datetime;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_wallet_events_wallet_id_tx_id;
+ALTER TABLE wallet_events DROP COLUMN tx_id;
@@ -0,0 +1,4 @@
+-- the transaction that created a wallet event. sqlite commits transactions one
+-- at a time, so events keep a zero transaction and are ordered by id
+ALTER TABLE wallet_events ADD COLUMN tx_id bigint NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_wallet_events_wallet_id_tx_id ON wallet_events (wallet_id, tx_id, id);
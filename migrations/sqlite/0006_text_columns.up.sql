@@ -0,0 +1,3 @@
+-- sqlite does not enforce the length of varchar columns, so errors and
+-- urls longer than 255 characters are already stored in full
+SELECT 1;
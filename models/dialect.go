@@ -0,0 +1,34 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// sql dialects of the supported databases. The names are the database drivers
+const (
+	Postgres = "postgres"
+	SQLite = "sqlite3"
+)
+
+// key of the dialect of a gorm handle
+const dialectKey = "ownode:dialect"
+
+// return a handle that records the dialect of its database. Queries that
+// differ between databases check the dialect of the handle they run on.
+// Handles and transactions derived from the handle keep the dialect
+func WithDialect(db *gorm.DB, dialect string) *gorm.DB {
+	return db.Set(dialectKey, dialect)
+}
+
+// dialect of the database of a handle. Handles without a dialect are postgres
+func DialectOf(db *gorm.DB) string {
+	if value, ok := db.Get(dialectKey); ok {
+		return value.(string)
+	}
+	return Postgres
+}
+
+// check if a handle's database is sqlite
+func IsSQLite(db *gorm.DB) bool {
+	return DialectOf(db) == SQLite
+}
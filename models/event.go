@@ -0,0 +1,229 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "time"
+)
+
+var (
+	EventObjectCreated = "ObjectCreated"
+	EventObjectMerged = "ObjectMerged"
+	EventObjectDivided = "ObjectDivided"
+	EventObjectSubtracted = "ObjectSubtracted"
+	EventObjectCharged = "ObjectCharged"
+	EventObjectOpened = "ObjectOpened"
+	EventObjectLocked = "ObjectLocked"
+	EventObjectRedeemed = "ObjectRedeemed"
+	EventWalletLocked = "WalletLocked"
+	EventWalletOpened = "WalletOpened"
+
+	// records the objects that existed before events were recorded
+	EventObjectsImported = "ObjectsImported"
+)
+
+// a domain event. Events are append only and ordered by sequence.
+// Replaying object events in sequence order rebuilds the objects table.
+// Sequences are assigned when events are inserted and transactions may commit
+// in another order, so events read after a sequence are only complete when
+// every page is read from the same snapshot (see BeginEventSnapshot)
+type Event struct {
+	Sequence int64 `gorm:"primary_key" json:"sequence" sql:"type:bigserial"`
+	EventID string `json:"id" sql:"not null;unique"`
+	Type string `json:"type" sql:"index"`
+	Data Meta `json:"data" sql:"type:jsonb"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// the full state of an object row as recorded in events
+type ObjectState struct {
+	ID uint `json:"id"`
+	ObjectID string `json:"object_id"`
+	Pin string `json:"pin"`
+	Type string `json:"type"`
+	WalletID int64 `json:"wallet_id"`
+	ServiceID int64 `json:"service_id"`
+	Balance float64 `json:"balance"`
+	Meta Meta `json:"meta"`
+	Open bool `json:"open"`
+	OpenMethod string `json:"open_method,omitempty"`
+	OpenTime int64 `json:"open_time,omitempty"`
+	OpenPin string `json:"open_pin,omitempty"`
+	MaxUses int `json:"max_uses,omitempty"`
+	Uses int `json:"uses"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// the change an event made to objects and wallets
+type EventChange struct {
+	Objects []ObjectState `json:"objects,omitempty"`
+	Deleted []string `json:"deleted,omitempty"`
+
+	// wallets of the objects created, updated or deleted
+	Wallets []int64 `json:"wallets,omitempty"`
+	WalletID int64 `json:"wallet_id,omitempty"`
+	WalletLock bool `json:"wallet_lock,omitempty"`
+}
+
+// capture the state of an object
+func NewObjectState(object Object) ObjectState {
+	state := ObjectState{
+		ID: object.ID,
+		ObjectID: object.ObjectID,
+		Pin: object.Pin,
+		Type: object.Type,
+		WalletID: object.WalletID.Int64,
+		ServiceID: object.ServiceID.Int64,
+		Balance: object.Balance,
+		Meta: object.Meta,
+		Open: object.Open,
+		OpenMethod: object.OpenMethod,
+		OpenTime: object.OpenTime,
+		OpenPin: object.OpenPin,
+		MaxUses: object.MaxUses,
+		Uses: object.Uses,
+		CreatedAt: object.CreatedAt,
+		UpdatedAt: object.UpdatedAt,
+	}
+
+	// foreign keys are not set until associations are saved
+	if !object.WalletID.Valid {
+		state.WalletID = int64(object.Wallet.ID)
+	}
+	if !object.ServiceID.Valid {
+		state.ServiceID = int64(object.Service.ID)
+	}
+	return state
+}
+
+// object row described by the state
+func (s ObjectState) Object() Object {
+	object := Object{
+		ID: s.ID,
+		ObjectID: s.ObjectID,
+		Pin: s.Pin,
+		Type: s.Type,
+		WalletID: sql.NullInt64{ Int64: s.WalletID, Valid: s.WalletID != 0 },
+		ServiceID: sql.NullInt64{ Int64: s.ServiceID, Valid: s.ServiceID != 0 },
+		Balance: s.Balance,
+		Meta: s.Meta,
+		Open: s.Open,
+		OpenMethod: s.OpenMethod,
+		OpenTime: s.OpenTime,
+		OpenPin: s.OpenPin,
+		MaxUses: s.MaxUses,
+		Uses: s.Uses,
+	}
+	object.CreatedAt = s.CreatedAt
+	object.UpdatedAt = s.UpdatedAt
+	return object
+}
+
+// create an event recording the objects it created or updated and
+// the object ids it deleted. Must be called in the transaction of the change
+func AppendObjectEvent(db *gorm.DB, eventID, eventType string, objects []Object, deleted []Object) error {
+	change := EventChange{}
+	seenWallets := map[int64]bool{}
+	addWallet := func(state ObjectState) {
+		if state.WalletID != 0 && !seenWallets[state.WalletID] {
+			seenWallets[state.WalletID] = true
+			change.Wallets = append(change.Wallets, state.WalletID)
+		}
+	}
+	for _, object := range objects {
+		state := NewObjectState(object)
+		change.Objects = append(change.Objects, state)
+		addWallet(state)
+	}
+	for _, object := range deleted {
+		change.Deleted = append(change.Deleted, object.ObjectID)
+		addWallet(NewObjectState(object))
+	}
+	return AppendEvent(db, eventID, eventType, change)
+}
+
+// create an event recording a change of a wallet's lock
+func AppendWalletEvent(db *gorm.DB, eventID, eventType string, wallet Wallet) error {
+	return AppendEvent(db, eventID, eventType, EventChange{ WalletID: int64(wallet.ID), WalletLock: wallet.Lock, Wallets: []int64{ int64(wallet.ID) } })
+}
+
+// create an event
+func AppendEvent(db *gorm.DB, eventID, eventType string, change EventChange) error {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	event := Event{ EventID: eventID, Type: eventType, Data: Meta(data), CreatedAt: time.Now().UTC() }
+	return db.Create(&event).Error
+}
+
+// decode the change an event made
+func (e Event) Change() (EventChange, error) {
+	change := EventChange{}
+	if e.Data.IsEmpty() {
+		return change, nil
+	}
+	return change, json.Unmarshal([]byte(e.Data), &change)
+}
+
+// begin reading events from a single snapshot, so pages of events read after
+// a sequence cannot skip an event of a transaction that commits late. Call end
+// once done. sqlite commits transactions one at a time in sequence order, so
+// events are read without a transaction rather than holding the write lock
+func BeginEventSnapshot(db *gorm.DB) (snapshot *gorm.DB, end func(), err error) {
+	if IsSQLite(db) {
+		return db, func() {}, nil
+	}
+	tx, err := BeginRepeatableRead(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tx, func() { tx.Rollback() }, nil
+}
+
+// find events after a sequence number, in sequence order. Pages of events
+// are only complete when read from one snapshot or while object changes are
+// locked out, as a rebuild does
+func FindEventsAfter(db *gorm.DB, sequence int64, limit int) ([]Event, error) {
+	result := []Event{}
+	return result, db.Where("sequence > ?", sequence).Order("sequence asc").Limit(limit).Find(&result).Error
+}
+
+// find events that changed a wallet's objects after a sequence number, in sequence
+// order. Pages of events are only complete when read from one snapshot
+func FindWalletObjectEventsAfter(db *gorm.DB, walletID uint, sequence int64, limit int) ([]Event, error) {
+	result := []Event{}
+	if IsSQLite(db) {
+		db = db.Where("sequence > ? AND EXISTS (SELECT 1 FROM json_each(data, '$.wallets') WHERE value = ?) AND type NOT IN (?)", sequence, walletID, []string{ EventWalletLocked, EventWalletOpened })
+	} else {
+		db = db.Where("sequence > ? AND data->'wallets' @> ?::jsonb AND type NOT IN (?)", sequence, fmt.Sprintf("[%d]", walletID), []string{ EventWalletLocked, EventWalletOpened })
+	}
+	return result, db.Order("sequence asc").Limit(limit).Find(&result).Error
+}
+
+// record the existing objects in ObjectsImported events if no event has been
+// recorded yet. This makes objects created before events were introduced replayable
+func ImportObjectsAsEvents(db *gorm.DB, eventID func() string, chunkSize int) error {
+	var count int
+	if err := db.Model(&Event{}).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+
+	for lastID := uint(0); ; {
+		objects := []Object{}
+		if err := db.Where("id > ?", lastID).Order("id asc").Limit(chunkSize).Find(&objects).Error; err != nil {
+			return err
+		}
+		if len(objects) == 0 {
+			return nil
+		}
+		if err := AppendObjectEvent(db, eventID(), EventObjectsImported, objects, nil); err != nil {
+			return err
+		}
+		lastID = objects[len(objects) - 1].ID
+	}
+}
@@ -5,6 +5,7 @@ import (
     _ "github.com/lib/pq"
     // "database/sql"
     // "github.com/ownode/services"
+    "github.com/ownode/tracing"
 )
 
 type Identity struct {
@@ -17,7 +18,8 @@ type Identity struct {
 	Issuer bool `json:"issuer,omitempty"`
 	SoulBalance float64 `json:"-"`
 	ObjectName  string `json:"object_name,omitempty"`
-	BaseCurrency string	`bson:"base_currency" json:"base_currency,omitempty"`
+	BaseCurrency string	`json:"base_currency,omitempty"`
+	MetaSchema string `json:"-" sql:"type:text"`
 	
 	Base
 }
@@ -68,35 +70,50 @@ func FindIdentityByObjectID(db *gorm.DB, id string) (Identity, bool, error) {
 	return result, true, nil
 }
 
-// add to a identities soul amount
+// lock the row of an identity until the transaction ends, so its soul balance
+// can be read and updated. sqlite transactions already hold the write lock
+func LockIdentity(db *gorm.DB, objectID string) (err error) {
+	if IsSQLite(db) {
+		return nil
+	}
+	stmt := "SELECT id FROM identities WHERE object_id = ? FOR UPDATE"
+	span := tracing.StartRawSpan(db, stmt)
+	defer func() { tracing.EndSpan(span, err) }()
+	return db.Exec(stmt, objectID).Error
+}
+
+// add to a identities soul amount. The identity is locked while its balance
+// is updated and the update is retried if it conflicts with another transaction
 func AddToSoulByObjectID (db *gorm.DB, id string, incrVal float64) (Identity, error) {
 	
 	identity := Identity{}
-	tx := db.Begin()
+	err := RepeatableReadTransaction(db, func(tx *gorm.DB) (err error) {
+		identity, err = AddToSoulInTransaction(tx, id, incrVal)
+		return err
+	})
+	return identity, err
+}
 
-	err := tx.Exec(`set transaction isolation level repeatable read`).Error
-	if err != nil {
-		tx.Rollback()
-	    return identity, err
-	}
+// add to a identities soul amount within a transaction. The identity
+// is locked until the transaction ends. incrVal may be negative
+func AddToSoulInTransaction(tx *gorm.DB, id string, incrVal float64) (Identity, error) {
 
-	// get identity
-	if err = tx.Where(&Identity{ ObjectID: id }).First(&identity).Error; err != nil {
-		tx.Rollback()
-		return identity, err
+	// lock and get identity
+	if err := LockIdentity(tx, id); err != nil {
+		return Identity{}, err
+	}
+	identity := Identity{}
+	if err := tx.Where(&Identity{ ObjectID: id }).First(&identity).Error; err != nil {
+		return Identity{}, err
 	}
 
 	// add to identities soul amount
 	identity.SoulBalance = identity.SoulBalance + incrVal
 
 	// update identity
-	tx.Save(&identity)
-
-	tx.Commit()
-	return identity, nil
+	return identity, tx.Save(&identity).Error
 }
 
-// find by object name
 func FindIdentityByObjectName(db *gorm.DB, name string) (Identity, bool, error) {
 	result := Identity{}
 	err := db.Where(&Identity{ ObjectName: name }).First(&result).Error
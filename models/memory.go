@@ -0,0 +1,346 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+var ErrNotFound = errors.New("record not found")
+
+// in-memory store used by the memory repositories
+type memoryStore struct {
+	mu sync.Mutex
+	lastID uint
+	identities map[uint]Identity
+	services map[uint]Service
+	wallets map[uint]Wallet
+	objects map[uint]Object
+	tokens map[uint]Token
+}
+
+// repositories backed by memory. Useful for tests
+type memoryRepos struct {
+	store *memoryStore
+
+	// set within a transaction, which already holds the store's lock
+	inTx bool
+}
+
+// create empty repositories backed by memory
+func NewMemoryRepos() Repos {
+	return memoryRepos{ store: &memoryStore{
+		identities: map[uint]Identity{},
+		services: map[uint]Service{},
+		wallets: map[uint]Wallet{},
+		objects: map[uint]Object{},
+		tokens: map[uint]Token{},
+	} }
+}
+
+// lock the store unless in a transaction. returns the unlock function
+func (r memoryRepos) lock() func() {
+	if r.inTx {
+		return func() {}
+	}
+	r.store.mu.Lock()
+	return r.store.mu.Unlock
+}
+
+func (r memoryRepos) nextID() uint {
+	r.store.lastID++
+	return r.store.lastID
+}
+
+func (r memoryRepos) Identities() IdentityRepo { return memoryIdentities{ r } }
+func (r memoryRepos) Services() ServiceRepo { return memoryServices{ r } }
+func (r memoryRepos) Wallets() WalletRepo { return memoryWallets{ r } }
+func (r memoryRepos) Objects() ObjectRepo { return memoryObjects{ r } }
+func (r memoryRepos) Tokens() TokenRepo { return memoryTokens{ r } }
+
+func (r memoryRepos) Transaction(fn func(Repos) error) error {
+	defer r.lock()()
+	s := r.store
+	lastID, identities, services, wallets, objects, tokens := s.lastID, copyMap(s.identities), copyMap(s.services), copyMap(s.wallets), copyMap(s.objects), copyMap(s.tokens)
+	if err := fn(memoryRepos{ store: s, inTx: true }); err != nil {
+		s.lastID, s.identities, s.services, s.wallets, s.objects, s.tokens = lastID, identities, services, wallets, objects, tokens
+		return err
+	}
+	return nil
+}
+
+func copyMap[T any](m map[uint]T) map[uint]T {
+	c := make(map[uint]T, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func nullID(id uint) sql.NullInt64 {
+	return sql.NullInt64{ Int64: int64(id), Valid: true }
+}
+
+// a service with its identity
+func (r memoryRepos) service(service Service) Service {
+	if identity, ok := r.store.identities[uint(service.IdentityID.Int64)]; ok && service.IdentityID.Valid {
+		service.Identity = &identity
+	}
+	return service
+}
+
+// a wallet with its identity
+func (r memoryRepos) wallet(wallet Wallet) Wallet {
+	if wallet.IdentityID.Valid {
+		wallet.Identity = r.store.identities[uint(wallet.IdentityID.Int64)]
+	}
+	return wallet
+}
+
+// an object with its wallet and service
+func (r memoryRepos) object(object Object) Object {
+	if object.WalletID.Valid {
+		object.Wallet = r.wallet(r.store.wallets[uint(object.WalletID.Int64)])
+	}
+	if object.ServiceID.Valid {
+		object.Service = r.service(r.store.services[uint(object.ServiceID.Int64)])
+	}
+	return object
+}
+
+type memoryIdentities struct {
+	memoryRepos
+}
+
+func (r memoryIdentities) Create(identity *Identity) error {
+	defer r.lock()()
+	identity.ID = r.nextID()
+	identity.BeforeCreate()
+	r.store.identities[identity.ID] = *identity
+	return nil
+}
+
+func (r memoryIdentities) Save(identity *Identity) error {
+	defer r.lock()()
+	if _, ok := r.store.identities[identity.ID]; !ok {
+		return ErrNotFound
+	}
+	identity.BeforeUpdate()
+	r.store.identities[identity.ID] = *identity
+	return nil
+}
+
+func (r memoryIdentities) find(match func(Identity) bool) (Identity, bool, error) {
+	defer r.lock()()
+	for _, identity := range r.store.identities {
+		if match(identity) {
+			return identity, true, nil
+		}
+	}
+	return Identity{}, false, nil
+}
+
+func (r memoryIdentities) FindByObjectID(id string) (Identity, bool, error) {
+	return r.find(func(i Identity) bool { return i.ObjectID == id })
+}
+
+func (r memoryIdentities) FindByObjectName(name string) (Identity, bool, error) {
+	return r.find(func(i Identity) bool { return i.ObjectName == name })
+}
+
+func (r memoryIdentities) AddToSoul(id string, amount float64) (Identity, error) {
+	defer r.lock()()
+	for key, identity := range r.store.identities {
+		if identity.ObjectID == id {
+			identity.SoulBalance += amount
+			identity.BeforeUpdate()
+			r.store.identities[key] = identity
+			return identity, nil
+		}
+	}
+	return Identity{}, ErrNotFound
+}
+
+type memoryServices struct {
+	memoryRepos
+}
+
+func (r memoryServices) Create(service *Service) error {
+	defer r.lock()()
+	service.ID = r.nextID()
+	if service.Identity != nil && service.Identity.ID != 0 {
+		service.IdentityID = nullID(service.Identity.ID)
+	}
+	service.BeforeCreate()
+	stored := *service
+	stored.Identity = nil
+	r.store.services[service.ID] = stored
+	return nil
+}
+
+func (r memoryServices) find(match func(Service) bool) (Service, bool, error) {
+	defer r.lock()()
+	for _, service := range r.store.services {
+		if match(service) {
+			return r.service(service), true, nil
+		}
+	}
+	return Service{}, false, nil
+}
+
+func (r memoryServices) FindByObjectID(id string) (Service, bool, error) {
+	return r.find(func(s Service) bool { return s.ObjectID == id })
+}
+
+func (r memoryServices) FindByClientID(clientID string) (Service, bool, error) {
+	return r.find(func(s Service) bool { return s.ClientID == clientID })
+}
+
+func (r memoryServices) FindByID(id uint) (Service, bool, error) {
+	return r.find(func(s Service) bool { return s.ID == id })
+}
+
+type memoryWallets struct {
+	memoryRepos
+}
+
+func (r memoryWallets) Create(wallet *Wallet) error {
+	defer r.lock()()
+	wallet.ID = r.nextID()
+	if wallet.Identity.ID != 0 {
+		wallet.IdentityID = nullID(wallet.Identity.ID)
+	}
+	wallet.BeforeCreate()
+	r.store.wallets[wallet.ID] = *wallet
+	return nil
+}
+
+func (r memoryWallets) Save(wallet *Wallet) error {
+	defer r.lock()()
+	if _, ok := r.store.wallets[wallet.ID]; !ok {
+		return ErrNotFound
+	}
+	wallet.BeforeUpdate()
+	r.store.wallets[wallet.ID] = *wallet
+	return nil
+}
+
+func (r memoryWallets) find(match func(Wallet) bool) (Wallet, bool, error) {
+	defer r.lock()()
+	for _, wallet := range r.store.wallets {
+		if match(wallet) {
+			return r.wallet(wallet), true, nil
+		}
+	}
+	return Wallet{}, false, nil
+}
+
+func (r memoryWallets) FindByObjectID(id string) (Wallet, bool, error) {
+	return r.find(func(w Wallet) bool { return w.ObjectID == id })
+}
+
+func (r memoryWallets) FindByHandle(handle string) (Wallet, bool, error) {
+	return r.find(func(w Wallet) bool { return w.Handle == handle })
+}
+
+func (r memoryWallets) FindAllByObjectID(ids []string) ([]Wallet, error) {
+	defer r.lock()()
+	result := []Wallet{}
+	for _, wallet := range r.store.wallets {
+		for _, id := range ids {
+			if wallet.ObjectID == id {
+				result = append(result, r.wallet(wallet))
+			}
+		}
+	}
+	return result, nil
+}
+
+type memoryObjects struct {
+	memoryRepos
+}
+
+func (r memoryObjects) Create(object *Object) error {
+	defer r.lock()()
+	object.ID = r.nextID()
+	if object.Wallet.ID != 0 {
+		object.WalletID = nullID(object.Wallet.ID)
+	}
+	if object.Service.ID != 0 {
+		object.ServiceID = nullID(object.Service.ID)
+	}
+	object.BeforeCreate()
+	r.store.objects[object.ID] = *object
+	return nil
+}
+
+func (r memoryObjects) Save(object *Object) error {
+	defer r.lock()()
+	if _, ok := r.store.objects[object.ID]; !ok {
+		return ErrNotFound
+	}
+	object.BeforeUpdate()
+	r.store.objects[object.ID] = *object
+	return nil
+}
+
+func (r memoryObjects) Delete(object *Object) error {
+	defer r.lock()()
+	delete(r.store.objects, object.ID)
+	return nil
+}
+
+func (r memoryObjects) find(match func(Object) bool) (Object, bool, error) {
+	defer r.lock()()
+	for _, object := range r.store.objects {
+		if match(object) {
+			return r.object(object), true, nil
+		}
+	}
+	return Object{}, false, nil
+}
+
+func (r memoryObjects) FindByObjectID(id string) (Object, bool, error) {
+	return r.find(func(o Object) bool { return o.ObjectID == id })
+}
+
+func (r memoryObjects) FindByObjectIDOrPin(idOrPin string) (Object, bool, error) {
+	return r.find(func(o Object) bool { return o.ObjectID == idOrPin || o.Pin == idOrPin })
+}
+
+func (r memoryObjects) FindAllByObjectID(ids []string) ([]Object, error) {
+	defer r.lock()()
+	result := []Object{}
+	for _, object := range r.store.objects {
+		for _, id := range ids {
+			if object.ObjectID == id {
+				result = append(result, r.object(object))
+			}
+		}
+	}
+	return result, nil
+}
+
+func (r memoryObjects) FindExistingPins(pins []string) ([]string, error) {
+	defer r.lock()()
+	existing := []string{}
+	for _, object := range r.store.objects {
+		for _, pin := range pins {
+			if object.Pin == pin {
+				existing = append(existing, pin)
+			}
+		}
+	}
+	return existing, nil
+}
+
+type memoryTokens struct {
+	memoryRepos
+}
+
+func (r memoryTokens) Create(token *Token) error {
+	defer r.lock()()
+	token.ID = r.nextID()
+	r.store.tokens[token.ID] = *token
+	return nil
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+// Meta is arbitrary json data attached to an object. It is stored as jsonb
+// and written to responses as raw json. An empty meta is stored as null.
+type Meta string
+
+// create meta from a string. If the string is not valid json,
+// it is stored as a json string
+func MetaFromString(str string) Meta {
+	if str == "" {
+		return ""
+	}
+	if json.Valid([]byte(str)) {
+		return Meta(str)
+	}
+	encoded, _ := json.Marshal(str)
+	return Meta(encoded)
+}
+
+// check if meta is empty
+func (m Meta) IsEmpty() bool {
+	return m == "" || m == "null"
+}
+
+// size of meta in bytes
+func (m Meta) Size() int {
+	return len([]byte(m))
+}
+
+func (m Meta) MarshalJSON() ([]byte, error) {
+	if m.IsEmpty() {
+		return []byte("null"), nil
+	}
+	return []byte(m), nil
+}
+
+func (m *Meta) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*m = ""
+		return nil
+	}
+	*m = Meta(data)
+	return nil
+}
+
+func (m Meta) Value() (driver.Value, error) {
+	if m.IsEmpty() {
+		return nil, nil
+	}
+	return string(m), nil
+}
+
+func (m *Meta) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = ""
+	case []byte:
+		*m = Meta(v)
+	case string:
+		*m = Meta(v)
+	default:
+		return errors.New("meta: unsupported type")
+	}
+	return nil
+}
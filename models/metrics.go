@@ -0,0 +1,96 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+    "github.com/ownode/tracing"
+    "time"
+)
+
+// balance held by a wallet in objects of an issuer
+type WalletHolding struct {
+	WalletID string `json:"wallet"`
+	Handle string `json:"handle"`
+	ObjectCount int64 `json:"object_count"`
+	Balance float64 `json:"balance"`
+}
+
+// liability and activity of an issuer
+type IssuerMetrics struct {
+	SoulBalance float64 `json:"soul_balance"`
+	OutstandingBalance float64 `json:"outstanding_balance"`
+	OutstandingObjects int64 `json:"outstanding_objects"`
+	Holders int64 `json:"holders"`
+	ObjectsCreated int64 `json:"objects_created"`
+	Charges int64 `json:"charges"`
+	Redemptions int64 `json:"redemptions"`
+	TopWallets []WalletHolding `json:"top_wallets"`
+	From time.Time `json:"from"`
+	To time.Time `json:"to"`
+}
+
+// condition matching the services of an issuer identity
+const issuerServicesCond = "service_id IN (SELECT id FROM services WHERE identity_id = ?)"
+
+// compute the metrics of an issuer. Activity (created, charges, redemptions)
+// is counted between from and to. topWallets is the number of top holders returned
+func ComputeIssuerMetrics(db *gorm.DB, issuer Identity, from, to time.Time, topWallets int) (IssuerMetrics, error) {
+	metrics := IssuerMetrics{ SoulBalance: issuer.SoulBalance, From: from, To: to, TopWallets: []WalletHolding{} }
+
+	// outstanding value and holders
+	err := ScanRow(db, []interface{}{ &metrics.OutstandingBalance, &metrics.OutstandingObjects, &metrics.Holders },
+		`SELECT COALESCE(SUM(balance) FILTER (WHERE type = ?), 0), COUNT(*), COUNT(DISTINCT wallet_id)
+		FROM objects WHERE ` + issuerServicesCond, ObjectValue, issuer.ID)
+	if err != nil {
+		return metrics, err
+	}
+
+	// objects created and charges are counted from the events of the window.
+	// A charge is counted for every issuer whose objects it recorded
+	objectServices := "SELECT (o->>'service_id')::bigint AS service_id FROM jsonb_array_elements(data->'objects') o"
+	if IsSQLite(db) {
+		objectServices = "SELECT json_extract(o.value, '$.service_id') AS service_id FROM json_each(data, '$.objects') o"
+	}
+	err = ScanRow(db, []interface{}{ &metrics.ObjectsCreated, &metrics.Charges }, `SELECT
+		COALESCE(SUM(CASE WHEN type = ? THEN (SELECT COUNT(*) FROM (` + objectServices + `) AS s WHERE service_id IN (SELECT id FROM services WHERE identity_id = ?)) END), 0),
+		COUNT(*) FILTER (WHERE type = ? AND EXISTS (SELECT 1 FROM (` + objectServices + `) AS s WHERE service_id IN (SELECT id FROM services WHERE identity_id = ?)))
+		FROM events WHERE type IN (?) AND created_at >= ? AND created_at < ?`,
+		EventObjectCreated, issuer.ID, EventObjectCharged, issuer.ID, []string{ EventObjectCreated, EventObjectCharged }, from, to)
+	if err != nil {
+		return metrics, err
+	}
+
+	if err := db.Model(&ObjectUse{}).Where(issuerServicesCond + " AND created_at >= ? AND created_at < ?", issuer.ID, from, to).Count(&metrics.Redemptions).Error; err != nil {
+		return metrics, err
+	}
+
+	metrics.TopWallets, err = findTopHoldings(db, issuer, topWallets)
+	return metrics, err
+}
+
+// find the wallets holding the largest balance in objects of an issuer
+func findTopHoldings(db *gorm.DB, issuer Identity, limit int) (holdings []WalletHolding, err error) {
+	holdings = []WalletHolding{}
+	stmt := `SELECT wallets.object_id, wallets.handle, COUNT(*), COALESCE(SUM(objects.balance) FILTER (WHERE objects.type = ?), 0) AS balance
+		FROM objects JOIN wallets ON wallets.id = objects.wallet_id
+		WHERE objects.` + issuerServicesCond + `
+		GROUP BY wallets.object_id, wallets.handle
+		ORDER BY balance DESC, wallets.object_id LIMIT ?`
+	span := tracing.StartRawSpan(db, stmt)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	rows, err := db.Raw(stmt, ObjectValue, issuer.ID, limit).Rows()
+	if err != nil {
+		return holdings, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		holding := WalletHolding{}
+		if err := rows.Scan(&holding.WalletID, &holding.Handle, &holding.ObjectCount, &holding.Balance); err != nil {
+			return holdings, err
+		}
+		holdings = append(holdings, holding)
+	}
+	return holdings, rows.Err()
+}
+
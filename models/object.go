@@ -6,6 +6,7 @@ import (
     _ "github.com/lib/pq"
     "database/sql"
     // "github.com/ownode/services"
+    "github.com/ownode/tracing"
 )
 
 var (
@@ -26,11 +27,13 @@ type Object struct {
 	Service Service `json:"service"`
 	ServiceID  sql.NullInt64 `json:"-"`
 	Balance float64  `gorm:"balance" json:"balance"`
-	Meta string `gorm:"meta" json:"meta" sql:"type:text"`
+	Meta Meta `gorm:"meta" json:"meta" sql:"type:jsonb"`
 	Open bool `gorm:"open" json:"open"`
 	OpenMethod string `gorm:"open_method" json:"open_method,omitempty"`
 	OpenTime int64 `gorm:"open_time" json:"open_time,omitempty"`
 	OpenPin string `gorm:"open_pin" json:"-"`
+	MaxUses int `gorm:"max_uses" json:"max_uses,omitempty"`
+	Uses int `gorm:"uses" json:"uses"`
 	Base
 }
 
@@ -39,6 +42,22 @@ func CreateObject(db *gorm.DB, object *Object) error {
 	return db.Create(object).Error
 }
 
+// create an object in transaction tx. If its pin was taken by a concurrent
+// transaction, the object is created again with a pin from newPin, up to
+// attempts times in total
+func CreateObjectRetryingPin(tx *gorm.DB, object *Object, attempts int, newPin func() (string, error)) error {
+	return retryPinConflicts(tx, attempts, func(retry bool) error {
+		if retry {
+			pin, err := newPin()
+			if err != nil {
+				return err
+			}
+			object.Pin = pin
+		}
+		return CreateObject(tx, object)
+	})
+}
+
 // find object by object id
 func FindObjectByObjectID(db *gorm.DB, objectID string) (Object, bool, error) {
 	result := Object{}
@@ -65,8 +84,56 @@ func FindObjectByObjectIDOrPin(db *gorm.DB, IDOrPin string) (Object, bool, error
 	return result, true, nil
 }
 
+// find object by object id and lock it until the transaction ends
+func FindObjectByObjectIDForUpdate(db *gorm.DB, objectID string) (Object, bool, error) {
+	if err := lockObjects(db, []string{ objectID }); err != nil {
+		return Object{}, false, err
+	}
+	return FindObjectByObjectID(db, objectID)
+}
+
 // find all objects contained in a list of object ids
 func FindAllObjectsByObjectID(db *gorm.DB, objects []string) ([]Object, error) {
 	result := []Object{}
 	return result, db.Preload("Service.Identity").Preload("Wallet.Identity").Where("object_id IN (?)", objects).Find(&result).Error
 }
+
+// find all objects contained in a list of object ids and lock them until the transaction ends
+func FindAllObjectsByObjectIDForUpdate(db *gorm.DB, objects []string) ([]Object, error) {
+	if err := lockObjects(db, objects); err != nil {
+		return []Object{}, err
+	}
+	return FindAllObjectsByObjectID(db, objects)
+}
+
+// lock the rows of objects with SELECT ... FOR UPDATE. Rows are locked in id
+// order so transactions locking some of the same objects cannot deadlock.
+// Only the objects are locked, not their wallets or services. sqlite
+// transactions already hold the database's write lock
+func lockObjects(db *gorm.DB, objects []string) (err error) {
+	if IsSQLite(db) || len(objects) == 0 {
+		return nil
+	}
+	stmt := "SELECT id FROM objects WHERE object_id IN (?) ORDER BY id FOR UPDATE"
+	span := tracing.StartRawSpan(db, stmt)
+	defer func() { tracing.EndSpan(span, err) }()
+	return db.Exec(stmt, objects).Error
+}
+
+// find the pins in a list of pins that are already used by objects.
+// pins are looked up in groups to keep the number of query parameters low
+func FindExistingObjectPins(db *gorm.DB, pins []string) ([]string, error) {
+	result := []string{}
+	for start := 0; start < len(pins); start += 1000 {
+		end := start + 1000
+		if end > len(pins) {
+			end = len(pins)
+		}
+		found := []string{}
+		if err := db.Model(Object{}).Where("pin IN (?)", pins[start:end]).Pluck("pin", &found).Error; err != nil {
+			return result, err
+		}
+		result = append(result, found...)
+	}
+	return result, nil
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+    "database/sql"
+    "github.com/ownode/tracing"
+    "fmt"
+    "strings"
+    "time"
+)
+
+var (
+	BatchPending = "pending"
+	BatchProcessing = "processing"
+	BatchCompleted = "completed"
+	BatchFailed = "failed"
+)
+
+// an asynchronous bulk object issuance job
+type ObjectBatch struct {
+	ID  uint `gorm:"primary_key" json:"-"`
+	ObjectID string `gorm:"object_id" json:"id" sql:"not null;unique"`
+	ServiceID  sql.NullInt64 `json:"-"`
+	Type string `json:"type"`
+	MaxUses int `json:"max_uses,omitempty"`
+	Status string `json:"status"`
+	TotalRows int `json:"total_rows"`
+	ProcessedRows int `json:"processed_rows"`
+	FailedRows int `json:"failed_rows"`
+	ObjectsCreated int `json:"objects_created"`
+	Error string `json:"error,omitempty"`
+	Rows string `json:"-" sql:"type:text"`
+	Base
+}
+
+// a row of a batch that could not be processed
+type ObjectBatchFailure struct {
+	ID  uint `gorm:"primary_key" json:"-" sql:"type:bigserial"`
+	BatchID sql.NullInt64 `json:"-"`
+	RowNumber int `json:"row"`
+	WalletID string `json:"wallet_id"`
+	Reason string `json:"reason"`
+	Base
+}
+
+// create a batch
+func CreateObjectBatch(db *gorm.DB, batch *ObjectBatch) error {
+	return db.Create(batch).Error
+}
+
+// create a batch failure
+func CreateObjectBatchFailure(db *gorm.DB, failure *ObjectBatchFailure) error {
+	return db.Create(failure).Error
+}
+
+// find a batch by object id
+func FindObjectBatchByObjectID(db *gorm.DB, id string) (ObjectBatch, bool, error) {
+	result := ObjectBatch{}
+	err := db.Where(&ObjectBatch{ ObjectID: id }).First(&result).Error
+	if err != nil {
+		if err == gorm.RecordNotFound {
+			return result, false, nil
+		}
+		return result, false, err
+	}
+	return result, true, nil
+}
+
+// find a batch by id
+func FindObjectBatchById(db *gorm.DB, id uint) (ObjectBatch, bool, error) {
+	result := ObjectBatch{}
+	err := db.Where(&ObjectBatch{ ID: id }).First(&result).Error
+	if err != nil {
+		if err == gorm.RecordNotFound {
+			return result, false, nil
+		}
+		return result, false, err
+	}
+	return result, true, nil
+}
+
+// find all batches that have not finished processing
+func FindUnfinishedObjectBatches(db *gorm.DB) ([]ObjectBatch, error) {
+	result := []ObjectBatch{}
+	return result, db.Where("status IN (?)", []string{ BatchPending, BatchProcessing }).Order("id asc").Find(&result).Error
+}
+
+// claim an unfinished batch for owner until lease passes. Succeeds when the
+// batch is not claimed, its claim expired or owner already holds it, so owners
+// renew their claim by claiming again. Returns false if another owner holds it
+func ClaimObjectBatch(db *gorm.DB, id uint, owner string, lease time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	result := db.Exec(`UPDATE object_batches SET lease_owner = ?, lease_expires_at = ?
+		WHERE id = ? AND status IN (?, ?) AND (lease_owner IS NULL OR lease_owner = ? OR lease_expires_at < ?)`,
+		owner, now.Add(lease), id, BatchPending, BatchProcessing, owner, now)
+	return result.RowsAffected == 1, result.Error
+}
+
+// release the claim of owner on a batch so another owner can claim it
+func ReleaseObjectBatch(db *gorm.DB, id uint, owner string) error {
+	return db.Exec(`UPDATE object_batches SET lease_owner = NULL, lease_expires_at = NULL WHERE id = ? AND lease_owner = ?`, id, owner).Error
+}
+
+// save the progress of a chunk of a batch processed by owner. Only succeeds
+// if owner still holds the claim and the batch progressed to from rows, so
+// a chunk is not saved twice when another owner took over the batch.
+// Returns false if it did not succeed
+func AdvanceObjectBatch(db *gorm.DB, batch ObjectBatch, owner string, from int) (bool, error) {
+	result := db.Exec(`UPDATE object_batches SET processed_rows = ?, failed_rows = ?, objects_created = ?, updated_at = ?
+		WHERE id = ? AND lease_owner = ? AND processed_rows = ?`,
+		batch.ProcessedRows, batch.FailedRows, batch.ObjectsCreated, time.Now().UTC(), batch.ID, owner, from)
+	return result.RowsAffected == 1, result.Error
+}
+
+// set the status of a batch claimed by owner. reason is the error of a failed batch.
+// Rows are no longer needed once a batch completes and are removed
+func SetObjectBatchStatus(db *gorm.DB, id uint, owner, status, reason string) error {
+	updates := map[string]interface{}{ "status": status, "error": reason, "updated_at": time.Now().UTC() }
+	if status == BatchCompleted {
+		updates["rows"] = ""
+	}
+	return db.Model(&ObjectBatch{}).Where("id = ? AND lease_owner = ?", id, owner).UpdateColumns(updates).Error
+}
+
+// find the failures of a batch
+func FindObjectBatchFailures(db *gorm.DB, batchID uint, limit int) ([]ObjectBatchFailure, error) {
+	result := []ObjectBatchFailure{}
+	return result, db.Where("batch_id = ?", batchID).Order("row_number asc").Limit(limit).Find(&result).Error
+}
+
+// insert many objects using multi row insert statements in transaction tx.
+// objects must have their wallet and service loaded. The ids of the
+// created rows are set on the objects. If a pin was taken by a concurrent
+// transaction, the statement is run again with pins from newPins, up to
+// attempts times in total
+func BulkCreateObjects(tx *gorm.DB, objects []Object, chunkSize, attempts int, newPins func(n int) ([]string, error)) error {
+	for start := 0; start < len(objects); start += chunkSize {
+		end := start + chunkSize
+		if end > len(objects) {
+			end = len(objects)
+		}
+		err := retryPinConflicts(tx, attempts, func(retry bool) error {
+			if retry {
+				pins, err := newPins(end - start)
+				if err != nil {
+					return err
+				}
+				for i := start; i < end; i++ {
+					objects[i].Pin = pins[i - start]
+				}
+			}
+			return insertObjects(tx, objects, start, end)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insert objects[start:end] with a single statement
+func insertObjects(db *gorm.DB, objects []Object, start, end int) (err error) {
+	placeholders := []string{}
+	args := []interface{}{}
+	indexes := map[string]int{}
+	for i := start; i < end; i++ {
+		obj := &objects[i]
+		obj.BeforeCreate()
+		indexes[obj.ObjectID] = i
+		placeholders = append(placeholders, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, obj.ObjectID, obj.Pin, obj.Type, obj.Wallet.ID, obj.Service.ID, obj.Balance, obj.Meta, obj.Open, obj.MaxUses, obj.Uses, obj.CreatedAt, obj.UpdatedAt)
+	}
+
+	// set the ids of the created objects
+	stmt := fmt.Sprintf("INSERT INTO objects (object_id, pin, type, wallet_id, service_id, balance, meta, open, max_uses, uses, created_at, updated_at) VALUES %s RETURNING object_id, id", strings.Join(placeholders, ", "))
+	span := tracing.StartRawSpan(db, stmt)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	rows, err := db.Raw(stmt, args...).Rows()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var objectID string
+		var id uint
+		if err := rows.Scan(&objectID, &id); err != nil {
+			rows.Close()
+			return err
+		}
+		objects[indexes[objectID]].ID = id
+	}
+	rows.Close()
+	return rows.Err()
+}
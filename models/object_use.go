@@ -0,0 +1,23 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+    "database/sql"
+)
+
+// a record of a single use/redemption of a valueless object (ticket)
+type ObjectUse struct {
+	ID  uint `gorm:"primary_key" json:"-" sql:"type:bigserial"`
+	ObjectID string `gorm:"object_id" json:"id" sql:"not null;unique"`
+	TicketID sql.NullInt64 `json:"-"`
+	ServiceID sql.NullInt64 `json:"-"`
+	WalletID sql.NullInt64 `json:"-"`
+	Meta Meta `gorm:"meta" json:"meta" sql:"type:jsonb"`
+	Base
+}
+
+// create an object use
+func CreateObjectUse(db *gorm.DB, use *ObjectUse) error {
+	return db.Create(use).Error
+}
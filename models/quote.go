@@ -0,0 +1,57 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+    "time"
+)
+
+// a conversion of an amount at a locked exchange rate. A quote is used once,
+// before it expires, to charge objects of the From currency for an amount of the To currency
+type Quote struct {
+	ID  uint `gorm:"primary_key" json:"-"`
+	ObjectID string `gorm:"object_id" json:"id" sql:"not null;unique"`
+	ServiceID uint `json:"-"`
+	FromCurrency string `json:"from"`
+	ToCurrency string `json:"to"`
+	Rate float64 `json:"rate"`
+	Amount float64 `json:"amount"`
+	SourceAmount float64 `json:"source_amount"`
+	RateTime time.Time `json:"rate_time"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Used bool `json:"used"`
+	Base
+}
+
+// check if a quote has expired
+func (q *Quote) Expired() bool {
+	return !time.Now().UTC().Before(q.ExpiresAt)
+}
+
+// create a quote
+func CreateQuote(db *gorm.DB, quote *Quote) error {
+	return db.Create(quote).Error
+}
+
+// find a quote by object id
+func FindQuoteByObjectID(db *gorm.DB, id string) (Quote, bool, error) {
+	result := Quote{}
+	err := db.Where(&Quote{ ObjectID: id }).First(&result).Error
+	if err != nil {
+		if err == gorm.RecordNotFound {
+			return result, false, nil
+		}
+		return result, false, err
+	}
+	return result, true, nil
+}
+
+// mark a quote as used. Returns false if the quote was already used
+func UseQuote(db *gorm.DB, quote *Quote) (bool, error) {
+	result := db.Model(&Quote{}).Where("id = ? AND used = ?", quote.ID, false).UpdateColumn("used", true)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	quote.Used = result.RowsAffected == 1
+	return quote.Used, nil
+}
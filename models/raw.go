@@ -0,0 +1,25 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/ownode/tracing"
+)
+
+// run a raw query in a span and scan its first row into dest.
+// dest is left unchanged if the query returns no rows
+func ScanRow(db *gorm.DB, dest []interface{}, stmt string, args ...interface{}) (err error) {
+	span := tracing.StartRawSpan(db, stmt)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	rows, err := db.Raw(stmt, args...).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,199 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+)
+
+// storage of identities
+type IdentityRepo interface {
+	Create(identity *Identity) error
+	Save(identity *Identity) error
+	FindByObjectID(id string) (Identity, bool, error)
+	FindByObjectName(name string) (Identity, bool, error)
+	AddToSoul(id string, amount float64) (Identity, error)
+}
+
+// storage of services. Services are returned with their identity
+type ServiceRepo interface {
+	Create(service *Service) error
+	FindByObjectID(id string) (Service, bool, error)
+	FindByClientID(clientID string) (Service, bool, error)
+	FindByID(id uint) (Service, bool, error)
+}
+
+// storage of wallets. Wallets are returned with their identity
+type WalletRepo interface {
+	Create(wallet *Wallet) error
+	Save(wallet *Wallet) error
+	FindByObjectID(id string) (Wallet, bool, error)
+	FindByHandle(handle string) (Wallet, bool, error)
+	FindAllByObjectID(ids []string) ([]Wallet, error)
+}
+
+// storage of objects. Objects are returned with their wallet and service
+type ObjectRepo interface {
+	Create(object *Object) error
+	Save(object *Object) error
+	Delete(object *Object) error
+	FindByObjectID(id string) (Object, bool, error)
+	FindByObjectIDOrPin(idOrPin string) (Object, bool, error)
+	FindAllByObjectID(ids []string) ([]Object, error)
+	FindExistingPins(pins []string) ([]string, error)
+}
+
+// storage of access tokens
+type TokenRepo interface {
+	Create(token *Token) error
+}
+
+// the repositories of a store. They cover creating and reading records, so
+// handlers that only do that can be tested without a database. Handlers that
+// lock rows, retry conflicting transactions, append events and webhooks in the
+// transaction of a change or run list and aggregate queries use the database
+type Repos interface {
+	Identities() IdentityRepo
+	Services() ServiceRepo
+	Wallets() WalletRepo
+	Objects() ObjectRepo
+	Tokens() TokenRepo
+
+	// run fn with repositories in a transaction. Changes are discarded if fn returns an error
+	Transaction(fn func(Repos) error) error
+}
+
+// repositories backed by the sql database, postgres or sqlite
+type sqlRepos struct {
+	db *gorm.DB
+}
+
+// create repositories backed by the sql database of a handle
+func NewSQLRepos(db *gorm.DB) Repos {
+	return sqlRepos{ db }
+}
+
+func (r sqlRepos) Identities() IdentityRepo { return sqlIdentities{ r.db } }
+func (r sqlRepos) Services() ServiceRepo { return sqlServices{ r.db } }
+func (r sqlRepos) Wallets() WalletRepo { return sqlWallets{ r.db } }
+func (r sqlRepos) Objects() ObjectRepo { return sqlObjects{ r.db } }
+func (r sqlRepos) Tokens() TokenRepo { return sqlTokens{ r.db } }
+
+func (r sqlRepos) Transaction(fn func(Repos) error) error {
+	tx := r.db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+	if err := fn(sqlRepos{ tx }); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+type sqlIdentities struct {
+	db *gorm.DB
+}
+
+func (r sqlIdentities) Create(identity *Identity) error {
+	return CreateIdentity(r.db, identity)
+}
+
+func (r sqlIdentities) Save(identity *Identity) error {
+	return r.db.Save(identity).Error
+}
+
+func (r sqlIdentities) FindByObjectID(id string) (Identity, bool, error) {
+	return FindIdentityByObjectID(r.db, id)
+}
+
+func (r sqlIdentities) FindByObjectName(name string) (Identity, bool, error) {
+	return FindIdentityByObjectName(r.db, name)
+}
+
+func (r sqlIdentities) AddToSoul(id string, amount float64) (Identity, error) {
+	return AddToSoulByObjectID(r.db, id, amount)
+}
+
+type sqlServices struct {
+	db *gorm.DB
+}
+
+func (r sqlServices) Create(service *Service) error {
+	return CreateService(r.db, service)
+}
+
+func (r sqlServices) FindByObjectID(id string) (Service, bool, error) {
+	return FindServiceByObjectID(r.db, id)
+}
+
+func (r sqlServices) FindByClientID(clientID string) (Service, bool, error) {
+	return FindServiceByClientId(r.db, clientID)
+}
+
+func (r sqlServices) FindByID(id uint) (Service, bool, error) {
+	return FindServiceById(r.db, id)
+}
+
+type sqlWallets struct {
+	db *gorm.DB
+}
+
+func (r sqlWallets) Create(wallet *Wallet) error {
+	return CreateWallet(r.db, wallet)
+}
+
+func (r sqlWallets) Save(wallet *Wallet) error {
+	return r.db.Save(wallet).Error
+}
+
+func (r sqlWallets) FindByObjectID(id string) (Wallet, bool, error) {
+	return FindWalletByObjectID(r.db, id)
+}
+
+func (r sqlWallets) FindByHandle(handle string) (Wallet, bool, error) {
+	return FindWalletByHandle(r.db, handle)
+}
+
+func (r sqlWallets) FindAllByObjectID(ids []string) ([]Wallet, error) {
+	return FindAllWalletsByObjectID(r.db, ids)
+}
+
+type sqlObjects struct {
+	db *gorm.DB
+}
+
+func (r sqlObjects) Create(object *Object) error {
+	return CreateObject(r.db, object)
+}
+
+func (r sqlObjects) Save(object *Object) error {
+	return r.db.Save(object).Error
+}
+
+func (r sqlObjects) Delete(object *Object) error {
+	return r.db.Delete(object).Error
+}
+
+func (r sqlObjects) FindByObjectID(id string) (Object, bool, error) {
+	return FindObjectByObjectID(r.db, id)
+}
+
+func (r sqlObjects) FindByObjectIDOrPin(idOrPin string) (Object, bool, error) {
+	return FindObjectByObjectIDOrPin(r.db, idOrPin)
+}
+
+func (r sqlObjects) FindAllByObjectID(ids []string) ([]Object, error) {
+	return FindAllObjectsByObjectID(r.db, ids)
+}
+
+func (r sqlObjects) FindExistingPins(pins []string) ([]string, error) {
+	return FindExistingObjectPins(r.db, pins)
+}
+
+type sqlTokens struct {
+	db *gorm.DB
+}
+
+func (r sqlTokens) Create(token *Token) error {
+	return CreateToken(r.db, token)
+}
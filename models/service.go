@@ -13,8 +13,8 @@ type Service struct {
 	IdentityID  sql.NullInt64 `json:"-"`
 	ObjectID string `gorm:"object_id" json:"id" sql:"not null;unique"`
 	Description string `json:"description"`
-	ClientID string	`bson:"client_id" json:"-" sql:"not null;unique"`
-	ClientSecret string	`bson:"client_secret" json:"-"`
+	ClientID string	`json:"-" sql:"not null;unique"`
+	ClientSecret string	`json:"-"`
     Base
 }
 
@@ -49,3 +49,22 @@ func FindServiceByObjectID(db *gorm.DB, id string) (Service, bool, error) {
 	}
 	return result, true, nil
 }
+
+// find a service by id
+func FindServiceById(db *gorm.DB, id uint) (Service, bool, error) {
+	result := Service{}
+	err := db.Preload("Identity").Where(&Service{ ID: id }).First(&result).Error
+	if err != nil {
+		if err == gorm.RecordNotFound {
+			return result, false, nil
+		} 
+		return result, false, err
+	}
+	return result, true, nil
+}
+
+// find the services of an identity
+func FindServicesByIdentityID(db *gorm.DB, identityID uint) ([]Service, error) {
+	result := []Service{}
+	return result, db.Where("identity_id = ?", identityID).Find(&result).Error
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// maximum number of objects a ledger tracks. Replaying events into a ledger
+// that would track more fails with ErrLedgerTooLarge
+var MaxLedgerObjects = 100000
+
+// returned when a ledger would track more than MaxLedgerObjects objects
+var ErrLedgerTooLarge = errors.New("wallet has too many objects")
+
+// a change of the balance of a wallet's value objects caused by an event
+type Movement struct {
+	Sequence int64
+	Event string
+	CreatedAt time.Time
+	ObjectID string
+	ServiceID int64
+	Amount float64
+}
+
+type ledgerObject struct {
+	serviceID int64
+	balance float64
+	value bool
+}
+
+// numbers of a wallet's objects of a service
+type LedgerStats struct {
+	ObjectCount int64
+	ValueObjectCount int64
+	ValuelessObjectCount int64
+	Balance float64
+}
+
+// the objects of a wallet rebuilt by replaying events.
+// Used to derive the movements and balances of a wallet at any point in time
+type WalletLedger struct {
+	walletID int64
+	balancesOnly bool
+	objects map[string]ledgerObject
+}
+
+// create an empty ledger of a wallet
+func NewWalletLedger(walletID int64) *WalletLedger {
+	return &WalletLedger{ walletID: walletID, objects: map[string]ledgerObject{} }
+}
+
+// create an empty ledger of a wallet's balances and movements. It only tracks
+// value objects with a balance, as other objects cannot move the balance.
+// Objects are dropped once their balance is consumed, an object the ledger
+// does not track has no balance. Stats of the ledger leave out valueless
+// and empty objects
+func NewWalletBalanceLedger(walletID int64) *WalletLedger {
+	return &WalletLedger{ walletID: walletID, balancesOnly: true, objects: map[string]ledgerObject{} }
+}
+
+// apply an event and return the movements it caused on the wallet.
+// Events must be applied in sequence order
+func (l *WalletLedger) Apply(event Event) ([]Movement, error) {
+	change, err := event.Change()
+	if err != nil {
+		return nil, err
+	}
+
+	movements := []Movement{}
+	move := func(objectID string, serviceID int64, amount float64) {
+		if amount != 0 {
+			movements = append(movements, Movement{ event.Sequence, event.Type, event.CreatedAt, objectID, serviceID, amount })
+		}
+	}
+
+	for _, objectID := range change.Deleted {
+		if prev, ok := l.objects[objectID]; ok {
+			move(objectID, prev.serviceID, -prev.balance)
+			delete(l.objects, objectID)
+		}
+	}
+
+	// only value objects move the balance
+	for _, state := range change.Objects {
+		prev, had := l.objects[state.ObjectID]
+		value := state.Type == ObjectValue
+		balance := 0.0
+		if value {
+			balance = state.Balance
+		}
+
+		switch {
+		case state.WalletID == l.walletID:
+			move(state.ObjectID, state.ServiceID, balance - prev.balance)
+			if l.balancesOnly && balance == 0 {
+				delete(l.objects, state.ObjectID)
+				continue
+			}
+			if !had && len(l.objects) >= MaxLedgerObjects {
+				return nil, ErrLedgerTooLarge
+			}
+			l.objects[state.ObjectID] = ledgerObject{ state.ServiceID, balance, value }
+		case had:
+			move(state.ObjectID, prev.serviceID, -prev.balance)
+			delete(l.objects, state.ObjectID)
+		}
+	}
+
+	return movements, nil
+}
+
+// balance of the wallet's value objects per service
+func (l *WalletLedger) Balances() map[int64]float64 {
+	balances := map[int64]float64{}
+	for _, object := range l.objects {
+		if object.value {
+			balances[object.serviceID] += object.balance
+		}
+	}
+	return balances
+}
+
+// numbers of the wallet's objects per service
+func (l *WalletLedger) Stats() map[int64]LedgerStats {
+	stats := map[int64]LedgerStats{}
+	for _, object := range l.objects {
+		s := stats[object.serviceID]
+		s.ObjectCount++
+		if object.value {
+			s.ValueObjectCount++
+			s.Balance += object.balance
+		} else {
+			s.ValuelessObjectCount++
+		}
+		stats[object.serviceID] = s
+	}
+	return stats
+}
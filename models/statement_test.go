@@ -0,0 +1,97 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func testEvent(sequence int64, eventType string, change EventChange) Event {
+	data, _ := json.Marshal(change)
+	return Event{ Sequence: sequence, Type: eventType, Data: Meta(data) }
+}
+
+func TestWalletLedgerMovements(t *testing.T) {
+	assert := assert.New(t)
+	ledger := NewWalletLedger(1)
+
+	// two objects issued into the wallet and one into another wallet
+	movements, err := ledger.Apply(testEvent(1, EventObjectCreated, EventChange{ Objects: []ObjectState{
+		{ ObjectID: "a", Type: ObjectValue, WalletID: 1, ServiceID: 7, Balance: 10 },
+		{ ObjectID: "b", Type: ObjectValue, WalletID: 1, ServiceID: 7, Balance: 5 },
+		{ ObjectID: "c", Type: ObjectValue, WalletID: 2, ServiceID: 7, Balance: 3 },
+	}}))
+	assert.Nil(err)
+	assert.Equal(2, len(movements))
+	assert.Equal(map[int64]float64{ 7: 15 }, ledger.Balances())
+
+	// a is charged, b is partially charged and a new object is created in the charging wallet
+	movements, err = ledger.Apply(testEvent(2, EventObjectCharged, EventChange{
+		Objects: []ObjectState{
+			{ ObjectID: "d", Type: ObjectValue, WalletID: 2, ServiceID: 7, Balance: 12 },
+			{ ObjectID: "b", Type: ObjectValue, WalletID: 1, ServiceID: 7, Balance: 3 },
+		},
+		Deleted: []string{ "a" },
+	}))
+	assert.Nil(err)
+	assert.Equal(2, len(movements))
+	assert.Equal(-10.0, movements[0].Amount)
+	assert.Equal(-2.0, movements[1].Amount)
+	assert.Equal(map[int64]float64{ 7: 3 }, ledger.Balances())
+
+	// valueless objects and unchanged balances do not move the wallet
+	movements, err = ledger.Apply(testEvent(3, EventObjectOpened, EventChange{ Objects: []ObjectState{
+		{ ObjectID: "b", Type: ObjectValue, WalletID: 1, ServiceID: 7, Balance: 3, Open: true },
+		{ ObjectID: "t", Type: ObjectValueless, WalletID: 1, ServiceID: 7 },
+	}}))
+	assert.Nil(err)
+	assert.Equal(0, len(movements))
+	assert.Equal(map[int64]float64{ 7: 3 }, ledger.Balances())
+	assert.Equal(LedgerStats{ ObjectCount: 2, ValueObjectCount: 1, ValuelessObjectCount: 1, Balance: 3 }, ledger.Stats()[7])
+}
+
+func TestWalletLedgerDropsEmptyObjects(t *testing.T) {
+	assert := assert.New(t)
+	ledger := NewWalletBalanceLedger(1)
+	stats := NewWalletLedger(1)
+	apply := func(event Event) []Movement {
+		movements, err := ledger.Apply(event)
+		assert.Nil(err)
+		_, err = stats.Apply(event)
+		assert.Nil(err)
+		return movements
+	}
+
+	apply(testEvent(1, EventObjectCreated, EventChange{ Objects: []ObjectState{
+		{ ObjectID: "a", Type: ObjectValue, WalletID: 1, ServiceID: 7, Balance: 10 },
+		{ ObjectID: "t", Type: ObjectValueless, WalletID: 1, ServiceID: 7 },
+	}}))
+	assert.Equal(1, len(ledger.objects))
+	assert.Equal(LedgerStats{ ObjectCount: 2, ValueObjectCount: 1, ValuelessObjectCount: 1, Balance: 10 }, stats.Stats()[7])
+
+	// an emptied object is dropped and moves the balance again when it is refilled
+	movements := apply(testEvent(2, EventObjectSubtracted, EventChange{ Objects: []ObjectState{
+		{ ObjectID: "a", Type: ObjectValue, WalletID: 1, ServiceID: 7, Balance: 0 },
+	}}))
+	assert.Equal(-10.0, movements[0].Amount)
+	assert.Equal(0, len(ledger.objects))
+	movements = apply(testEvent(3, EventObjectMerged, EventChange{ Objects: []ObjectState{
+		{ ObjectID: "a", Type: ObjectValue, WalletID: 1, ServiceID: 7, Balance: 4 },
+	}}))
+	assert.Equal(4.0, movements[0].Amount)
+	assert.Equal(map[int64]float64{ 7: 4 }, ledger.Balances())
+	assert.Equal(stats.Balances(), ledger.Balances())
+}
+
+func TestWalletLedgerTooLarge(t *testing.T) {
+	assert := assert.New(t)
+	defer func(max int) { MaxLedgerObjects = max }(MaxLedgerObjects)
+	MaxLedgerObjects = 1
+
+	ledger := NewWalletBalanceLedger(1)
+	_, err := ledger.Apply(testEvent(1, EventObjectCreated, EventChange{ Objects: []ObjectState{
+		{ ObjectID: "a", Type: ObjectValue, WalletID: 1, ServiceID: 7, Balance: 10 },
+		{ ObjectID: "b", Type: ObjectValue, WalletID: 1, ServiceID: 7, Balance: 5 },
+	}}))
+	assert.Equal(ErrLedgerTooLarge, err)
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/ownode/metrics"
+	"github.com/ownode/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// postgres error codes of transactions that failed because of a concurrent
+// transaction. They are likely to succeed when run again
+const (
+	serializationFailure = "40001"
+	deadlockDetected = "40P01"
+)
+
+// postgres error code of a unique constraint violation and the constraint on object pins
+const (
+	uniqueViolation = "23505"
+	objectPinConstraint = "objects_pin_key"
+)
+
+// number of times a conflicting transaction is run and the delay before the
+// first retry. The delay doubles on every retry up to MaxTransactionBackoff
+var (
+	TransactionAttempts = 10
+	TransactionBackoff = 5 * time.Millisecond
+	MaxTransactionBackoff = 500 * time.Millisecond
+)
+
+// check if an error is a serialization failure or a deadlock
+func IsRetryableError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == serializationFailure || pqErr.Code == deadlockDetected
+}
+
+// check if an error is a violation of the unique constraint on object pins.
+// Pins are checked before they are used, but two transactions can draw the
+// same pin before either inserts it
+func IsPinConflict(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == uniqueViolation && pqErr.Constraint == objectPinConstraint
+	}
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed: objects.pin")
+}
+
+// run fn in a savepoint of transaction tx. When fn fails with a pin conflict,
+// the savepoint is rolled back and fn runs again with retry set, up to attempts
+// times. Rolling back to the savepoint keeps the transaction usable
+func retryPinConflicts(tx *gorm.DB, attempts int, fn func(retry bool) error) error {
+	for attempt := 1; ; attempt++ {
+		if err := tx.Exec("SAVEPOINT object_pin").Error; err != nil {
+			return err
+		}
+		err := fn(attempt > 1)
+		if err == nil {
+			return tx.Exec("RELEASE SAVEPOINT object_pin").Error
+		} else if !IsPinConflict(err) || attempt >= attempts {
+			return err
+		}
+		if err := tx.Exec("ROLLBACK TO SAVEPOINT object_pin").Error; err != nil {
+			return err
+		}
+	}
+}
+
+// begin a transaction with isolation set to repeatable read. sqlite
+// transactions hold the write lock from the start, which is at least as strict
+func BeginRepeatableRead(db *gorm.DB) (*gorm.DB, error) {
+	tx := db.Begin()
+	if tx.Error != nil || IsSQLite(tx) {
+		return tx, tx.Error
+	}
+	if err := tx.Exec(`set transaction isolation level repeatable read`).Error; err != nil {
+		tx.Rollback()
+		return tx, err
+	}
+	return tx, nil
+}
+
+// run fn in a repeatable read transaction. The transaction is committed if fn
+// returns nil and rolled back otherwise. When it fails because of a concurrent
+// transaction, it is run again after a random delay. fn must not keep state
+// between runs
+func RepeatableReadTransaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	backoff := TransactionBackoff
+	for attempt := 1; ; attempt++ {
+		err := repeatableReadTransaction(db, fn, attempt)
+		if err == nil || !IsRetryableError(err) {
+			return err
+		} else if attempt >= TransactionAttempts {
+			metrics.TransactionConflicts.Inc()
+			return err
+		}
+		metrics.TransactionRetries.Inc()
+
+		// jitter keeps transactions that conflicted from conflicting again
+		time.Sleep(backoff / 2 + time.Duration(rand.Int63n(int64(backoff))))
+		if backoff *= 2; backoff > MaxTransactionBackoff {
+			backoff = MaxTransactionBackoff
+		}
+	}
+}
+
+// run an attempt of a transaction. Its queries are traced in a span of the attempt
+func repeatableReadTransaction(db *gorm.DB, fn func(tx *gorm.DB) error, attempt int) (err error) {
+	db, span := tracing.StartDBSpan(db, "transaction", attribute.Int("db.transaction.attempt", attempt))
+	defer func() { tracing.EndSpan(span, err) }()
+
+	tx, err := BeginRepeatableRead(db)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
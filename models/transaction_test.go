@@ -0,0 +1,28 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(IsRetryableError(&pq.Error{ Code: "40001" }))
+	assert.True(IsRetryableError(&pq.Error{ Code: "40P01" }))
+	assert.True(IsRetryableError(fmt.Errorf("charge: %w", &pq.Error{ Code: "40001" })))
+	assert.False(IsRetryableError(&pq.Error{ Code: "23505" }))
+	assert.False(IsRetryableError(errors.New("40001")))
+	assert.False(IsRetryableError(nil))
+}
+
+func TestIsPinConflict(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(IsPinConflict(&pq.Error{ Code: "23505", Constraint: "objects_pin_key" }))
+	assert.True(IsPinConflict(errors.New("UNIQUE constraint failed: objects.pin")))
+	assert.False(IsPinConflict(&pq.Error{ Code: "23505", Constraint: "objects_object_id_key" }))
+	assert.False(IsPinConflict(errors.New("UNIQUE constraint failed: objects.object_id")))
+	assert.False(IsPinConflict(nil))
+}
@@ -5,6 +5,7 @@ import (
     _ "github.com/lib/pq"
     "database/sql"
     // "github.com/ownode/services"
+    "github.com/ownode/tracing"
 )
 
 type Wallet struct {
@@ -50,3 +51,25 @@ func FindWalletByObjectID(db *gorm.DB, objectID string) (Wallet, bool, error) {
 	
 	return result, true, nil
 }
+
+// find all wallets contained in a list of object ids
+func FindAllWalletsByObjectID(db *gorm.DB, objectIDs []string) ([]Wallet, error) {
+	result := []Wallet{}
+	return result, db.Preload("Identity").Where("object_id IN (?)", objectIDs).Find(&result).Error
+}
+
+// find all wallets contained in a list of object ids and lock them until the
+// transaction ends, so they are not locked or changed while objects move to them.
+// Rows are locked in id order. sqlite transactions already hold the write lock
+func FindAllWalletsByObjectIDForUpdate(db *gorm.DB, objectIDs []string) ([]Wallet, error) {
+	if !IsSQLite(db) && len(objectIDs) > 0 {
+		stmt := "SELECT id FROM wallets WHERE object_id IN (?) ORDER BY id FOR UPDATE"
+		span := tracing.StartRawSpan(db, stmt)
+		err := db.Exec(stmt, objectIDs).Error
+		tracing.EndSpan(span, err)
+		if err != nil {
+			return []Wallet{}, err
+		}
+	}
+	return FindAllWalletsByObjectID(db, objectIDs)
+}
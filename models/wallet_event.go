@@ -0,0 +1,148 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+    "database/sql"
+    "github.com/ownode/tracing"
+    "errors"
+    "strconv"
+    "strings"
+)
+
+// postgres channel new wallet events are announced on.
+// the notification payload is the id of the wallet event
+var WalletEventChannel = "wallet_events"
+
+// an entry in the activity ledger of a wallet.
+// Ids are assigned when events are inserted and transactions may commit in
+// another order, so streams resume from the cursor of an event instead
+type WalletEvent struct {
+	ID  int64 `gorm:"primary_key" json:"-" sql:"type:bigserial"`
+	TxID int64 `json:"-"`
+	WalletID  sql.NullInt64 `json:"-" sql:"index"`
+	Event string `json:"event"`
+	Data Meta `json:"data" sql:"type:jsonb"`
+	Base
+}
+
+// event bus topic of a wallet's events
+func WalletEventTopic(walletID uint) string {
+	return "wallet:" + strconv.FormatUint(uint64(walletID), 10)
+}
+
+// position of an event in the ledger of a wallet. Events are ordered by the
+// transaction that created them, then by id. Events are only read once every
+// transaction that began before theirs has ended, so no event can later
+// appear before a cursor that has been sent. On sqlite, where transactions
+// commit one at a time, the transaction is zero and events are ordered by id
+type WalletEventCursor struct {
+	TxID int64
+	ID int64
+}
+
+// the cursor of an event
+func (e WalletEvent) Cursor() WalletEventCursor {
+	return WalletEventCursor{ TxID: e.TxID, ID: e.ID }
+}
+
+// format a cursor as `<transaction>-<id>`, or as the id when the transaction is zero
+func (c WalletEventCursor) String() string {
+	if c.TxID == 0 {
+		return strconv.FormatInt(c.ID, 10)
+	}
+	return strconv.FormatInt(c.TxID, 10) + "-" + strconv.FormatInt(c.ID, 10)
+}
+
+// parse a cursor formatted by String. A bare id is the id of an event
+// and must be resolved with FindWalletEventCursor
+func ParseWalletEventCursor(str string) (WalletEventCursor, bool, error) {
+	cursor := WalletEventCursor{}
+	parts := strings.SplitN(str, "-", 2)
+	var err error
+	if cursor.ID, err = strconv.ParseInt(parts[len(parts) - 1], 10, 64); err != nil || cursor.ID < 0 {
+		return cursor, false, errors.New("invalid event cursor")
+	}
+	if len(parts) == 1 {
+		return cursor, false, nil
+	}
+	if cursor.TxID, err = strconv.ParseInt(parts[0], 10, 64); err != nil || cursor.TxID < 0 {
+		return cursor, false, errors.New("invalid event cursor")
+	}
+	return cursor, true, nil
+}
+
+// the cursor of a wallet's event by id. Ids of events that do not exist
+// are kept with a zero transaction, which places them before newer events
+func FindWalletEventCursor(db *gorm.DB, walletID uint, id int64) (WalletEventCursor, error) {
+	event := WalletEvent{}
+	err := db.Where("wallet_id = ? AND id = ?", walletID, id).First(&event).Error
+	if err == gorm.RecordNotFound {
+		return WalletEventCursor{ ID: id }, nil
+	}
+	return event.Cursor(), err
+}
+
+// create a wallet event and announce it to listeners.
+// The announcement is only sent when the transaction commits.
+// sqlite has no notifications, listeners poll for new events instead
+func CreateWalletEvent(db *gorm.DB, event *WalletEvent) error {
+	if err := db.Create(event).Error; err != nil || IsSQLite(db) {
+		return err
+	}
+
+	// record the transaction of the event
+	err := ScanRow(db, []interface{}{ &event.TxID }, "UPDATE wallet_events SET tx_id = txid_current() WHERE id = ? RETURNING tx_id", event.ID)
+	if err != nil {
+		return err
+	}
+
+	stmt := "SELECT pg_notify(?, ?)"
+	span := tracing.StartRawSpan(db, stmt)
+	err = db.Exec(stmt, WalletEventChannel, strconv.FormatInt(event.ID, 10)).Error
+	tracing.EndSpan(span, err)
+	return err
+}
+
+// find a wallet event by id
+func FindWalletEventById(db *gorm.DB, id int64) (WalletEvent, bool, error) {
+	result := WalletEvent{}
+	err := db.Where("id = ?", id).First(&result).Error
+	if err != nil {
+		if err == gorm.RecordNotFound {
+			return result, false, nil
+		}
+		return result, false, err
+	}
+	return result, true, nil
+}
+
+// find events of a wallet after a cursor, oldest first. On postgres, events of
+// transactions that began after the oldest running transaction are left out
+// until it ends, as it may still create events that come before them
+func FindWalletEventsAfter(db *gorm.DB, walletID uint, after WalletEventCursor, limit int) ([]WalletEvent, error) {
+	result := []WalletEvent{}
+	query := db.Where("wallet_id = ? AND (tx_id > ? OR (tx_id = ? AND id > ?))", walletID, after.TxID, after.TxID, after.ID)
+	if !IsSQLite(db) {
+		query = query.Where("tx_id < txid_snapshot_xmin(txid_current_snapshot())")
+	}
+	return result, query.Order("tx_id asc, id asc").Limit(limit).Find(&result).Error
+}
+
+// find events of all wallets created after an event id, oldest first
+func FindAllWalletEventsAfter(db *gorm.DB, afterID int64, limit int) ([]WalletEvent, error) {
+	result := []WalletEvent{}
+	return result, db.Where("id > ?", afterID).Order("id asc").Limit(limit).Find(&result).Error
+}
+
+// id of the most recent wallet event. zero if there are none
+func LastWalletEventID(db *gorm.DB) (int64, error) {
+	var ids []int64
+	if err := db.Model(&WalletEvent{}).Order("id desc").Limit(1).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return ids[0], nil
+}
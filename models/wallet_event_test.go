@@ -0,0 +1,26 @@
+package models
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalletEventCursor(t *testing.T) {
+	assert := assert.New(t)
+
+	cursor, complete, err := ParseWalletEventCursor("1042-7")
+	assert.Nil(err)
+	assert.True(complete)
+	assert.Equal(WalletEventCursor{ TxID: 1042, ID: 7 }, cursor)
+	assert.Equal("1042-7", cursor.String())
+
+	cursor, complete, err = ParseWalletEventCursor("7")
+	assert.Nil(err)
+	assert.False(complete)
+	assert.Equal("7", cursor.String())
+
+	for _, invalid := range []string{ "", "-7", "a-7", "7-", "1-2-3" } {
+		_, _, err = ParseWalletEventCursor(invalid)
+		assert.NotNil(err, invalid)
+	}
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+    _ "github.com/lib/pq"
+    "database/sql"
+    "strings"
+    "time"
+)
+
+var (
+	DeliveryPending = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead = "dead"
+)
+
+// a url registered by a service to receive events
+type WebhookEndpoint struct {
+	ID  uint `gorm:"primary_key" json:"-"`
+	ObjectID string `gorm:"object_id" json:"id" sql:"not null;unique"`
+	ServiceID  sql.NullInt64 `json:"-"`
+	URL string `json:"url" sql:"not null"`
+	Secret string `json:"-"`
+	Events string `json:"-"`
+	Active bool `json:"active"`
+	Base
+}
+
+// list of events the endpoint is subscribed to. empty means all events
+func (e *WebhookEndpoint) EventList() []string {
+	if e.Events == "" {
+		return []string{}
+	}
+	return strings.Split(e.Events, ",")
+}
+
+// check if the endpoint is subscribed to an event
+func (e *WebhookEndpoint) Subscribed(event string) bool {
+	if e.Events == "" {
+		return true
+	}
+	for _, ev := range e.EventList() {
+		if ev == event {
+			return true
+		}
+	}
+	return false
+}
+
+// an event waiting to be or already delivered to a webhook endpoint (outbox).
+// deliveries are created in the same transaction as the mutation that caused the event
+type WebhookDelivery struct {
+	ID  uint `gorm:"primary_key" json:"-" sql:"type:bigserial"`
+	ObjectID string `gorm:"object_id" json:"id" sql:"not null;unique"`
+	EndpointID  sql.NullInt64 `json:"-"`
+	EventID string `json:"event_id"`
+	Event string `json:"event"`
+	Payload string `json:"-" sql:"type:text"`
+	Status string `json:"status"`
+	Attempts int `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError string `json:"last_error,omitempty"`
+	Base
+}
+
+// create a webhook endpoint
+func CreateWebhookEndpoint(db *gorm.DB, endpoint *WebhookEndpoint) error {
+	return db.Create(endpoint).Error
+}
+
+// find a webhook endpoint by object id
+func FindWebhookEndpointByObjectID(db *gorm.DB, id string) (WebhookEndpoint, bool, error) {
+	result := WebhookEndpoint{}
+	err := db.Where(&WebhookEndpoint{ ObjectID: id }).First(&result).Error
+	if err != nil {
+		if err == gorm.RecordNotFound {
+			return result, false, nil
+		}
+		return result, false, err
+	}
+	return result, true, nil
+}
+
+// find a webhook endpoint by id
+func FindWebhookEndpointById(db *gorm.DB, id uint) (WebhookEndpoint, bool, error) {
+	result := WebhookEndpoint{}
+	err := db.Where(&WebhookEndpoint{ ID: id }).First(&result).Error
+	if err != nil {
+		if err == gorm.RecordNotFound {
+			return result, false, nil
+		}
+		return result, false, err
+	}
+	return result, true, nil
+}
+
+// find the webhook endpoints of a service
+func FindWebhookEndpointsByServiceID(db *gorm.DB, serviceID uint) ([]WebhookEndpoint, error) {
+	result := []WebhookEndpoint{}
+	return result, db.Where("service_id = ?", serviceID).Order("id asc").Find(&result).Error
+}
+
+// find the active webhook endpoints of a list of services
+func FindActiveWebhookEndpointsByServiceIDs(db *gorm.DB, serviceIDs []uint) ([]WebhookEndpoint, error) {
+	result := []WebhookEndpoint{}
+	if len(serviceIDs) == 0 {
+		return result, nil
+	}
+	return result, db.Where("service_id IN (?) AND active = ?", serviceIDs, true).Find(&result).Error
+}
+
+// create a webhook delivery
+func CreateWebhookDelivery(db *gorm.DB, delivery *WebhookDelivery) error {
+	return db.Create(delivery).Error
+}
+
+// find a webhook delivery by object id
+func FindWebhookDeliveryByObjectID(db *gorm.DB, id string) (WebhookDelivery, bool, error) {
+	result := WebhookDelivery{}
+	err := db.Where(&WebhookDelivery{ ObjectID: id }).First(&result).Error
+	if err != nil {
+		if err == gorm.RecordNotFound {
+			return result, false, nil
+		}
+		return result, false, err
+	}
+	return result, true, nil
+}
+
+// find the deliveries of an endpoint, most recent first. status is optional
+func FindWebhookDeliveriesByEndpointID(db *gorm.DB, endpointID uint, status string, limit int) ([]WebhookDelivery, error) {
+	result := []WebhookDelivery{}
+	q := db.Where("endpoint_id = ?", endpointID)
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	return result, q.Order("id desc").Limit(limit).Find(&result).Error
+}
+
+// find pending deliveries that are due for an attempt and lock them.
+// locked rows are skipped so multiple workers can deliver concurrently.
+// must be called in a transaction
+func FindDueWebhookDeliveries(db *gorm.DB, now time.Time, limit int) ([]WebhookDelivery, error) {
+	result := []WebhookDelivery{}
+
+	// sqlite has no row locks. its transactions already exclude each other
+	if !IsSQLite(db) {
+		db = db.Set("gorm:query_option", "FOR UPDATE SKIP LOCKED")
+	}
+	return result, db.Where("status = ? AND next_attempt_at <= ?", DeliveryPending, now).Order("id asc").Limit(limit).Find(&result).Error
+}
+
+// move the next attempt of deliveries to until, so they are not due while they are
+// being delivered. must be called in the transaction that found and locked them
+func ClaimWebhookDeliveries(db *gorm.DB, ids []uint, until time.Time) error {
+	return db.Exec("UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id IN (?)", until, ids).Error
+}
@@ -0,0 +1,102 @@
+// Projections build read models from the events table.
+// The objects projection replays object events in sequence order
+// and reproduces the objects table
+package projections
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/ownode/models"
+)
+
+// number of events read per query during a rebuild
+var RebuildPageSize = 1000
+
+// apply an event to the objects table. Events that do not change objects are ignored
+func ApplyObjectEvent(db *gorm.DB, event models.Event) error {
+	change, err := event.Change()
+	if err != nil {
+		return err
+	}
+
+	for _, objectID := range change.Deleted {
+		if err := db.Exec("DELETE FROM objects WHERE object_id = ?", objectID).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, state := range change.Objects {
+		if err := upsertObject(db, state.Object()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write an object row exactly as recorded, keeping its id and timestamps
+func upsertObject(db *gorm.DB, o models.Object) error {
+	update := db.Exec(`UPDATE objects SET pin = ?, type = ?, wallet_id = ?, service_id = ?, balance = ?, meta = ?, open = ?, open_method = ?,
+		open_time = ?, open_pin = ?, max_uses = ?, uses = ?, created_at = ?, updated_at = ? WHERE object_id = ?`,
+		o.Pin, o.Type, o.WalletID, o.ServiceID, o.Balance, o.Meta, o.Open, o.OpenMethod, o.OpenTime, o.OpenPin, o.MaxUses, o.Uses, o.CreatedAt, o.UpdatedAt, o.ObjectID)
+	if update.Error != nil || update.RowsAffected > 0 {
+		return update.Error
+	}
+
+	return db.Exec(`INSERT INTO objects (id, object_id, pin, type, wallet_id, service_id, balance, meta, open, open_method, open_time, open_pin, max_uses, uses, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		o.ID, o.ObjectID, o.Pin, o.Type, o.WalletID, o.ServiceID, o.Balance, o.Meta, o.Open, o.OpenMethod, o.OpenTime, o.OpenPin, o.MaxUses, o.Uses, o.CreatedAt, o.UpdatedAt).Error
+}
+
+// truncate the objects table and rebuild it by replaying all events.
+// The objects table is locked for the duration of the rebuild.
+// Returns the number of events replayed
+func RebuildObjects(db *gorm.DB) (int, error) {
+	tx := db.Begin()
+
+	// sqlite transactions hold the database write lock, the objects table needs no lock
+	if !models.IsSQLite(tx) {
+		if err := tx.Exec("LOCK TABLE objects IN ACCESS EXCLUSIVE MODE").Error; err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if err := tx.Exec("DELETE FROM objects").Error; err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	replayed := 0
+	sequence := int64(0)
+	for {
+		events, err := models.FindEventsAfter(tx, sequence, RebuildPageSize)
+		if err != nil {
+			tx.Rollback()
+			return replayed, err
+		}
+
+		for _, event := range events {
+			if err := ApplyObjectEvent(tx, event); err != nil {
+				tx.Rollback()
+				return replayed, err
+			}
+			sequence = event.Sequence
+			replayed++
+		}
+
+		if len(events) < RebuildPageSize {
+			break
+		}
+	}
+
+	// continue the id sequence after the replayed objects.
+	// sqlite continues after the largest id by itself
+	if !models.IsSQLite(tx) {
+		if err := tx.Exec("SELECT setval('objects_id_seq', COALESCE((SELECT MAX(id) FROM objects), 0) + 1, false)").Error; err != nil {
+			tx.Rollback()
+			return replayed, err
+		}
+	}
+
+	return replayed, tx.Commit().Error
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	value interface{}
+	expires time.Time
+}
+
+// in-memory cache of values that expire after a ttl
+type TTLCache struct {
+	mu sync.Mutex
+	ttl time.Duration
+	entries map[string]cacheEntry
+	now func() time.Time
+}
+
+// create a cache. A zero ttl disables caching
+func NewTTLCache(ttl time.Duration) *TTLCache {
+	return &TTLCache{ ttl: ttl, entries: map[string]cacheEntry{}, now: time.Now }
+}
+
+// get a value that has not expired
+func (c *TTLCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// store a value. expired entries are removed
+func (c *TTLCache) Set(key string, value interface{}) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for k, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cacheEntry{ value, now.Add(c.ttl) }
+}
+
+// how long values are kept
+func (c *TTLCache) TTL() time.Duration {
+	return c.ttl
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTTLCache(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2015, 8, 13, 0, 0, 0, 0, time.UTC)
+	cache := NewTTLCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.Set("a", 1)
+	v, ok := cache.Get("a")
+	assert.True(ok)
+	assert.Equal(1, v)
+
+	now = now.Add(time.Minute)
+	_, ok = cache.Get("a")
+	assert.False(ok)
+
+	// zero ttl disables caching
+	disabled := NewTTLCache(0)
+	disabled.Set("a", 1)
+	_, ok = disabled.Get("a")
+	assert.False(ok)
+}
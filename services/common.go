@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"os"
 	"crypto/md5"
 	"fmt"
@@ -15,8 +16,10 @@ import (
 	cryptrand "crypto/rand"
 	b64 "encoding/base64"
 	"golang.org/x/crypto/bcrypt"
+	"github.com/ownode/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"github.com/fatih/camelcase"
-	"github.com/DigiExam/luhn"
 	"math"
 )
 
@@ -126,8 +129,10 @@ func StringInStringSlice(sl []string, str string) bool {
 	return false
 }
 
-// bcrypt hash a string/password
-func Bcrypt(str string, cost int) (string, error) {
+// bcrypt hash a string/password. Traced as a child of the span of ctx
+func Bcrypt(ctx context.Context, str string, cost int) (string, error) {
+	_, span := tracing.Tracer.Start(ctx, "bcrypt.hash", trace.WithAttributes(attribute.Int("bcrypt.cost", cost)))
+	defer span.End()
 	hashedStr, err := bcrypt.GenerateFromPassword([]byte(str), cost)
 	if err != nil {
 		return "", err
@@ -135,8 +140,10 @@ func Bcrypt(str string, cost int) (string, error) {
 	return string(hashedStr), nil
 }
 
-// compare bcrypted password and an unhashed passoword
-func BcryptCompare(hashedPass, pass string) bool {
+// compare bcrypted password and an unhashed passoword. Traced as a child of the span of ctx
+func BcryptCompare(ctx context.Context, hashedPass, pass string) bool {
+	_, span := tracing.Tracer.Start(ctx, "bcrypt.compare")
+	defer span.End()
 	if bcrypt.CompareHashAndPassword([]byte(hashedPass), []byte(pass)) == nil {
 		return true
 	}
@@ -191,24 +198,6 @@ func StructToJsonToSlice(obj interface{}) ([]map[string]interface{}, error) {
 	return d, errors.New("invalid object type passed")
 }
 
-// create new object pin
-func NewObjectPin(countryCode string) (string, error) {
-
-	countryCodeLen := len(countryCode)
-	if countryCodeLen == 0 {
-		return "", errors.New("provide country code")
-	}
-
-	// pad country code if less than 4
-	if countryCodeLen < 4 {
-		missingLength := 4 - countryCodeLen
-		countryCode = strings.Repeat("0", missingLength) + countryCode
-	}
-
-	pin := countryCode + GenRandNum(11)
-	return luhn.Append(pin)
-}
-
 // checks if a slice has a duplicate string
 func StringSliceHasDuplicates(data []string) bool {
 	seenMap := make(map[string]struct{})
@@ -232,6 +221,12 @@ func Round(f float64) float64 {
     return math.Floor(f + .5)
 }
 
+// round a number to a number of decimal places
+func ToFixed(f float64, places int) float64 {
+    shift := math.Pow(10, float64(places))
+    return Round(f * shift) / shift
+}
+
 // returns "s" if l not zero. useful for pluralizing words
 func SIfNotZero(l int) string {
 	if l == 0 {
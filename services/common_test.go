@@ -88,12 +88,6 @@ func TestGetRandNum(t *testing.T) {
 	assert.Equal(len(genN), 16, "should match")
 }
 
-func TestNewObjectPin(t *testing.T) {
-	assert := assert.New(t)
-	_, err := NewObjectPin("1")
-	assert.Nil(err)
-}
-
 func TestStringSliceHasDuplicates(t *testing.T) {
 	assert := assert.New(t)
 	testData := [][]string{
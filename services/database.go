@@ -1,92 +1,113 @@
 package services
 
 import (
-    "gopkg.in/mgo.v2"
+    "context"
     "errors"
+    "strings"
     "github.com/jinzhu/gorm"
+    "github.com/ownode/models"
+    "github.com/ownode/tracing"
     _ "github.com/lib/pq"
+    _ "github.com/mattn/go-sqlite3"
 )
 
-var (
-	MongoDBName string
-	IdentityColName string
-	ServiceColName string
-	WalletColName string
-	TokenColName string
-)
-
-func init () {
-	MongoDBName = GetEnvOrDefault("OWNODE_DB_NAME", "ownode")
-	IdentityColName = GetEnvOrDefault("OWNODE_IDENTITY_COL_NAME", "Identities")
-	ServiceColName = GetEnvOrDefault("OWNODE_SERVICE_COL_NAME", "services")
-	WalletColName = GetEnvOrDefault("OWNODE_WALLET_COL_NAME", "wallets")
-	TokenColName = GetEnvOrDefault("OWNODE_TOKEN_COL_NAME", "tokens")
-}
-
 type DB struct {
-	session *mgo.Session
 	pgDB *gorm.DB
+	dialect string
 }
 
-// connects to mongo db and return session
-func (db *DB) ConnectToMongo() (*mgo.Session, error) {
-	session, err := mgo.Dial("localhost")		// TODO: get from env variable
-    if err != nil {
-        return &mgo.Session{}, errors.New("unable to connect to database")
-    }
-    db.session = session
-    return session, nil
-}
+// connect to a database. driver is postgres or sqlite3. For sqlite, dsn is
+// a file path or :memory:. maxOpenConns of 0 means no limit
+func (db *DB) Connect(driver, dsn string, maxOpenConns, maxIdleConns int) (*gorm.DB, error) {
 
-// returns mongo session
-func (db *DB) GetMongoSession() *mgo.Session {
-	return db.session
-}
+	if driver != models.Postgres && driver != models.SQLite {
+		return nil, errors.New("unsupported database driver " + driver)
+	}
 
-// close mongo database session
-func (db *DB) CloseMongoSession() {
-	db.session.Close()
-}
+	inMemory := false
+	if driver == models.SQLite {
+		inMemory = dsn == ":memory:"
+		dsn = sqliteDSN(dsn)
+	}
 
-// connect to postgres db
-func (db *DB) ConnectToPostgres(args string) (*gorm.DB, error) {
-	
-	dbObj, err := gorm.Open("postgres", args)
+	dbObj, err := gorm.Open(driver, dsn)
 	if err != nil {
-		return &dbObj, errors.New("unable to connect to postgres. reason: " + err.Error())
+		return &dbObj, errors.New("unable to connect to " + driver + ". reason: " + err.Error())
 	}
-	
+
 	if err = dbObj.DB().Ping(); err != nil {
-		return &dbObj, errors.New("unable to ping postgres. reason: " + err.Error())
+		return &dbObj, errors.New("unable to ping " + driver + ". reason: " + err.Error())
 	}
 
-	db.pgDB = &dbObj
+	// every connection to :memory: opens a new database so only one is used
+	if inMemory {
+		maxOpenConns, maxIdleConns = 1, 1
+	}
+	dbObj.DB().SetMaxOpenConns(maxOpenConns)
+	dbObj.DB().SetMaxIdleConns(maxIdleConns)
+
+	tracing.RegisterCallbacks(&dbObj, driver)
+	db.pgDB = models.WithDialect(&dbObj, driver)
+	db.dialect = driver
 	// db.pgDB.LogMode(true)
 	return db.pgDB, err
 }
 
+// add the connection options ownode relies on to a sqlite dsn. Transactions
+// take the write lock when they begin, so they are serialized, and connections
+// wait for the lock instead of failing
+func sqliteDSN(dsn string) string {
+	options := "_txlock=immediate&_busy_timeout=5000&_foreign_keys=1"
+	if dsn != ":memory:" {
+		options += "&_journal_mode=WAL"
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + options
+	}
+	return dsn + "?" + options
+}
+
+// return a copy of db whose queries are traced as children of the span of ctx
+func (db *DB) WithContext(ctx context.Context) *DB {
+	return &DB{ pgDB: tracing.WithContext(db.pgDB, ctx), dialect: db.dialect }
+}
+
+// the driver of the connected database
+func (db *DB) Dialect() string {
+	return db.dialect
+}
+
 func (dbM *DB) setDB(db *gorm.DB) {
-	_ = db.Exec("set time zone 'utc';")
+	if dbM.dialect == models.Postgres {
+		_ = db.Exec("set time zone 'utc';")
+	}
 }
 
-// get postgres db handle
+// get db handle
 func (db *DB) GetPostgresHandle() *gorm.DB {
 	db.setDB(db.pgDB)
 	return db.pgDB
 }
 
-// get postgres transaction with isolation set to repeatable read
+// get transaction with isolation set to repeatable read. sqlite transactions
+// hold the write lock from the start, which is at least as strict
 func (db *DB) GetPostgresHandleWithRepeatableReadTrans() (*gorm.DB, error) {
-	tx := db.GetPostgresHandle().Begin()
-	err := tx.Exec(`set transaction isolation level repeatable read`).Error
-	if err != nil {
-		tx.Rollback()
-	    return tx, err
-	}
-	return tx, nil
+	return models.BeginRepeatableRead(db.GetPostgresHandle())
+}
+
+// run fn in a repeatable read transaction that is committed if fn returns nil.
+// It is retried on serialization failures and deadlocks, so fn must not write
+// a response for errors that can be retried
+func (db *DB) RepeatableReadTransaction(fn func(dbTx *gorm.DB) error) error {
+	return models.RepeatableReadTransaction(db.GetPostgresHandle(), fn)
 }
 
-// close postgres database
+// check that the database is reachable
+func (db *DB) Ping(ctx context.Context) error {
+	return db.pgDB.DB().PingContext(ctx)
+}
+
+// close database
 func (db *DB) ClosePostgresHandle() {
 	db.pgDB.Close()
-}
\ No newline at end of file
+}
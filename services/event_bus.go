@@ -0,0 +1,74 @@
+package services
+
+import (
+	"sync"
+)
+
+// size of a subscription's buffer. A subscriber that falls this far
+// behind is dropped and must catch up from persisted state
+var EventBusBufferSize = 64
+
+// in-process publish/subscribe of events by topic
+type EventBus struct {
+	mu sync.Mutex
+	subs map[string]map[chan interface{}]struct{}
+}
+
+// the event bus shared by the process
+var Events = NewEventBus()
+
+// create an event bus
+func NewEventBus() *EventBus {
+	return &EventBus{ subs: map[string]map[chan interface{}]struct{}{} }
+}
+
+// subscribe to a topic. The returned channel is closed when the subscriber
+// is too slow to keep up. The returned function must be called to unsubscribe
+func (b *EventBus) Subscribe(topic string) (<-chan interface{}, func()) {
+	ch := make(chan interface{}, EventBusBufferSize)
+
+	b.mu.Lock()
+	if b.subs[topic] == nil {
+		b.subs[topic] = map[chan interface{}]struct{}{}
+	}
+	b.subs[topic][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(topic, ch)
+	}
+}
+
+// publish an event to the subscribers of a topic
+func (b *EventBus) Publish(topic string, event interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[topic] {
+		select {
+		case ch <- event:
+		default:
+			b.remove(topic, ch)
+		}
+	}
+}
+
+// number of subscribers of a topic
+func (b *EventBus) Subscribers(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs[topic])
+}
+
+// remove and close a subscription. caller must hold the lock
+func (b *EventBus) remove(topic string, ch chan interface{}) {
+	if _, ok := b.subs[topic][ch]; !ok {
+		return
+	}
+	delete(b.subs[topic], ch)
+	close(ch)
+	if len(b.subs[topic]) == 0 {
+		delete(b.subs, topic)
+	}
+}
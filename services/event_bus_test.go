@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventBusPublish(t *testing.T) {
+	assert := assert.New(t)
+	bus := NewEventBus()
+
+	ch, unsubscribe := bus.Subscribe("wallet_1")
+	other, unsubscribeOther := bus.Subscribe("wallet_2")
+	defer unsubscribeOther()
+
+	bus.Publish("wallet_1", "hello")
+	assert.Equal("hello", <-ch)
+	assert.Equal(0, len(other))
+
+	unsubscribe()
+	assert.Equal(0, bus.Subscribers("wallet_1"))
+
+	// unsubscribing twice is harmless
+	unsubscribe()
+}
+
+func TestEventBusDropsSlowSubscriber(t *testing.T) {
+	assert := assert.New(t)
+	bus := NewEventBus()
+
+	ch, unsubscribe := bus.Subscribe("wallet_1")
+	defer unsubscribe()
+
+	for i := 0; i <= EventBusBufferSize; i++ {
+		bus.Publish("wallet_1", i)
+	}
+
+	received := 0
+	for range ch {
+		received++
+	}
+	assert.Equal(EventBusBufferSize, received)
+	assert.Equal(0, bus.Subscribers("wallet_1"))
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+var ErrRateNotFound = errors.New("exchange rate not found")
+
+// an exchange rate. One unit of From is worth Value units of To
+type Rate struct {
+	From string `json:"from"`
+	To string `json:"to"`
+	Value float64 `json:"rate"`
+	Time time.Time `json:"time"`
+}
+
+// convert an amount of the rate's From currency
+func (r Rate) Convert(amount float64) float64 {
+	return amount * r.Value
+}
+
+// provides exchange rates between currencies
+type RateProvider interface {
+	Rate(from, to string) (Rate, error)
+}
+
+// the rate provider used by the process. nil when no rates are available
+var Rates RateProvider
+
+// rates of currencies against a base currency
+type RateTable struct {
+	mu sync.RWMutex
+	base string
+	time time.Time
+	rates map[string]float64
+}
+
+// rate file format. rates are units of a currency per unit of base.
+// time is a unix timestamp of when the rates were taken
+type rateFile struct {
+	Base string `json:"base"`
+	Time int64 `json:"time"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+// parse a rate table from json
+func ParseRates(data []byte) (*RateTable, error) {
+	table := &RateTable{}
+	return table, table.set(data)
+}
+
+// load a rate table from a json file
+func LoadRateFile(path string) (*RateTable, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRates(data)
+}
+
+// replace the rates with those of a json file
+func (t *RateTable) Reload(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return t.set(data)
+}
+
+func (t *RateTable) set(data []byte) error {
+	var file rateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	if file.Base == "" {
+		return errors.New("rates: base currency is required")
+	}
+	for currency, rate := range file.Rates {
+		if rate <= 0 {
+			return errors.New("rates: rate of " + currency + " must be greater than zero")
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.base = file.Base
+	t.time = UnixToTime(file.Time).UTC()
+	t.rates = file.Rates
+	return nil
+}
+
+// rate of a currency against the base
+func (t *RateTable) baseRate(currency string) (float64, bool) {
+	if currency == t.base {
+		return 1, true
+	}
+	rate, ok := t.rates[currency]
+	return rate, ok
+}
+
+// get the rate between two currencies. Rates between non base currencies
+// are crossed through the base
+func (t *RateTable) Rate(from, to string) (Rate, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	rate := Rate{ From: from, To: to, Value: 1, Time: t.time }
+	if from == to {
+		return rate, nil
+	}
+	fromRate, ok := t.baseRate(from)
+	if !ok {
+		return rate, ErrRateNotFound
+	}
+	toRate, ok := t.baseRate(to)
+	if !ok {
+		return rate, ErrRateNotFound
+	}
+	rate.Value = toRate / fromRate
+	return rate, nil
+}
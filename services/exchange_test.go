@@ -0,0 +1,38 @@
+package services
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateTable(t *testing.T) {
+	assert := assert.New(t)
+	table, err := ParseRates([]byte(`{"base":"USD","time":1439424000,"rates":{"EUR":0.5,"NGN":200}}`))
+	assert.Nil(err)
+
+	rate, err := table.Rate("USD", "EUR")
+	assert.Nil(err)
+	assert.Equal(0.5, rate.Value)
+	assert.Equal(int64(1439424000), rate.Time.Unix())
+
+	// crossed through the base
+	rate, err = table.Rate("EUR", "NGN")
+	assert.Nil(err)
+	assert.Equal(400.0, rate.Value)
+	assert.Equal(40.0, rate.Convert(0.1))
+
+	rate, err = table.Rate("NGN", "NGN")
+	assert.Nil(err)
+	assert.Equal(1.0, rate.Value)
+
+	_, err = table.Rate("USD", "GBP")
+	assert.Equal(ErrRateNotFound, err)
+}
+
+func TestParseRatesRejectsInvalidRates(t *testing.T) {
+	assert := assert.New(t)
+	_, err := ParseRates([]byte(`{"base":"USD","rates":{"EUR":0}}`))
+	assert.NotNil(err)
+	_, err = ParseRates([]byte(`{"rates":{"EUR":1}}`))
+	assert.NotNil(err)
+}
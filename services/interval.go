@@ -0,0 +1,39 @@
+package services
+
+import (
+	"time"
+)
+
+// intervals time series can be grouped by
+var Intervals = []string{ "hour", "day", "week", "month" }
+
+// start of the interval containing a time. weeks start on monday.
+// returns false if the interval is unknown
+func IntervalStart(t time.Time, interval string) (time.Time, bool) {
+	t = t.UTC()
+	switch interval {
+	case "hour":
+		return t.Truncate(time.Hour), true
+	case "day":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), true
+	case "week":
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day() - daysSinceMonday, 0, 0, 0, 0, time.UTC), true
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), true
+	}
+	return t, false
+}
+
+// start of the interval following the interval starting at t
+func NextInterval(t time.Time, interval string) time.Time {
+	switch interval {
+	case "hour":
+		return t.Add(time.Hour)
+	case "day":
+		return t.AddDate(0, 0, 1)
+	case "week":
+		return t.AddDate(0, 0, 7)
+	}
+	return t.AddDate(0, 1, 0)
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntervalStart(t *testing.T) {
+	assert := assert.New(t)
+	ts := time.Date(2015, 8, 13, 15, 42, 10, 0, time.UTC)	// a thursday
+
+	start, ok := IntervalStart(ts, "hour")
+	assert.True(ok)
+	assert.Equal(time.Date(2015, 8, 13, 15, 0, 0, 0, time.UTC), start)
+
+	start, _ = IntervalStart(ts, "day")
+	assert.Equal(time.Date(2015, 8, 13, 0, 0, 0, 0, time.UTC), start)
+
+	start, _ = IntervalStart(ts, "week")
+	assert.Equal(time.Date(2015, 8, 10, 0, 0, 0, 0, time.UTC), start)
+
+	start, _ = IntervalStart(ts, "month")
+	assert.Equal(time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC), NextInterval(start, "month"))
+
+	_, ok = IntervalStart(ts, "year")
+	assert.False(ok)
+}
@@ -0,0 +1,263 @@
+// Listing provides cursor (keyset) pagination, sorting and filtering of
+// collections. A cursor encodes the sort value and id of the last item of a
+// page so the next page starts right after it, which keeps pages stable when
+// items are inserted while a client is paging
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"github.com/jinzhu/gorm"
+)
+
+// an invalid list query parameter
+type ListError struct {
+	Param string
+	Message string
+}
+
+func (e *ListError) Error() string {
+	return e.Message
+}
+
+// a filter applied when its query parameter is present. Apply returns a
+// *ListError if the value is invalid
+type ListFilter struct {
+	Param string
+	Apply func(db *gorm.DB, value string) (*gorm.DB, error)
+}
+
+// describes how a collection can be listed
+type Listing struct {
+
+	// sortable fields mapped to their column. sort is given as `sort=field`
+	// or `sort=-field` for descending order
+	Sorts map[string]string
+	DefaultSort string
+
+	// sortable fields mapped to the struct field holding their value.
+	// used to create cursors. The id field is used for the id column
+	SortFields map[string]string
+
+	// column used to order items with equal sort values and its struct field
+	IDColumn string
+	IDField string
+
+	Filters []ListFilter
+
+	// query parameters with this prefix must be known filters
+	FilterPrefix string
+
+	DefaultLimit int
+	MaxLimit int
+}
+
+// position after the last item of a page
+type listCursor struct {
+	Sort string `json:"s"`
+	Desc bool `json:"d"`
+	Value interface{} `json:"v"`
+	ID uint `json:"id"`
+}
+
+// a parsed list query
+type ListQuery struct {
+
+	// the filtered query without pagination. useful for counting
+	DB *gorm.DB
+
+	Sort string
+	Desc bool
+	Limit int
+
+	listing *Listing
+	cursor *listCursor
+}
+
+// encode a cursor
+func encodeListCursor(cursor listCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decode a cursor
+func decodeListCursor(str string) (listCursor, error) {
+	cursor := listCursor{}
+	data, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return cursor, err
+	}
+	return cursor, json.Unmarshal(data, &cursor)
+}
+
+// parse a sort value into the field and direction
+func (l *Listing) parseSort(value string) (string, bool, bool) {
+	if value == "" {
+		value = l.DefaultSort
+	}
+	desc := strings.HasPrefix(value, "-")
+	field := strings.TrimPrefix(value, "-")
+	_, ok := l.Sorts[field]
+	return field, desc, ok
+}
+
+// parse the list query parameters (limit, cursor, sort and filters) and apply the filters.
+// Invalid parameters are reported as *ListError
+func (l *Listing) Parse(db *gorm.DB, query url.Values) (*ListQuery, error) {
+
+	q := &ListQuery{ Limit: l.DefaultLimit, listing: l }
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > l.MaxLimit {
+			return nil, &ListError{ "limit", fmt.Sprintf("limit must be a number between 1 and %d", l.MaxLimit) }
+		}
+		q.Limit = n
+	}
+
+	sort, desc, ok := l.parseSort(query.Get("sort"))
+	if !ok {
+		return nil, &ListError{ "sort", "sort field is unknown" }
+	}
+	q.Sort, q.Desc = sort, desc
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		c, err := decodeListCursor(cursor)
+		if err != nil {
+			return nil, &ListError{ "cursor", "cursor is invalid" }
+		} else if c.Sort != q.Sort || c.Desc != q.Desc {
+			return nil, &ListError{ "cursor", "cursor does not match the sort order" }
+		}
+		q.cursor = &c
+	}
+
+	// reject unknown filters
+	known := map[string]bool{}
+	for _, filter := range l.Filters {
+		known[filter.Param] = true
+	}
+	if l.FilterPrefix != "" {
+		for param := range query {
+			if strings.HasPrefix(param, l.FilterPrefix) && !known[param] {
+				return nil, &ListError{ param, param + " is not a known filter" }
+			}
+		}
+	}
+
+	for _, filter := range l.Filters {
+		value := query.Get(filter.Param)
+		if value == "" {
+			continue
+		}
+		var err error
+		if db, err = filter.Apply(db, value); err != nil {
+			return nil, err
+		}
+	}
+
+	q.DB = db
+	return q, nil
+}
+
+// the query of the requested page. It fetches one item more than the limit
+// to determine whether there is a next page
+func (q *ListQuery) Page() *gorm.DB {
+	column := q.listing.Sorts[q.Sort]
+	direction, op := "asc", ">"
+	if q.Desc {
+		direction, op = "desc", "<"
+	}
+
+	db := q.DB
+	if q.cursor != nil {
+		if column == q.listing.IDColumn {
+			db = db.Where(fmt.Sprintf("%s %s ?", column, op), q.cursor.ID)
+		} else {
+			db = db.Where(fmt.Sprintf("(%s, %s) %s (?, ?)", column, q.listing.IDColumn, op), q.cursor.Value, q.cursor.ID)
+		}
+	}
+
+	db = db.Order(column + " " + direction)
+	if column != q.listing.IDColumn {
+		db = db.Order(q.listing.IDColumn + " " + direction)
+	}
+	return db.Limit(q.Limit + 1)
+}
+
+// fetch the requested page into dest, a pointer to a slice of structs.
+// db must be built from Page(). Returns the cursor of the next page or
+// an empty string if this is the last page
+func (q *ListQuery) Find(db *gorm.DB, dest interface{}) (string, error) {
+	if err := db.Find(dest).Error; err != nil {
+		return "", err
+	}
+
+	items := reflect.ValueOf(dest).Elem()
+	if items.Len() <= q.Limit {
+		return "", nil
+	}
+	items.Set(items.Slice(0, q.Limit))
+
+	last := reflect.Indirect(items.Index(q.Limit - 1))
+	id := uint(last.FieldByName(q.listing.IDField).Uint())
+	var sortValue interface{}
+	if field, ok := q.listing.SortFields[q.Sort]; ok {
+		sortValue = last.FieldByName(field).Interface()
+	}
+	return q.NextCursor(sortValue, id), nil
+}
+
+// cursor of the page following an item
+func (q *ListQuery) NextCursor(sortValue interface{}, id uint) string {
+	return encodeListCursor(listCursor{ Sort: q.Sort, Desc: q.Desc, Value: sortValue, ID: id })
+}
+
+// filter by a column whose value must be one of the given values
+func EnumFilter(param, column string, values []string) ListFilter {
+	return ListFilter{ param, func(db *gorm.DB, value string) (*gorm.DB, error) {
+		for _, v := range values {
+			if v == value {
+				return db.Where(column + " = ?", value), nil
+			}
+		}
+		return db, &ListError{ param, fmt.Sprintf("%s can only be one of: %s", param, strings.Join(values, ", ")) }
+	}}
+}
+
+// filter by a boolean column
+func BoolFilter(param, column string) ListFilter {
+	return ListFilter{ param, func(db *gorm.DB, value string) (*gorm.DB, error) {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return db, &ListError{ param, param + " must be true or false" }
+		}
+		return db.Where(column + " = ?", b), nil
+	}}
+}
+
+// filter a numeric column using a comparison operator (e.g >=)
+func NumberFilter(param, column, op string) ListFilter {
+	return ListFilter{ param, func(db *gorm.DB, value string) (*gorm.DB, error) {
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return db, &ListError{ param, param + " must be a number" }
+		}
+		return db.Where(fmt.Sprintf("%s %s ?", column, op), n), nil
+	}}
+}
+
+// filter a time column by a unix timestamp using a comparison operator (e.g >=)
+func TimeFilter(param, column, op string) ListFilter {
+	return ListFilter{ param, func(db *gorm.DB, value string) (*gorm.DB, error) {
+		ts, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return db, &ListError{ param, param + " must be a unix timestamp" }
+		}
+		return db.Where(fmt.Sprintf("%s %s ?", column, op), UnixToTime(ts).UTC()), nil
+	}}
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListCursorRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	encoded := encodeListCursor(listCursor{ Sort: "balance", Desc: true, Value: 10.5, ID: 42 })
+	cursor, err := decodeListCursor(encoded)
+	assert.Nil(err)
+	assert.Equal("balance", cursor.Sort)
+	assert.True(cursor.Desc)
+	assert.Equal(10.5, cursor.Value)
+	assert.Equal(uint(42), cursor.ID)
+
+	_, err = decodeListCursor("not a cursor")
+	assert.NotNil(err)
+}
+
+func TestListingParseSort(t *testing.T) {
+	assert := assert.New(t)
+	listing := &Listing{ Sorts: map[string]string{ "id": "objects.id", "balance": "objects.balance" }, DefaultSort: "id" }
+
+	field, desc, ok := listing.parseSort("")
+	assert.Equal("id", field)
+	assert.False(desc)
+	assert.True(ok)
+
+	field, desc, ok = listing.parseSort("-balance")
+	assert.Equal("balance", field)
+	assert.True(desc)
+	assert.True(ok)
+
+	_, _, ok = listing.parseSort("pin")
+	assert.False(ok)
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"github.com/xeipuuv/gojsonschema"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var metaFieldPattern = regexp.MustCompile(`^[a-zA-Z0-9_]+(\.[a-zA-Z0-9_]+)*$`)
+
+// check that a json schema is valid
+func ValidateJSONSchema(schema string) error {
+	if _, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(schema)); err != nil {
+		return errors.New("schema is invalid. reason: " + err.Error())
+	}
+	return nil
+}
+
+// validate a json document against a json schema.
+// returns the list of validation errors. An error is returned if the schema is invalid
+func ValidateJSONAgainstSchema(schema string, doc string) ([]string, error) {
+	result, err := gojsonschema.Validate(gojsonschema.NewStringLoader(schema), gojsonschema.NewStringLoader(doc))
+	if err != nil {
+		return nil, err
+	}
+
+	reasons := []string{}
+	for _, e := range result.Errors() {
+		reasons = append(reasons, e.String())
+	}
+	return reasons, nil
+}
+
+// convert a meta filter field (e.g order_id or event.seat) to a postgres json path (e.g {event,seat}).
+// returns false if the field is not a valid meta field
+func MetaFieldToJSONPath(field string) (string, bool) {
+	if !metaFieldPattern.MatchString(field) {
+		return "", false
+	}
+	return "{" + strings.Replace(field, ".", ",", -1) + "}", true
+}
+
+// convert a meta filter field (e.g event.seat) to a sqlite json path (e.g $.event.seat).
+// returns false if the field is not a valid meta field
+func MetaFieldToSQLitePath(field string) (string, bool) {
+	if !metaFieldPattern.MatchString(field) {
+		return "", false
+	}
+	return "$." + field, true
+}
@@ -0,0 +1,24 @@
+package services
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetaFieldToJSONPath(t *testing.T) {
+	assert := assert.New(t)
+	path, ok := MetaFieldToJSONPath("event.seat")
+	assert.True(ok)
+	assert.Equal(path, "{event,seat}", "should match")
+	_, ok = MetaFieldToJSONPath("order_id'; drop")
+	assert.False(ok)
+}
+
+func TestMetaFieldToSQLitePath(t *testing.T) {
+	assert := assert.New(t)
+	path, ok := MetaFieldToSQLitePath("event.seat")
+	assert.True(ok)
+	assert.Equal(path, "$.event.seat", "should match")
+	_, ok = MetaFieldToSQLitePath("order_id'; drop")
+	assert.False(ok)
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync/atomic"
+	"time"
+)
+
+// random bytes identifying the process and the counter of ids it generated
+var (
+	objectIDProcess = objectIDRandom(5)
+	objectIDCounter = binary.BigEndian.Uint32(append([]byte{ 0 }, objectIDRandom(3)...))
+)
+
+func objectIDRandom(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("unable to read random bytes for object ids: " + err.Error())
+	}
+	return b
+}
+
+// generate a 24 character hex id. ids are 12 bytes: a 4 byte timestamp,
+// 5 random bytes per process and a 3 byte counter, so ids sort by creation second
+func NewObjectID() string {
+	var b [12]byte
+	binary.BigEndian.PutUint32(b[0:4], uint32(time.Now().Unix()))
+	copy(b[4:9], objectIDProcess)
+	counter := atomic.AddUint32(&objectIDCounter, 1)
+	b[9], b[10], b[11] = byte(counter >> 16), byte(counter >> 8), byte(counter)
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,17 @@
+package services
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewObjectID(t *testing.T) {
+	assert := assert.New(t)
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		id := NewObjectID()
+		assert.Equal(24, len(id))
+		assert.False(seen[id], "ids must be unique")
+		seen[id] = true
+	}
+}
@@ -0,0 +1,126 @@
+// Pin module generates, validates and formats object pins.
+// An object pin is a 16 digit number made up of a 4 digit prefix
+// determined by the issuer's currency, 11 random digits and a luhn check digit
+package services
+
+import (
+	"github.com/DigiExam/luhn"
+	"errors"
+	"strings"
+)
+
+// length of an object pin
+var PinLength = 16
+
+// number of times pin generation is retried when generated pins collide
+var MaxPinAttempts = 5
+
+// create new object pin
+func NewObjectPin(countryCode string) (string, error) {
+
+	countryCodeLen := len(countryCode)
+	if countryCodeLen == 0 {
+		return "", errors.New("provide country code")
+	}
+
+	// pad country code if less than 4
+	if countryCodeLen < 4 {
+		missingLength := 4 - countryCodeLen
+		countryCode = strings.Repeat("0", missingLength) + countryCode
+	}
+
+	pin := countryCode + GenRandNum(11)
+	return luhn.Append(pin)
+}
+
+// create n unique object pins. `existing` is called with the generated pins and 
+// must return the pins already in use. Pins that are in use or are generated more
+// than once are regenerated up to MaxPinAttempts times
+func NewUniqueObjectPins(countryCode string, n int, existing func([]string) ([]string, error)) ([]string, error) {
+
+	pins := []string{}
+	seen := map[string]struct{}{}
+
+	for attempt := 0; attempt < MaxPinAttempts && len(pins) < n; attempt++ {
+
+		// generate the missing pins, skip pins generated more than once
+		candidates := []string{}
+		for len(pins) + len(candidates) < n {
+			pin, err := NewObjectPin(countryCode)
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := seen[pin]; dup {
+				continue
+			}
+			seen[pin] = struct{}{}
+			candidates = append(candidates, pin)
+		}
+
+		// remove pins already in use
+		inUse, err := existing(candidates)
+		if err != nil {
+			return nil, err
+		}
+
+		inUseMap := map[string]struct{}{}
+		for _, pin := range inUse {
+			inUseMap[pin] = struct{}{}
+		}
+
+		for _, pin := range candidates {
+			if _, used := inUseMap[pin]; !used {
+				pins = append(pins, pin)
+			}
+		}
+	}
+
+	if len(pins) < n {
+		return nil, errors.New("unable to generate unique object pins")
+	}
+
+	return pins, nil
+}
+
+// remove separators (spaces and dashes) from a pin
+func NormalizePin(pin string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(pin))
+}
+
+// check that a pin has the right length, contains only digits and passes the luhn check
+func IsValidPin(pin string) bool {
+	pin = NormalizePin(pin)
+	if len(pin) != PinLength {
+		return false
+	}
+	for _, c := range pin {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	valid, err := luhn.Valid(pin)
+	return err == nil && valid
+}
+
+// format a pin in groups of 4 digits for display. e.g 0263 1234 5678 9014
+func FormatPin(pin string) string {
+	pin = NormalizePin(pin)
+	groups := []string{}
+	for i := 0; i < len(pin); i += 4 {
+		end := i + 4
+		if end > len(pin) {
+			end = len(pin)
+		}
+		groups = append(groups, pin[i:end])
+	}
+	return strings.Join(groups, " ")
+}
+
+// mask all but the last 4 digits of a pin. e.g **** **** **** 9014
+func MaskPin(pin string) string {
+	pin = NormalizePin(pin)
+	if len(pin) <= 4 {
+		return pin
+	}
+	return FormatPin(strings.Repeat("*", len(pin) - 4) + pin[len(pin) - 4:])
+}
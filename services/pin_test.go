@@ -0,0 +1,55 @@
+package services
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewObjectPin(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewObjectPin("1")
+	assert.Nil(err)
+}
+
+func TestNewUniqueObjectPins(t *testing.T) {
+	assert := assert.New(t)
+	calls := 0
+	pins, err := NewUniqueObjectPins("263", 3, func(candidates []string) ([]string, error) {
+		calls++
+		if calls == 1 {
+			return candidates[:1], nil
+		}
+		return []string{}, nil
+	})
+	assert.Nil(err)
+	assert.Equal(len(pins), 3, "should match")
+	assert.Equal(calls, 2, "should retry once")
+}
+
+func TestNewUniqueObjectPinsFailsWhenAllCollide(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewUniqueObjectPins("263", 1, func(candidates []string) ([]string, error) {
+		return candidates, nil
+	})
+	assert.NotNil(err)
+}
+
+func TestIsValidPin(t *testing.T) {
+	assert := assert.New(t)
+	pin, _ := NewObjectPin("263")
+	assert.True(IsValidPin(pin))
+	assert.True(IsValidPin(FormatPin(pin)))
+	assert.False(IsValidPin("1234"))
+	assert.False(IsValidPin("02631234567890ab"))
+}
+
+func TestFormatPin(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(FormatPin("0263123456789014"), "0263 1234 5678 9014", "should match")
+	assert.Equal(FormatPin("0263-1234 5678-9014"), "0263 1234 5678 9014", "should match")
+}
+
+func TestMaskPin(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(MaskPin("0263123456789014"), "**** **** **** 9014", "should match")
+}
@@ -0,0 +1,76 @@
+// Redact module removes secrets such as credentials and
+// object pins from values that are logged
+package services
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// logged in place of a secret
+const Redacted = "[REDACTED]"
+
+// headers whose values are secret. The scheme of an authorization header is kept
+var SecretHeaders = []string{ "Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie" }
+
+// query parameters whose values are secret
+var SecretParams = []string{ "pin", "pins", "password", "client_secret", "access_token", "token" }
+
+// return the values of a header with secrets redacted
+func RedactHeader(header http.Header) map[string]string {
+	redacted := map[string]string{}
+	for key, values := range header {
+		value := strings.Join(values, ", ")
+		if StringInStringSlice(SecretHeaders, key) {
+			value = redactCredentials(value)
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+// keep the scheme of credentials. e.g `Basic abc` becomes `Basic [REDACTED]`
+func redactCredentials(value string) string {
+	if parts := strings.SplitN(value, " ", 2); len(parts) == 2 && parts[0] != "" {
+		return parts[0] + " " + Redacted
+	}
+	return Redacted
+}
+
+// return a url with the values of secret query parameters redacted and the pins
+// in its path masked. A path segment is a pin if it follows `pins` or has as
+// many digits as a pin
+func RedactURL(u *url.URL) string {
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		if (i > 0 && segments[i - 1] == "pins" && segment != "") || looksLikePin(segment) {
+			segments[i] = strings.Replace(MaskPin(segment), " ", "", -1)
+		}
+	}
+	redacted := strings.Join(segments, "/")
+
+	if u.RawQuery == "" {
+		return redacted
+	}
+	query := u.Query()
+	for key := range query {
+		if StringInStringSlice(SecretParams, key) {
+			query[key] = []string{ Redacted }
+		}
+	}
+	return redacted + "?" + query.Encode()
+}
+
+func looksLikePin(segment string) bool {
+	segment = NormalizePin(segment)
+	if len(segment) != PinLength {
+		return false
+	}
+	for _, c := range segment {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
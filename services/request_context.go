@@ -12,6 +12,20 @@ type AuxRequestContext struct {
 	martini.Context
 	*http.Request
 	data map[string]interface{}
+
+	// id of the request, echoed in the X-Request-ID response header
+	ID string
+
+	// log whose lines carry the request id
+	Log Logger
+}
+
+// a log of a request. Satisfied by config.CustomLog
+type Logger interface {
+	Info(args... interface{})
+	Debug(args... interface{})
+	Error(args... interface{})
+	Warn(args... interface{})
 }
 
 // add data
@@ -26,5 +40,5 @@ func (rc *AuxRequestContext) GetData(key string) interface{} {
 
 // return new customer request writer
 func NewAuxRequestContext(c martini.Context, rw *http.Request) AuxRequestContext {
-	return AuxRequestContext{ c, rw, make(map[string]interface{}) }
+	return AuxRequestContext{ Context: c, Request: rw, data: make(map[string]interface{}) }
 }
@@ -13,8 +13,12 @@ type ErrorContent struct {
 	Status int `json:"status"`
 	Message string `json:"message"`
 	Param string `json:"param,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
 }
 
+// header carrying the id of a request. Set on every response
+const RequestIDHeader = "X-Request-ID"
+
 type APIError struct {
 	Error ErrorContent `json:"error"`
 }
@@ -70,6 +74,7 @@ func (resp *Response) Send(body interface{}) (int, error) {
 func (resp *Response) Json(obj interface{}) (int, error) {
 	if jsonData, err := json.Marshal(obj); err == nil {
 		resp.SetHeader("Content-Type", "application/json")
+		resp.res.WriteHeader(resp.statusCode)
 		return fmt.Fprint(resp.res, string(jsonData))
 	} else {
 		fmt.Println(err, obj)
@@ -85,6 +90,7 @@ func (resp *Response) Error(status int, msgType string, msg string) (int, error)
 			Status: status,
 			Message: msg,
 			Param: resp.Param,
+			RequestID: resp.res.Header().Get(RequestIDHeader),
 		},
 	}
 	return resp.Json(e)
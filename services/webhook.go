@@ -0,0 +1,73 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// name of the header containing the signature of a webhook payload
+var WebhookSignatureHeader = "Ownode-Signature"
+
+// maximum length of a webhook endpoint url
+var MaxWebhookURLLength = 2048
+
+// allow webhook endpoints on loopback and private addresses. Off by default
+// so endpoints cannot be used to reach services inside the network
+var AllowPrivateWebhookHosts = false
+
+var ErrPrivateWebhookHost = errors.New("webhook endpoint host is a loopback or private address")
+
+// check if an ip is an address webhooks may be delivered to
+func IsPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// check the host of a webhook endpoint url. Hosts named localhost and
+// literal addresses that are not public are rejected. Names are only
+// resolved when delivering, by WebhookDialControl
+func CheckWebhookHost(host string) error {
+	if AllowPrivateWebhookHosts {
+		return nil
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateWebhookHost
+	}
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil && !IsPublicIP(ip) {
+		return ErrPrivateWebhookHost
+	}
+	return nil
+}
+
+// control function of the dialer of webhook deliveries. Refuses connections
+// to addresses that are not public, including names resolving to them
+func WebhookDialControl(network, address string, conn syscall.RawConn) error {
+	if AllowPrivateWebhookHosts {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+		return ErrPrivateWebhookHost
+	}
+	return nil
+}
+
+// sign a webhook payload using HMAC-SHA256. The timestamp is included in the signed
+// content to prevent replays. Returns the value of the signature header
+// in the form t=<timestamp>,v1=<hex signature>
+func SignWebhookPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.", timestamp)))
+	mac.Write(payload)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
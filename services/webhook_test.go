@@ -0,0 +1,27 @@
+package services
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	assert := assert.New(t)
+	sig := SignWebhookPayload("secret", 1445000000, []byte(`{"event":"object.created"}`))
+	sig2 := SignWebhookPayload("secret", 1445000000, []byte(`{"event":"object.created"}`))
+	sig3 := SignWebhookPayload("other", 1445000000, []byte(`{"event":"object.created"}`))
+	assert.Equal(sig, sig2, "should match")
+	assert.NotEqual(sig, sig3, "should not match")
+	assert.True(StringStartsWith(sig, "t=1445000000,v1="))
+}
+
+func TestCheckWebhookHost(t *testing.T) {
+	assert := assert.New(t)
+	assert.Nil(CheckWebhookHost("example.com"))
+	assert.Nil(CheckWebhookHost("93.184.216.34"))
+	for _, host := range []string{ "localhost", "api.localhost", "127.0.0.1", "10.0.0.5", "192.168.1.1", "172.16.0.1", "169.254.169.254", "0.0.0.0", "[::1]", "fd00::1" } {
+		assert.Equal(ErrPrivateWebhookHost, CheckWebhookHost(host), host)
+	}
+	assert.Equal(ErrPrivateWebhookHost, WebhookDialControl("tcp", "127.0.0.1:80", nil))
+	assert.Nil(WebhookDialControl("tcp", "93.184.216.34:443", nil))
+}
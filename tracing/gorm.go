@@ -0,0 +1,131 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// keys of the context of a gorm handle and of the span of a query
+const (
+	contextKey = "tracing:context"
+	spanKey = "tracing:span"
+)
+
+// the db.system of the registered database
+var dbSystem string
+
+// return a handle whose queries are traced as children of the span of ctx.
+// Transactions begun from the handle keep the context
+func WithContext(db *gorm.DB, ctx context.Context) *gorm.DB {
+	return db.Set(contextKey, ctx)
+}
+
+// the context of a handle returned by WithContext or nil
+func Context(db *gorm.DB) context.Context {
+	if value, ok := db.Get(contextKey); ok {
+		return value.(context.Context)
+	}
+	return nil
+}
+
+// start a span for work done with a handle. Returns the handle with the span's
+// context, or the handle unchanged and a nil span if it has no context
+func StartDBSpan(db *gorm.DB, name string, attrs ...attribute.KeyValue) (*gorm.DB, trace.Span) {
+	ctx := Context(db)
+	if ctx == nil {
+		return db, nil
+	}
+	ctx, span := Tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return WithContext(db, ctx), span
+}
+
+// end a span started by StartDBSpan, recording err
+func EndSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// start a span for a raw statement of a handle. Raw queries and Exec do not
+// run callbacks, so they are traced by wrapping them in this and EndSpan.
+// The operation is the statement's first word
+func StartRawSpan(db *gorm.DB, stmt string) trace.Span {
+	operation := stmt
+	if fields := strings.Fields(stmt); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+	_, span := StartDBSpan(db, operation,
+		attribute.String("db.system", dbSystem),
+		attribute.String("db.operation", operation),
+		attribute.String("db.statement", stmt),
+	)
+	return span
+}
+
+// trace the creates, queries, updates and deletes of handles returned by
+// WithContext. driver is the database driver, postgres or sqlite3.
+// Raw queries and Exec are traced with StartRawSpan
+func RegisterCallbacks(db *gorm.DB, driver string) {
+	system := driver
+	if driver == "sqlite3" {
+		system = "sqlite"
+	} else if driver == "postgres" {
+		system = "postgresql"
+	}
+	dbSystem = system
+
+	callback := db.Callback()
+	callback.Create().Before("gorm:begin_transaction").Register("tracing:before_create", startQuerySpan(system, "INSERT"))
+	callback.Create().After("gorm:commit_or_rollback_transaction").Register("tracing:after_create", endQuerySpan)
+	callback.Query().Before("gorm:query").Register("tracing:before_query", startQuerySpan(system, "SELECT"))
+	callback.Query().After("gorm:after_query").Register("tracing:after_query", endQuerySpan)
+	callback.Update().Before("gorm:begin_transaction").Register("tracing:before_update", startQuerySpan(system, "UPDATE"))
+	callback.Update().After("gorm:commit_or_rollback_transaction").Register("tracing:after_update", endQuerySpan)
+	callback.Delete().Before("gorm:begin_transaction").Register("tracing:before_delete", startQuerySpan(system, "DELETE"))
+	callback.Delete().After("gorm:commit_or_rollback_transaction").Register("tracing:after_delete", endQuerySpan)
+}
+
+// start a span named after the operation and table of a query
+func startQuerySpan(system, operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		value, ok := scope.Get(contextKey)
+		if !ok {
+			return
+		}
+		name, table := operation, ""
+		if scope.Value != nil {
+			table = scope.TableName()
+			name += " " + table
+		}
+		_, span := Tracer.Start(value.(context.Context), name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			attribute.String("db.system", system),
+			attribute.String("db.operation", operation),
+			attribute.String("db.sql.table", table),
+		))
+		scope.InstanceSet(spanKey, span)
+	}
+}
+
+// end the span of a query. The statement is recorded without its values
+func endQuerySpan(scope *gorm.Scope) {
+	value, ok := scope.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	span.SetAttributes(attribute.String("db.statement", scope.SQL), attribute.Int64("db.rows_affected", scope.DB().RowsAffected))
+	if err := scope.DB().Error; err != nil && err != gorm.RecordNotFound {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,86 @@
+// Tracing module sets up opentelemetry tracing. Spans are exported over otlp
+// http, to stdout or to a file and traces are continued from and propagated
+// in w3c traceparent headers
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// name of the service in exported spans
+const ServiceName = "ownode"
+
+// exporters spans can be sent to
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile = "file"
+)
+
+// tracer of all spans of the server. Spans are dropped until a provider is registered
+var Tracer = otel.Tracer("github.com/ownode")
+
+// export spans to exporter. endpoint is the url of the otlp http endpoint
+// and file the file spans are appended to. Returns a function that exports
+// the remaining spans and stops the exporter
+func Setup(exporter, endpoint, file string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	switch exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		exp, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+		if err != nil {
+			return nil, err
+		}
+		return Register(exp), nil
+	case ExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, err
+		}
+		return Register(exp), nil
+	case ExporterFile:
+		f, err := os.OpenFile(file, os.O_CREATE | os.O_APPEND | os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		shutdown := Register(exp)
+		return func(ctx context.Context) error {
+			err := shutdown(ctx)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}, nil
+	}
+	return nil, errors.New("unknown trace exporter " + exporter)
+}
+
+// register a tracer provider that exports spans to exporter in batches.
+// Returns a function that exports the remaining spans and stops the exporter
+func Register(exporter sdktrace.SpanExporter) func(context.Context) error {
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", ServiceName)))
+	if err != nil {
+		res = resource.Default()
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown
+}
@@ -0,0 +1,158 @@
+// Wallet event listener relays wallet events announced through postgres
+// LISTEN/NOTIFY to the in-process event bus. Every server instance runs a
+// listener so events caused by any instance reach streams on all instances.
+// sqlite has no notifications so the listener polls the ledger instead
+package workers
+
+import (
+	"github.com/ownode/config"
+	"github.com/ownode/models"
+	"github.com/ownode/services"
+	"github.com/lib/pq"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// max events relayed per query when catching up after a reconnect
+var WalletEventCatchUpSize = 500
+
+// how often the ledger is polled when the database has no notifications
+var WalletEventPollInterval = time.Second
+
+type WalletEventListener struct {
+	db *services.DB
+	log *config.CustomLog
+	bus *services.EventBus
+	listener *pq.Listener
+	lastID int64
+	stop chan struct{}
+	wg sync.WaitGroup
+	running int32
+}
+
+// create a wallet event listener. conninfo is the postgres connection string
+func NewWalletEventListener(db *services.DB, conninfo string, bus *services.EventBus, log *config.CustomLog) *WalletEventListener {
+	l := &WalletEventListener{ db: db, log: log, bus: bus, stop: make(chan struct{}) }
+	if db.Dialect() != models.Postgres {
+		return l
+	}
+	l.listener = pq.NewListener(conninfo, 10 * time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Error("wallet event listener: " + err.Error())
+		}
+	})
+	return l
+}
+
+// start listening in the background
+func (l *WalletEventListener) Start() error {
+	lastID, err := models.LastWalletEventID(l.db.GetPostgresHandle())
+	if err != nil {
+		return err
+	}
+	l.lastID = lastID
+
+	if l.listener != nil {
+		if err := l.listener.Listen(models.WalletEventChannel); err != nil {
+			return err
+		}
+	}
+
+	atomic.StoreInt32(&l.running, 1)
+	l.wg.Add(1)
+	go l.run()
+	return nil
+}
+
+// stop listening
+func (l *WalletEventListener) Stop() {
+	close(l.stop)
+	l.wg.Wait()
+	if l.listener != nil {
+		l.listener.Close()
+	}
+}
+
+// check if the listener is relaying events
+func (l *WalletEventListener) Running() bool {
+	return atomic.LoadInt32(&l.running) == 1
+}
+
+func (l *WalletEventListener) run() {
+	defer l.wg.Done()
+	defer atomic.StoreInt32(&l.running, 0)
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	// without a listener, notify stays nil and the ledger is polled
+	var notify <-chan *pq.Notification
+	var poll <-chan time.Time
+	if l.listener != nil {
+		notify = l.listener.Notify
+	} else {
+		ticker := time.NewTicker(WalletEventPollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-poll:
+			l.catchUp()
+		case n := <-notify:
+
+			// a nil notification follows a reconnect. notifications sent
+			// while disconnected are lost so catch up from the ledger
+			if n == nil {
+				l.catchUp()
+				continue
+			}
+
+			id, err := strconv.ParseInt(n.Extra, 10, 64)
+			if err != nil {
+				l.log.Error("wallet event listener: invalid notification " + n.Extra)
+				continue
+			}
+
+			event, found, err := models.FindWalletEventById(l.db.GetPostgresHandle(), id)
+			if err != nil {
+				l.log.Error(err.Error())
+				continue
+			} else if found {
+				l.publish(event)
+			}
+		case <-ping.C:
+			if l.listener != nil {
+				go l.listener.Ping()
+			}
+		}
+	}
+}
+
+// relay events created after the last relayed event
+func (l *WalletEventListener) catchUp() {
+	for {
+		events, err := models.FindAllWalletEventsAfter(l.db.GetPostgresHandle(), l.lastID, WalletEventCatchUpSize)
+		if err != nil {
+			l.log.Error(err.Error())
+			return
+		}
+		for _, event := range events {
+			l.publish(event)
+		}
+		if len(events) < WalletEventCatchUpSize {
+			return
+		}
+	}
+}
+
+func (l *WalletEventListener) publish(event models.WalletEvent) {
+	if event.ID > l.lastID {
+		l.lastID = event.ID
+	}
+	l.bus.Publish(models.WalletEventTopic(uint(event.WalletID.Int64)), event)
+}
@@ -0,0 +1,226 @@
+// Webhook worker delivers events queued in the webhook outbox to the
+// endpoints registered by services. Failed deliveries are retried with
+// exponential backoff and dead lettered once they run out of attempts
+package workers
+
+import (
+	"github.com/ownode/config"
+	"github.com/ownode/models"
+	"github.com/ownode/services"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// number of attempts before a delivery is dead lettered
+	MaxWebhookAttempts = 10
+
+	// delay before the first retry. doubled after every failed attempt
+	WebhookRetryBase = 30 * time.Second
+
+	// maximum delay between retries
+	WebhookRetryMax = 6 * time.Hour
+
+	// time added to a claim on deliveries beyond the time delivering them can take
+	WebhookClaimMargin = time.Minute
+)
+
+type WebhookWorker struct {
+	db *services.DB
+	log *config.CustomLog
+	client *http.Client
+	Interval time.Duration
+	BatchSize int
+	stop chan struct{}
+	wg sync.WaitGroup
+	running int32
+}
+
+// create a webhook worker
+func NewWebhookWorker(db *services.DB, log *config.CustomLog) *WebhookWorker {
+	return &WebhookWorker{
+		db: db,
+		log: log,
+		client: &http.Client{ Timeout: 10 * time.Second, Transport: webhookTransport() },
+		Interval: 2 * time.Second,
+		BatchSize: 50,
+		stop: make(chan struct{}),
+	}
+}
+
+// transport of deliveries. Connections to loopback and private addresses are
+// refused after names are resolved, so endpoints cannot reach internal services
+func webhookTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{ Timeout: 10 * time.Second, Control: services.WebhookDialControl }).DialContext
+	return transport
+}
+
+// start delivering events in the background
+func (w *WebhookWorker) Start() {
+	atomic.StoreInt32(&w.running, 1)
+	w.wg.Add(1)
+	go w.run()
+}
+
+// stop the worker and wait for in flight deliveries to complete
+func (w *WebhookWorker) Stop() {
+	close(w.stop)
+	w.wg.Wait()
+}
+
+// check if the worker is delivering events
+func (w *WebhookWorker) Running() bool {
+	return atomic.LoadInt32(&w.running) == 1
+}
+
+func (w *WebhookWorker) run() {
+	defer w.wg.Done()
+	defer atomic.StoreInt32(&w.running, 0)
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			// keep delivering while there are due deliveries
+			for {
+				n, err := w.DeliverDue()
+				if err != nil {
+					w.log.Error("webhook worker: " + err.Error())
+				}
+				if err != nil || n < w.BatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// delay before the next attempt of a delivery that has failed `attempts` times
+func RetryDelay(attempts int) time.Duration {
+	delay := WebhookRetryBase
+	for i := 1; i < attempts && delay < WebhookRetryMax; i++ {
+		delay = delay * 2
+	}
+	if delay > WebhookRetryMax {
+		delay = WebhookRetryMax
+	}
+	return delay
+}
+
+// deliver due deliveries. Deliveries are claimed in a short transaction that
+// locks them, so other instances of the worker skip them, and moves their next
+// attempt past the time it takes to deliver them. They are then delivered without
+// holding locks or a connection and the result of each is saved.
+// A delivery whose result is not saved is attempted again once its claim expires.
+// returns the number of deliveries attempted
+func (w *WebhookWorker) DeliverDue() (int, error) {
+
+	deliveries, err := w.claimDue()
+	if err != nil || len(deliveries) == 0 {
+		return 0, err
+	}
+
+	dbCon := w.db.GetPostgresHandle()
+	endpoints := map[int64]models.WebhookEndpoint{}
+	for i := range deliveries {
+		delivery := &deliveries[i]
+
+		endpoint, found := endpoints[delivery.EndpointID.Int64]
+		if !found {
+			endpoint, found, err = models.FindWebhookEndpointById(dbCon, uint(delivery.EndpointID.Int64))
+			if err != nil {
+				return 0, err
+			}
+			endpoints[delivery.EndpointID.Int64] = endpoint
+		}
+
+		delivery.Attempts = delivery.Attempts + 1
+		if !found || endpoint.ID == 0 || !endpoint.Active {
+			delivery.Status = models.DeliveryDead
+			delivery.LastError = "endpoint was removed or disabled"
+		} else if err := w.deliver(*delivery, endpoint); err != nil {
+			delivery.LastError = err.Error()
+			if delivery.Attempts >= MaxWebhookAttempts {
+				delivery.Status = models.DeliveryDead
+			} else {
+				delivery.NextAttemptAt = time.Now().UTC().Add(RetryDelay(delivery.Attempts))
+			}
+		} else {
+			delivery.Status = models.DeliveryDelivered
+			delivery.LastError = ""
+		}
+	}
+
+	// results are saved one by one so a result that cannot be saved
+	// does not discard the others
+	err = nil
+	for i := range deliveries {
+		if saveErr := dbCon.Save(&deliveries[i]).Error; saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}
+	return len(deliveries), err
+}
+
+// claim due deliveries for as long as delivering all of them can take
+func (w *WebhookWorker) claimDue() ([]models.WebhookDelivery, error) {
+
+	now := time.Now().UTC()
+	dbTx := w.db.GetPostgresHandle().Begin()
+	deliveries, err := models.FindDueWebhookDeliveries(dbTx, now, w.BatchSize)
+	if err != nil || len(deliveries) == 0 {
+		dbTx.Rollback()
+		return nil, err
+	}
+
+	ids := []uint{}
+	for _, delivery := range deliveries {
+		ids = append(ids, delivery.ID)
+	}
+	claimedUntil := now.Add(w.client.Timeout * time.Duration(len(deliveries)) + WebhookClaimMargin)
+	if err := models.ClaimWebhookDeliveries(dbTx, ids, claimedUntil); err != nil {
+		dbTx.Rollback()
+		return nil, err
+	}
+	for i := range deliveries {
+		deliveries[i].NextAttemptAt = claimedUntil
+	}
+
+	return deliveries, dbTx.Commit().Error
+}
+
+// post a delivery's payload to an endpoint. Any non 2xx response is a failure
+func (w *WebhookWorker) deliver(delivery models.WebhookDelivery, endpoint models.WebhookEndpoint) error {
+
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequest("POST", endpoint.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Ownode-Event", delivery.Event)
+	req.Header.Set("Ownode-Delivery", delivery.ObjectID)
+	req.Header.Set(services.WebhookSignatureHeader, services.SignWebhookPayload(endpoint.Secret, time.Now().UTC().Unix(), payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return errors.New("request failed. reason: " + err.Error())
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
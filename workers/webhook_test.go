@@ -0,0 +1,26 @@
+package workers
+
+import (
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelay(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(RetryDelay(1), WebhookRetryBase, "first retry uses base delay")
+	assert.Equal(RetryDelay(3), WebhookRetryBase * 4, "delay doubles per attempt")
+	assert.Equal(RetryDelay(100), WebhookRetryMax, "delay is capped")
+	assert.True(RetryDelay(2) > time.Duration(0))
+}
+
+func TestWebhookWorkerRunning(t *testing.T) {
+	assert := assert.New(t)
+	w := NewWebhookWorker(nil, nil)
+	w.Interval = time.Hour
+	assert.False(w.Running())
+	w.Start()
+	assert.True(w.Running())
+	w.Stop()
+	assert.False(w.Running())
+}